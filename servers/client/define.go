@@ -33,8 +33,10 @@ type ServerConfig struct {
 
 // MediaServerConfig Media Server 설정
 type MediaServerConfig struct {
-	Address string `json:"address"`
-	Port    int    `json:"port"`
+	Address  string `json:"address"`
+	Port     int    `json:"port"`
+	Login    string `json:"login,omitempty"`    // media 서버 http_login (ptz 등 보호된 API)
+	Password string `json:"password,omitempty"` // media 서버 http_password
 }
 
 // TurnServerConfig TURN Server 설정
@@ -100,6 +100,9 @@ func (client *MediaServerClient) request(method, path string, body interface{},
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
+	if appConfig.Server.MediaServer.Login != "" {
+		req.SetBasicAuth(appConfig.Server.MediaServer.Login, appConfig.Server.MediaServer.Password)
+	}
 
 	apiClient := globalClientPool.Get().(*http.Client)
 	defer globalClientPool.Put(apiClient)
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -58,6 +59,8 @@ func handleGet(c *gin.Context) {
 			serveHTMLProxy(c)
 		case "resource":
 			serveResourceProxy(c)
+		case "ptz":
+			handlePTZ(c)
 		default:
 			c.JSON(http.StatusOK, gin.H{
 				"status":  "error",
@@ -73,6 +76,8 @@ func handlePost(c *gin.Context) {
 	if len(reqSlice) == 3 {
 		if reqSlice[2] == "signalling" {
 			handleSignalling(c)
+		} else if reqSlice[2] == "ptz" {
+			handlePTZ(c)
 		} else if reqSlice[2] == "resource" {
 			// serveFormSubmit(c) // setting 할거면 필요
 		}
@@ -122,6 +127,74 @@ func handleSignalling(c *gin.Context) {
 	io.Copy(c.Writer, resp.Body)
 }
 
+// handlePTZ multiview 에서 보내는 ptz 명령을 media 서버로 전달
+// /proxy/ptz?streamID=&channelID=&action=continuous|stop|absolute|relative|presets|goto|set[&preset=]
+func handlePTZ(c *gin.Context) {
+	query := c.Request.URL.Query()
+
+	streamID := query.Get("streamID")
+	channelID := query.Get("channelID")
+	if streamID == "" || channelID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": "streamID and channelID are required",
+		})
+		return
+	}
+
+	method := http.MethodPost
+	path := fmt.Sprintf("/stream/%s/channel/%s/ptz/", url.PathEscape(streamID), url.PathEscape(channelID))
+	switch action := query.Get("action"); action {
+	case "continuous", "stop", "absolute", "relative":
+		path += action
+	case "presets":
+		method = http.MethodGet
+		path += action
+	case "set":
+		path += "presets"
+	case "goto":
+		path += "presets/" + url.PathEscape(query.Get("preset")) + "/goto"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": fmt.Sprintf("ptz action %s not supported", action),
+		})
+		return
+	}
+
+	var body interface{}
+	if method == http.MethodPost {
+		data, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  "error",
+				"message": "failed to read request body",
+			})
+			return
+		}
+		if len(data) == 0 {
+			data = []byte("{}")
+		}
+		body = json.RawMessage(data)
+	}
+
+	resp, err := getMediaServerClient().request(method, path, body)
+	if err != nil {
+		log.Printf("Request failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "error",
+			"message": "failed to api request",
+		})
+		return
+	}
+	defer resp.Body.Close()
+
+	// media 서버 응답(에러 포함)을 그대로 전달
+	c.Header("Content-Type", resp.Header.Get("Content-Type"))
+	c.Status(resp.StatusCode)
+	io.Copy(c.Writer, resp.Body)
+}
+
 func getM3U8List(c *gin.Context) {
 	msclient := getMediaServerClient()
 	reqQuery := c.Request.URL.Query()
@@ -19,7 +19,7 @@ func HTTPAPIServerStreamChannelCodec(c *gin.Context) {
 		log.Printf("[ERROR] [http_stream] [HTTPAPIServerStreamChannelCodec] [StreamChannelCodec] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), err.Error())
 		return
 	}
-	c.IndentedJSON(200, Message{Status: 1, Payload: codecs})
+	c.IndentedJSON(200, Message{Status: 1, Payload: CodecInfo(codecs)})
 }
 
 // HTTPAPIServerStreamChannelInfo function return stream info struct
@@ -30,7 +30,7 @@ func HTTPAPIServerStreamChannelInfo(c *gin.Context) {
 		log.Printf("[ERROR] [http_stream] [HTTPAPIServerStreamChannelInfo] [StreamChannelInfo] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), err.Error())
 		return
 	}
-	c.IndentedJSON(200, Message{Status: 1, Payload: info})
+	c.IndentedJSON(200, Message{Status: 1, Payload: info.api()})
 }
 
 // HTTPAPIServerStreamChannelReload function reload stream
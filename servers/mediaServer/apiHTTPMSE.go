@@ -16,6 +16,12 @@ import (
 
 // HTTPAPIServerStreamMSE func
 func HTTPAPIServerStreamMSE(c *gin.Context) {
+	// limit 초과는 upgrade 전에 http 로 거부
+	if err := Storage.ClientLimit(c.Param("uuid"), c.Param("channel")); err != nil {
+		c.IndentedJSON(429, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_mse] [HTTPAPIServerStreamMSE] [ClientLimit] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), err.Error())
+		return
+	}
 	conn, _, _, err := ws.UpgradeHTTP(c.Request, c.Writer)
 	if err != nil {
 		return
@@ -44,9 +50,12 @@ func HTTPAPIServerStreamMSE(c *gin.Context) {
 		log.Printf("[ERROR] [http_mse] [HTTPAPIServerStreamMSE] [SetWriteDeadline] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), err.Error())
 		return
 	}
-	cid, ch, _, err := Storage.ClientAdd(c.Param("uuid"), c.Param("channel"), MSE)
+	cid, ch, _, err := Storage.ClientAdd(c.Param("uuid"), c.Param("channel"), MSE, c.ClientIP(), c.Query("token"), false)
 	if err != nil {
 		log.Printf("[ERROR] [http_mse] [HTTPAPIServerStreamMSE] [ClientAdd] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), err.Error())
+		if limitIsError(err) {
+			wsutil.WriteServerMessage(conn, ws.OpClose, ws.NewCloseFrameBody(ws.StatusPolicyViolation, err.Error()))
+		}
 		return
 	}
 	defer Storage.ClientDelete(c.Param("uuid"), cid, c.Param("channel"))
@@ -62,6 +71,10 @@ func HTTPAPIServerStreamMSE(c *gin.Context) {
 		return
 	}
 	meta, init := muxerMSE.GetInit(codecs)
+	// mp4f 는 h265 codec string 이 고정값, sps 기준 hvc1 문자열로 교체
+	if codecMeta := CodecMeta(codecs); codecMeta != "" {
+		meta = codecMeta
+	}
 	err = wsutil.WriteServerMessage(conn, ws.OpBinary, append([]byte{9}, meta...))
 	if err != nil {
 		log.Printf("[ERROR] [http_mse] [HTTPAPIServerStreamMSE] [Send] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), err.Error())
@@ -122,7 +135,11 @@ func HTTPAPIServerStreamMSE(c *gin.Context) {
 		case <-noVideo.C:
 			log.Printf("[ERROR] [http_mse] [HTTPAPIServerStreamMSE] [ErrorStreamNoVideo] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), ErrorStreamNoVideo.Error())
 			return
-		case pck := <-ch:
+		case pck, ok := <-ch:
+			if !ok {
+				log.Printf("[ERROR] [http_mse] [HTTPAPIServerStreamMSE] [ErrorClientSlow] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), ErrorClientSlow.Error())
+				return
+			}
 			if pck.IsKeyFrame {
 				noVideo.Reset(10 * time.Second)
 				videoStart = true
@@ -147,6 +164,7 @@ func HTTPAPIServerStreamMSE(c *gin.Context) {
 					log.Printf("[ERROR] [http_mse] [HTTPAPIServerStreamMSE] [Send] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), err.Error())
 					return
 				}
+				Sessions.Sent(cid, len(buf))
 			}
 		}
 	}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ONVIFRequestST onvif device credentials request
+type ONVIFRequestST struct {
+	XAddr    string `json:"xaddr" binding:"required"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// ONVIFStreamAddST create stream from onvif profiles request
+type ONVIFStreamAddST struct {
+	ONVIFRequestST
+	Name     string   `json:"name"`
+	OnDemand bool     `json:"on_demand"`
+	Audio    bool     `json:"audio"`
+	Debug    bool     `json:"debug"`
+	Profiles []string `json:"profiles"` // 비어있으면 전체 profile, 순서대로 channel 0, 1 ...
+}
+
+// HTTPAPIServerONVIFDiscovery ws-discovery on local subnet
+func HTTPAPIServerONVIFDiscovery(c *gin.Context) {
+	timeout := 3 * time.Second
+	if seconds, err := strconv.Atoi(c.Query("timeout")); err == nil && seconds > 0 && seconds <= 30 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+	devices, err := ONVIFDiscovery(timeout)
+	if err != nil {
+		c.IndentedJSON(500, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_onvif] [HTTPAPIServerONVIFDiscovery] [ONVIFDiscovery] %s", err.Error())
+		return
+	}
+	c.IndentedJSON(200, Message{Status: 1, Payload: devices})
+}
+
+// HTTPAPIServerONVIFProfiles GetProfiles + GetStreamUri of device
+func HTTPAPIServerONVIFProfiles(c *gin.Context) {
+	var payload ONVIFRequestST
+	if err := c.BindJSON(&payload); err != nil {
+		c.IndentedJSON(400, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_onvif] [HTTPAPIServerONVIFProfiles] [BindJSON] %s", err.Error())
+		return
+	}
+	client, err := NewONVIFClient(payload.XAddr, payload.Username, payload.Password)
+	if err != nil {
+		c.IndentedJSON(502, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_onvif] [HTTPAPIServerONVIFProfiles] [NewONVIFClient] xaddr=%s: %s", payload.XAddr, err.Error())
+		return
+	}
+	profiles, err := client.Profiles()
+	if err != nil {
+		c.IndentedJSON(502, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_onvif] [HTTPAPIServerONVIFProfiles] [Profiles] xaddr=%s: %s", payload.XAddr, err.Error())
+		return
+	}
+	c.IndentedJSON(200, Message{Status: 1, Payload: profiles})
+}
+
+// HTTPAPIServerONVIFStreamAdd add stream with one channel per onvif profile
+func HTTPAPIServerONVIFStreamAdd(c *gin.Context) {
+	var payload ONVIFStreamAddST
+	if err := c.BindJSON(&payload); err != nil {
+		c.IndentedJSON(400, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_onvif] [HTTPAPIServerONVIFStreamAdd] [BindJSON] %s", err.Error())
+		return
+	}
+	client, err := NewONVIFClient(payload.XAddr, payload.Username, payload.Password)
+	if err != nil {
+		c.IndentedJSON(502, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_onvif] [HTTPAPIServerONVIFStreamAdd] [NewONVIFClient] xaddr=%s: %s", payload.XAddr, err.Error())
+		return
+	}
+	profiles, err := client.Profiles()
+	if err != nil {
+		c.IndentedJSON(502, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_onvif] [HTTPAPIServerONVIFStreamAdd] [Profiles] xaddr=%s: %s", payload.XAddr, err.Error())
+		return
+	}
+	if len(payload.Profiles) > 0 {
+		byToken := make(map[string]ONVIFProfile)
+		for _, profile := range profiles {
+			byToken[profile.Token] = profile
+		}
+		profiles = profiles[:0]
+		for _, token := range payload.Profiles {
+			if profile, ok := byToken[token]; ok {
+				profiles = append(profiles, profile)
+			}
+		}
+	}
+	if len(profiles) == 0 {
+		c.IndentedJSON(400, Message{Status: 0, Payload: ErrorStreamsLen0.Error()})
+		return
+	}
+	stream := StreamST{Name: payload.Name, Channels: make(map[string]ChannelST)}
+	for i, profile := range profiles {
+		stream.Channels[strconv.Itoa(i)] = ChannelST{
+			Name:     profile.Name,
+			URL:      client.StreamURIWithCredentials(profile.StreamURI),
+			OnDemand: payload.OnDemand,
+			Audio:    payload.Audio,
+			Debug:    payload.Debug,
+			ONVIF: &ChannelONVIFST{
+				XAddr:        payload.XAddr,
+				Username:     payload.Username,
+				Password:     payload.Password,
+				ProfileToken: profile.Token,
+			},
+		}
+	}
+	if err = Storage.StreamAdd(c.Param("uuid"), stream); err != nil {
+		c.IndentedJSON(500, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_onvif] [HTTPAPIServerONVIFStreamAdd] [StreamAdd] stream=%s: %s", c.Param("uuid"), err.Error())
+		return
+	}
+	c.IndentedJSON(200, Message{Status: 1, Payload: profiles})
+}
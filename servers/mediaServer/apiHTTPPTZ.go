@@ -0,0 +1,163 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PTZMoveST ptz move request, continuous 는 velocity(-1 ~ 1), timeout 초
+type PTZMoveST struct {
+	ONVIFVector
+	Timeout float64 `json:"timeout"`
+}
+
+// PTZPresetST ptz preset set request, token 이 있으면 덮어쓰기
+type PTZPresetST struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+}
+
+// ptzClients device 별 onvif client (time offset, capabilities 재사용)
+var ptzClients = struct {
+	mutex   sync.Mutex
+	clients map[ChannelONVIFST]*ONVIFClient
+}{clients: make(map[ChannelONVIFST]*ONVIFClient)}
+
+// ptzChannel onvif client and profile token of stream channel
+func ptzChannel(c *gin.Context) (*ONVIFClient, string, bool) {
+	channel, err := Storage.StreamChannelInfo(c.Param("uuid"), c.Param("channel"))
+	if err != nil {
+		c.IndentedJSON(404, Message{Status: 0, Payload: err.Error()})
+		return nil, "", false
+	}
+	if channel.ONVIF == nil || channel.ONVIF.XAddr == "" {
+		c.IndentedJSON(400, Message{Status: 0, Payload: ErrorONVIFNotConfigured.Error()})
+		return nil, "", false
+	}
+	device := *channel.ONVIF
+	device.ProfileToken = ""
+	ptzClients.mutex.Lock()
+	client, ok := ptzClients.clients[device]
+	ptzClients.mutex.Unlock()
+	if !ok {
+		if client, err = NewONVIFClient(device.XAddr, device.Username, device.Password); err != nil {
+			c.IndentedJSON(502, Message{Status: 0, Payload: err.Error()})
+			log.Printf("[ERROR] [http_ptz] [ptzChannel] [NewONVIFClient] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), err.Error())
+			return nil, "", false
+		}
+		ptzClients.mutex.Lock()
+		ptzClients.clients[device] = client
+		ptzClients.mutex.Unlock()
+	}
+	return client, channel.ONVIF.ProfileToken, true
+}
+
+// ptzResult write ptz call result, 실패한 client 는 다음 요청에서 다시 연결
+func ptzResult(c *gin.Context, function string, client *ONVIFClient, err error, payload interface{}) {
+	if err != nil {
+		ptzClients.mutex.Lock()
+		for device, cached := range ptzClients.clients {
+			if cached == client {
+				delete(ptzClients.clients, device)
+			}
+		}
+		ptzClients.mutex.Unlock()
+		c.IndentedJSON(502, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_ptz] [%s] stream=%s channel=%s: %s", function, c.Param("uuid"), c.Param("channel"), err.Error())
+		return
+	}
+	c.IndentedJSON(200, Message{Status: 1, Payload: payload})
+}
+
+// HTTPAPIServerStreamChannelPTZContinuous start continuous move
+func HTTPAPIServerStreamChannelPTZContinuous(c *gin.Context) {
+	var payload PTZMoveST
+	if err := c.BindJSON(&payload); err != nil {
+		c.IndentedJSON(400, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_ptz] [HTTPAPIServerStreamChannelPTZContinuous] [BindJSON] %s", err.Error())
+		return
+	}
+	client, profileToken, ok := ptzChannel(c)
+	if !ok {
+		return
+	}
+	err := client.ContinuousMove(profileToken, payload.ONVIFVector, time.Duration(payload.Timeout*float64(time.Second)))
+	ptzResult(c, "HTTPAPIServerStreamChannelPTZContinuous", client, err, Success)
+}
+
+// HTTPAPIServerStreamChannelPTZStop stop pan tilt zoom
+func HTTPAPIServerStreamChannelPTZStop(c *gin.Context) {
+	client, profileToken, ok := ptzChannel(c)
+	if !ok {
+		return
+	}
+	ptzResult(c, "HTTPAPIServerStreamChannelPTZStop", client, client.Stop(profileToken), Success)
+}
+
+// HTTPAPIServerStreamChannelPTZAbsolute move to absolute position
+func HTTPAPIServerStreamChannelPTZAbsolute(c *gin.Context) {
+	var payload ONVIFVector
+	if err := c.BindJSON(&payload); err != nil {
+		c.IndentedJSON(400, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_ptz] [HTTPAPIServerStreamChannelPTZAbsolute] [BindJSON] %s", err.Error())
+		return
+	}
+	client, profileToken, ok := ptzChannel(c)
+	if !ok {
+		return
+	}
+	ptzResult(c, "HTTPAPIServerStreamChannelPTZAbsolute", client, client.AbsoluteMove(profileToken, payload), Success)
+}
+
+// HTTPAPIServerStreamChannelPTZRelative move by relative translation
+func HTTPAPIServerStreamChannelPTZRelative(c *gin.Context) {
+	var payload ONVIFVector
+	if err := c.BindJSON(&payload); err != nil {
+		c.IndentedJSON(400, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_ptz] [HTTPAPIServerStreamChannelPTZRelative] [BindJSON] %s", err.Error())
+		return
+	}
+	client, profileToken, ok := ptzChannel(c)
+	if !ok {
+		return
+	}
+	ptzResult(c, "HTTPAPIServerStreamChannelPTZRelative", client, client.RelativeMove(profileToken, payload), Success)
+}
+
+// HTTPAPIServerStreamChannelPTZPresets list presets
+func HTTPAPIServerStreamChannelPTZPresets(c *gin.Context) {
+	client, profileToken, ok := ptzChannel(c)
+	if !ok {
+		return
+	}
+	presets, err := client.Presets(profileToken)
+	ptzResult(c, "HTTPAPIServerStreamChannelPTZPresets", client, err, presets)
+}
+
+// HTTPAPIServerStreamChannelPTZPresetSet save current position as preset
+func HTTPAPIServerStreamChannelPTZPresetSet(c *gin.Context) {
+	var payload PTZPresetST
+	if err := c.BindJSON(&payload); err != nil {
+		c.IndentedJSON(400, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_ptz] [HTTPAPIServerStreamChannelPTZPresetSet] [BindJSON] %s", err.Error())
+		return
+	}
+	client, profileToken, ok := ptzChannel(c)
+	if !ok {
+		return
+	}
+	token, err := client.SetPreset(profileToken, payload.Name, payload.Token)
+	ptzResult(c, "HTTPAPIServerStreamChannelPTZPresetSet", client, err, ONVIFPreset{Token: token, Name: payload.Name})
+}
+
+// HTTPAPIServerStreamChannelPTZPresetGoto move to preset
+func HTTPAPIServerStreamChannelPTZPresetGoto(c *gin.Context) {
+	client, profileToken, ok := ptzChannel(c)
+	if !ok {
+		return
+	}
+	ptzResult(c, "HTTPAPIServerStreamChannelPTZPresetGoto", client, client.GotoPreset(profileToken, c.Param("preset")), Success)
+}
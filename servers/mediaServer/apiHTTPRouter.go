@@ -32,7 +32,13 @@ func HTTPAPIServer() {
 		public = gin.Default()
 	}
 
+	// client ip (session remote, hls session) 는 설정된 proxy 의 X-Forwarded-For 만 믿는다
+	if err := public.SetTrustedProxies(Storage.ServerTrustedProxies()); err != nil {
+		log.Printf("[ERROR] [http_server] [HTTPAPIServer] [SetTrustedProxies] %s", err.Error())
+	}
 	public.Use(CrossOrigin())
+	// rtsp over http tunnel (x-sessioncookie GET / POST)
+	public.Use(RTSPTunnel())
 	//Add private login password protect methods
 	privat := public.Group("/")
 	if Storage.ServerHTTPLogin() != "" && Storage.ServerHTTPPassword() != "" {
@@ -97,6 +103,24 @@ func HTTPAPIServer() {
 	public.GET("/stream/:uuid/channel/:channel/reload", HTTPAPIServerStreamChannelReload)
 	public.GET("/stream/:uuid/channel/:channel/info", HTTPAPIServerStreamChannelInfo)
 
+	// ONVIF 카메라 검색, profile 로 stream 추가
+	privat.GET("/onvif/discovery", HTTPAPIServerONVIFDiscovery)
+	privat.POST("/onvif/profiles", HTTPAPIServerONVIFProfiles)
+	privat.POST("/onvif/stream/:uuid/add", HTTPAPIServerONVIFStreamAdd)
+
+	// ONVIF PTZ 제어 (channel 에 저장된 onvif 계정 사용)
+	privat.POST("/stream/:uuid/channel/:channel/ptz/continuous", HTTPAPIServerStreamChannelPTZContinuous)
+	privat.POST("/stream/:uuid/channel/:channel/ptz/stop", HTTPAPIServerStreamChannelPTZStop)
+	privat.POST("/stream/:uuid/channel/:channel/ptz/absolute", HTTPAPIServerStreamChannelPTZAbsolute)
+	privat.POST("/stream/:uuid/channel/:channel/ptz/relative", HTTPAPIServerStreamChannelPTZRelative)
+	privat.GET("/stream/:uuid/channel/:channel/ptz/presets", HTTPAPIServerStreamChannelPTZPresets)
+	privat.POST("/stream/:uuid/channel/:channel/ptz/presets", HTTPAPIServerStreamChannelPTZPresetSet)
+	privat.POST("/stream/:uuid/channel/:channel/ptz/presets/:preset/goto", HTTPAPIServerStreamChannelPTZPresetGoto)
+
+	// 시청 세션 조회, 강제 종료
+	privat.GET("/api/sessions", HTTPAPIServerSessions)
+	privat.DELETE("/api/sessions/:id", HTTPAPIServerSessionDelete)
+
 	// server edit API 추가
 	privat.POST("/server/edit", HTTPAPIServerEdit)
 	public.POST("/pages/settings", HTTPAPIServerSettingsUpdate)
@@ -106,21 +130,28 @@ func HTTPAPIServer() {
 		Stream video elements
 	*/
 	//HLS
-	public.GET("/stream/:uuid/channel/:channel/hls/live/index.m3u8", HTTPAPIServerStreamHLSM3U8)
-	public.GET("/stream/:uuid/channel/:channel/hls/live/segment/:seq/file.ts", HTTPAPIServerStreamHLSTS)
+	public.GET("/stream/:uuid/channel/:channel/hls/live/index.m3u8", HTTPAPIServerSessionHLS, HTTPAPIServerStreamHLSM3U8)
+	public.GET("/stream/:uuid/channel/:channel/hls/live/segment/:seq/file.ts", HTTPAPIServerSessionHLS, HTTPAPIServerStreamHLSTS)
 	//HLS remote record
 	//public.GET("/stream/:uuid/channel/:channel/hls/rr/:s/:e/index.m3u8", HTTPAPIServerStreamRRM3U8)
 	//public.GET("/stream/:uuid/channel/:channel/hls/rr/:s/:e/:seq/file.ts", HTTPAPIServerStreamRRTS)
 	//HLS LL
-	public.GET("/stream/:uuid/channel/:channel/hlsll/live/index.m3u8", HTTPAPIServerStreamHLSLLM3U8)
-	public.GET("/stream/:uuid/channel/:channel/hlsll/live/init.mp4", HTTPAPIServerStreamHLSLLInit)
-	public.GET("/stream/:uuid/channel/:channel/hlsll/live/segment/:segment/:any", HTTPAPIServerStreamHLSLLM4Segment)
-	public.GET("/stream/:uuid/channel/:channel/hlsll/live/fragment/:segment/:fragment/:any", HTTPAPIServerStreamHLSLLM4Fragment)
+	public.GET("/stream/:uuid/channel/:channel/hlsll/live/index.m3u8", HTTPAPIServerSessionHLS, HTTPAPIServerStreamHLSLLM3U8)
+	public.GET("/stream/:uuid/channel/:channel/hlsll/live/init.mp4", HTTPAPIServerSessionHLS, HTTPAPIServerStreamHLSLLInit)
+	public.GET("/stream/:uuid/channel/:channel/hlsll/live/segment/:segment/:any", HTTPAPIServerSessionHLS, HTTPAPIServerStreamHLSLLM4Segment)
+	public.GET("/stream/:uuid/channel/:channel/hlsll/live/fragment/:segment/:fragment/:any", HTTPAPIServerSessionHLS, HTTPAPIServerStreamHLSLLM4Fragment)
 	//MSE
 	public.GET("/stream/:uuid/channel/:channel/mse", HTTPAPIServerStreamMSE)
 
 	// WebRTC
 	public.POST("/stream/:uuid/channel/:channel/webrtc", HTTPAPIServerStreamWebRTC)
+	// WHIP publish (push 채널)
+	public.POST("/whip/:uuid/:channel", HTTPAPIServerWHIP)
+	public.DELETE("/whip/:uuid/:channel/:session", HTTPAPIServerWHIPDelete)
+	// WHEP playback (trickle ice PATCH)
+	public.POST("/whep/:uuid/:channel", HTTPAPIServerWHEP)
+	public.PATCH("/whep/:uuid/:channel/:session", HTTPAPIServerWHEPPatch)
+	public.DELETE("/whep/:uuid/:channel/:session", HTTPAPIServerWHEPDelete)
 	//Save fragment to mp4
 	public.GET("/stream/:uuid/channel/:channel/save/mp4/fragment/:duration", HTTPAPIServerStreamSaveToMP4)
 
@@ -441,8 +472,9 @@ func CrossOrigin() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, If-Match")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Location, ETag, Accept-Patch")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
 			return
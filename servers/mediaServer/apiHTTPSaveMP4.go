@@ -30,10 +30,14 @@ func HTTPAPIServerStreamSaveToMP4(c *gin.Context) {
 		log.Printf("[ERROR] [http_save_mp4] [HTTPAPIServerStreamSaveToMP4] [RemoteAuthorization] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), ErrorStreamUnauthorized.Error())
 		return
 	}
+	if err = Storage.ClientLimit(c.Param("uuid"), c.Param("channel")); err != nil {
+		c.IndentedJSON(429, Message{Status: 0, Payload: err.Error()})
+		return
+	}
 	c.Writer.Write([]byte("await save started"))
 	go func() {
 		Storage.StreamChannelRun(c.Param("uuid"), c.Param("channel"))
-		cid, ch, _, err := Storage.ClientAdd(c.Param("uuid"), c.Param("channel"), MSE)
+		cid, ch, _, err := Storage.ClientAdd(c.Param("uuid"), c.Param("channel"), MSE, c.ClientIP(), c.Query("token"), false)
 		if err != nil {
 			log.Printf("[ERROR] [http_save_mp4] [HTTPAPIServerStreamSaveToMP4] [ClientAdd] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), err.Error())
 			return
@@ -83,7 +87,11 @@ func HTTPAPIServerStreamSaveToMP4(c *gin.Context) {
 			case <-noVideo.C:
 				log.Printf("[ERROR] [http_save_mp4] [HTTPAPIServerStreamSaveToMP4] [ErrorStreamNoVideo] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), ErrorStreamNoVideo.Error())
 				return
-			case pck := <-ch:
+			case pck, ok := <-ch:
+				if !ok {
+					log.Printf("[ERROR] [http_save_mp4] [HTTPAPIServerStreamSaveToMP4] [ErrorClientSlow] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), ErrorClientSlow.Error())
+					return
+				}
 				if pck.IsKeyFrame {
 					noVideo.Reset(10 * time.Second)
 					videoStart = true
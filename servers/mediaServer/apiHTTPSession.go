@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HTTPAPIServerSessions list viewer sessions (?stream=&channel=&protocol=&remote=&user=)
+func HTTPAPIServerSessions(c *gin.Context) {
+	var filter SessionFilterST
+	if err := c.BindQuery(&filter); err != nil {
+		c.IndentedJSON(400, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_session] [HTTPAPIServerSessions] [BindQuery] %s", err.Error())
+		return
+	}
+	c.IndentedJSON(200, Message{Status: 1, Payload: Sessions.List(filter)})
+}
+
+// HTTPAPIServerSessionDelete kick viewer
+func HTTPAPIServerSessionDelete(c *gin.Context) {
+	if err := Sessions.Kick(c.Param("id")); err != nil {
+		c.IndentedJSON(404, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_session] [HTTPAPIServerSessionDelete] [Kick] session=%s: %s", c.Param("id"), err.Error())
+		return
+	}
+	log.Printf("[INFO] [http_session] [HTTPAPIServerSessionDelete] Session kicked: session=%s", c.Param("id"))
+	c.IndentedJSON(200, Message{Status: 1, Payload: Success})
+}
+
+// HTTPAPIServerSessionHLS hls route middleware, track session, refuse kicked viewer and new viewer over limits
+func HTTPAPIServerSessionHLS(c *gin.Context) {
+	if !Sessions.HLSActive(c.Param("uuid"), c.Param("channel"), c.ClientIP()) {
+		if err := Storage.ClientLimit(c.Param("uuid"), c.Param("channel")); err != nil {
+			c.AbortWithStatusJSON(429, Message{Status: 0, Payload: err.Error()})
+			log.Printf("[ERROR] [http_session] [HTTPAPIServerSessionHLS] [ClientLimit] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), err.Error())
+			return
+		}
+	}
+	id, ok := Sessions.HLS(c.Param("uuid"), c.Param("channel"), c.ClientIP(), c.Query("token"))
+	if !ok {
+		c.AbortWithStatusJSON(403, Message{Status: 0, Payload: ErrorSessionKicked.Error()})
+		return
+	}
+	c.Next()
+	Sessions.Sent(id, c.Writer.Size())
+}
@@ -151,5 +151,5 @@ func HTTPAPIServerStreamInfo(c *gin.Context) {
 		log.Printf("[ERROR] [http_stream] [HTTPAPIServerStreamInfo] [StreamInfo] stream=%s: %s", c.Param("uuid"), err.Error())
 		return
 	}
-	c.IndentedJSON(200, Message{Status: 1, Payload: info})
+	c.IndentedJSON(200, Message{Status: 1, Payload: info.api()})
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io"
+	"log"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HTTPAPIServerWHEP WHEP playback (POST application/sdp offer, 201 answer)
+// token 은 query 또는 Authorization: Bearer <token>
+func HTTPAPIServerWHEP(c *gin.Context) {
+	streamID, channelID := c.Param("uuid"), c.Param("channel")
+	if !Storage.StreamChannelExist(streamID, channelID) {
+		c.IndentedJSON(404, Message{Status: 0, Payload: ErrorStreamNotFound.Error()})
+		log.Printf("[ERROR] [http_whep] [HTTPAPIServerWHEP] [StreamChannelExist] stream=%s channel=%s: %s", streamID, channelID, ErrorStreamNotFound.Error())
+		return
+	}
+	token := c.Query("token")
+	if token == "" {
+		token = bearerToken(c.GetHeader("Authorization"))
+	}
+	if !RemoteAuthorization("WebRTC", streamID, channelID, token, c.ClientIP()) {
+		c.Header("WWW-Authenticate", "Bearer")
+		c.IndentedJSON(401, Message{Status: 0, Payload: ErrorStreamUnauthorized.Error()})
+		log.Printf("[ERROR] [http_whep] [HTTPAPIServerWHEP] [RemoteAuthorization] stream=%s channel=%s: %s", streamID, channelID, ErrorStreamUnauthorized.Error())
+		return
+	}
+	if !strings.HasPrefix(c.ContentType(), "application/sdp") {
+		c.IndentedJSON(415, Message{Status: 0, Payload: "content type must be application/sdp"})
+		return
+	}
+	offer, err := io.ReadAll(c.Request.Body)
+	if err != nil || len(offer) == 0 {
+		c.IndentedJSON(400, Message{Status: 0, Payload: "sdp offer empty"})
+		return
+	}
+	Storage.StreamChannelRun(streamID, channelID)
+	codecs, err := Storage.StreamChannelCodecs(streamID, channelID)
+	if err != nil {
+		c.IndentedJSON(500, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_whep] [HTTPAPIServerWHEP] [StreamCodecs] stream=%s channel=%s: %s", streamID, channelID, err.Error())
+		return
+	}
+	if CodecHasH265(codecs) && !Storage.ServerWebRTCTranscode() {
+		c.IndentedJSON(415, Message{Status: 0, Payload: gin.H{"error": ErrorStreamCodecWebRTC.Error(), "codecs": CodecInfo(codecs)}})
+		log.Printf("[ERROR] [http_whep] [HTTPAPIServerWHEP] [CodecHasH265] stream=%s channel=%s: %s", streamID, channelID, ErrorStreamCodecWebRTC.Error())
+		return
+	}
+	// 실제 client 등록은 ice 연결 후, 여기서는 limit 만 확인
+	if err = Storage.ClientLimit(streamID, channelID); err != nil {
+		c.IndentedJSON(429, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_whep] [HTTPAPIServerWHEP] [ClientLimit] stream=%s channel=%s: %s", streamID, channelID, err.Error())
+		return
+	}
+	session, answer, err := NewWebRTCSession(streamID, channelID, codecs, string(offer), c.ClientIP(), token, webrtcTrickleGather)
+	if err != nil {
+		c.IndentedJSON(400, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_whep] [HTTPAPIServerWHEP] [NewWebRTCSession] stream=%s channel=%s: %s", streamID, channelID, err.Error())
+		return
+	}
+	c.Header("Location", "/whep/"+streamID+"/"+channelID+"/"+session.ID)
+	c.Header("ETag", "\""+session.ID+"\"")
+	c.Header("Accept-Patch", "application/trickle-ice-sdpfrag")
+	c.Data(201, "application/sdp", []byte(answer))
+}
+
+// HTTPAPIServerWHEPPatch WHEP trickle ice (application/trickle-ice-sdpfrag)
+// 응답 body 는 answer 이후 gather 된 server candidate, 없으면 204
+func HTTPAPIServerWHEPPatch(c *gin.Context) {
+	session, ok := whepSession(c)
+	if !ok {
+		return
+	}
+	if match := c.GetHeader("If-Match"); match != "" && match != "*" && match != "\""+session.ID+"\"" {
+		c.IndentedJSON(412, Message{Status: 0, Payload: "etag mismatch"})
+		return
+	}
+	if !strings.HasPrefix(c.ContentType(), "application/trickle-ice-sdpfrag") {
+		c.IndentedJSON(415, Message{Status: 0, Payload: "content type must be application/trickle-ice-sdpfrag"})
+		return
+	}
+	fragment, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.IndentedJSON(400, Message{Status: 0, Payload: err.Error()})
+		return
+	}
+	local, err := session.Trickle(string(fragment))
+	if err == ErrorWHEPICERestart {
+		c.IndentedJSON(501, Message{Status: 0, Payload: err.Error()})
+		return
+	} else if err != nil {
+		c.IndentedJSON(400, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_whep] [HTTPAPIServerWHEPPatch] [Trickle] stream=%s channel=%s session=%s: %s", session.StreamID, session.ChannelID, session.ID, err.Error())
+		return
+	}
+	if local == "" {
+		c.Status(204)
+		return
+	}
+	c.Header("ETag", "\""+session.ID+"\"")
+	c.Data(200, "application/trickle-ice-sdpfrag", []byte(local))
+}
+
+// HTTPAPIServerWHEPDelete WHEP session teardown
+func HTTPAPIServerWHEPDelete(c *gin.Context) {
+	session, ok := whepSession(c)
+	if !ok {
+		return
+	}
+	session.Close()
+	c.Status(200)
+}
+
+// whepSession session of url, session id 가 resource 인증 역할
+func whepSession(c *gin.Context) (*WebRTCSession, bool) {
+	session, ok := WebRTCSessionFind(c.Param("session"))
+	if !ok || session.StreamID != c.Param("uuid") || session.ChannelID != c.Param("channel") {
+		c.IndentedJSON(404, Message{Status: 0, Payload: ErrorStreamNotFound.Error()})
+		return nil, false
+	}
+	return session, true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/subtle"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HTTPAPIServerWHIP WHIP publish (POST application/sdp offer, 201 answer)
+// push 채널에만 publish 가능, Authorization: Bearer <stream_key>
+func HTTPAPIServerWHIP(c *gin.Context) {
+	streamID, channelID := c.Param("uuid"), c.Param("channel")
+	opt, err := Storage.StreamChannelControl(streamID, channelID)
+	if err != nil {
+		c.IndentedJSON(404, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_whip] [HTTPAPIServerWHIP] [StreamChannelControl] stream=%s channel=%s: %s", streamID, channelID, err.Error())
+		return
+	}
+	if !opt.Push {
+		c.IndentedJSON(400, Message{Status: 0, Payload: ErrorStreamChannelNotPush.Error()})
+		log.Printf("[ERROR] [http_whip] [HTTPAPIServerWHIP] [Push] stream=%s channel=%s: %s", streamID, channelID, ErrorStreamChannelNotPush.Error())
+		return
+	}
+	if !whipAuthorization(c, opt) {
+		c.Header("WWW-Authenticate", "Bearer")
+		c.IndentedJSON(401, Message{Status: 0, Payload: ErrorStreamUnauthorized.Error()})
+		log.Printf("[ERROR] [http_whip] [HTTPAPIServerWHIP] [Authorization] stream=%s channel=%s remote=%s: %s", streamID, channelID, c.ClientIP(), ErrorStreamUnauthorized.Error())
+		return
+	}
+	if !strings.HasPrefix(c.ContentType(), "application/sdp") {
+		c.IndentedJSON(415, Message{Status: 0, Payload: "content type must be application/sdp"})
+		return
+	}
+	offer, err := io.ReadAll(c.Request.Body)
+	if err != nil || len(offer) == 0 {
+		c.IndentedJSON(400, Message{Status: 0, Payload: "sdp offer empty"})
+		return
+	}
+	source, answer, err := NewSourceWHIP(streamID, channelID, string(offer))
+	if err != nil {
+		c.IndentedJSON(400, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_whip] [HTTPAPIServerWHIP] [NewSourceWHIP] stream=%s channel=%s: %s", streamID, channelID, err.Error())
+		return
+	}
+	if err = SourcePushPublish(streamID, channelID, source); err != nil {
+		source.Close()
+		c.IndentedJSON(409, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_whip] [HTTPAPIServerWHIP] [SourcePushPublish] stream=%s channel=%s: %s", streamID, channelID, err.Error())
+		return
+	}
+	log.Printf("[INFO] [http_whip] [HTTPAPIServerWHIP] publish start: stream=%s channel=%s session=%s remote=%s", streamID, channelID, source.ID, c.ClientIP())
+	c.Header("Location", "/whip/"+streamID+"/"+channelID+"/"+source.ID)
+	c.Header("ETag", "\""+source.ID+"\"")
+	c.Data(201, "application/sdp", []byte(answer))
+}
+
+// HTTPAPIServerWHIPDelete WHIP session teardown
+func HTTPAPIServerWHIPDelete(c *gin.Context) {
+	source, ok := SourceWHIPSession(c.Param("session"))
+	if !ok || source.StreamID != c.Param("uuid") || source.ChannelID != c.Param("channel") {
+		c.IndentedJSON(404, Message{Status: 0, Payload: ErrorStreamNotFound.Error()})
+		return
+	}
+	// channel 이 지워졌으면 stream key 를 확인할 수 없으므로 거부
+	opt, err := Storage.StreamChannelControl(source.StreamID, source.ChannelID)
+	if err != nil {
+		c.IndentedJSON(404, Message{Status: 0, Payload: err.Error()})
+		log.Printf("[ERROR] [http_whip] [HTTPAPIServerWHIPDelete] [StreamChannelControl] stream=%s channel=%s: %s", source.StreamID, source.ChannelID, err.Error())
+		return
+	}
+	if !whipAuthorization(c, opt) {
+		c.Header("WWW-Authenticate", "Bearer")
+		c.IndentedJSON(401, Message{Status: 0, Payload: ErrorStreamUnauthorized.Error()})
+		return
+	}
+	// peer 종료 후 core loop 에 stop signal
+	source.Close()
+	source.stop()
+	log.Printf("[INFO] [http_whip] [HTTPAPIServerWHIPDelete] publish stop: stream=%s channel=%s session=%s", source.StreamID, source.ChannelID, source.ID)
+	c.Status(200)
+}
+
+// whipAuthorization bearer token must match channel stream key
+func whipAuthorization(c *gin.Context, opt *ChannelST) bool {
+	if opt.StreamKey == "" {
+		return false
+	}
+	token := bearerToken(c.GetHeader("Authorization"))
+	return subtle.ConstantTimeCompare([]byte(token), []byte(opt.StreamKey)) == 1
+}
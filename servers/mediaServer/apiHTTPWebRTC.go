@@ -7,37 +7,40 @@ import (
 
 	"log"
 
+	"github.com/deepch/vdk/av"
 	webrtc "github.com/deepch/vdk/format/webrtcv3"
 	"github.com/gin-gonic/gin"
 )
 
 // HTTPAPIServerStreamWebRTC stream video over WebRTC
 func HTTPAPIServerStreamWebRTC(c *gin.Context) {
-	if !Storage.StreamChannelExist(c.Param("uuid"), c.Param("channel")) {
+	// handler 가 끝나면 gin context 는 재사용되므로 goroutine 에서 쓸 값은 미리 꺼낸다
+	streamID, channelID := c.Param("uuid"), c.Param("channel")
+	if !Storage.StreamChannelExist(streamID, channelID) {
 		c.IndentedJSON(500, Message{Status: 0, Payload: ErrorStreamNotFound.Error()})
-		log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [StreamChannelExist] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), ErrorStreamNotFound.Error())
+		log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [StreamChannelExist] stream=%s channel=%s: %s", streamID, channelID, ErrorStreamNotFound.Error())
 		return
 	}
 
-	if !RemoteAuthorization("WebRTC", c.Param("uuid"), c.Param("channel"), c.Query("token"), c.ClientIP()) {
-		log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [RemoteAuthorization] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), ErrorStreamUnauthorized.Error())
+	if !RemoteAuthorization("WebRTC", streamID, channelID, c.Query("token"), c.ClientIP()) {
+		log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [RemoteAuthorization] stream=%s channel=%s: %s", streamID, channelID, ErrorStreamUnauthorized.Error())
 		return
 	}
 
-	Storage.StreamChannelRun(c.Param("uuid"), c.Param("channel"))
-	codecs, err := Storage.StreamChannelCodecs(c.Param("uuid"), c.Param("channel"))
+	Storage.StreamChannelRun(streamID, channelID)
+	codecs, err := Storage.StreamChannelCodecs(streamID, channelID)
 	if err != nil {
 		c.IndentedJSON(500, Message{Status: 0, Payload: err.Error()})
-		log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [StreamCodecs] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), err.Error())
+		log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [StreamCodecs] stream=%s channel=%s: %s", streamID, channelID, err.Error())
 		return
 	}
 
 	// 코덱이 있지만 RTSP가 OFFLINE 상태일 수 있음 (이전 연결의 코덱이 남아있는 경우)
 	// StreamChannelRun() 호출 후 RTSP 연결 시도가 완료될 시간을 대기하고 상태 확인
-	channelInfo, err := Storage.StreamChannelInfo(c.Param("uuid"), c.Param("channel"))
+	channelInfo, err := Storage.StreamChannelInfo(streamID, channelID)
 	if err != nil {
 		c.IndentedJSON(500, Message{Status: 0, Payload: err.Error()})
-		log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [StreamChannelInfo] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), err.Error())
+		log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [StreamChannelInfo] stream=%s channel=%s: %s", streamID, channelID, err.Error())
 		return
 	}
 
@@ -47,51 +50,92 @@ func HTTPAPIServerStreamWebRTC(c *gin.Context) {
 		time.Sleep(4 * time.Second)
 
 		// 다시 상태 확인
-		channelInfo, err = Storage.StreamChannelInfo(c.Param("uuid"), c.Param("channel"))
+		channelInfo, err = Storage.StreamChannelInfo(streamID, channelID)
 		if err != nil {
 			c.IndentedJSON(500, Message{Status: 0, Payload: err.Error()})
-			log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [StreamChannelInfo] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), err.Error())
+			log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [StreamChannelInfo] stream=%s channel=%s: %s", streamID, channelID, err.Error())
 			return
 		}
 
 		// 여전히 OFFLINE이면 RTSP 연결 실패
 		if channelInfo.Status == OFFLINE {
 			c.IndentedJSON(500, Message{Status: 0, Payload: ErrorStreamChannelCodecNotFound.Error()})
-			log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [StreamOffline] stream=%s channel=%s: RTSP connection failed or stream is offline", c.Param("uuid"), c.Param("channel"))
+			log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [StreamOffline] stream=%s channel=%s: RTSP connection failed or stream is offline", streamID, channelID)
 			return
 		}
 	}
 
+	// webrtc 는 h264 만 전송 가능, h265 는 ffmpeg 변환 또는 mse / ll-hls 안내
+	// client 등록 (limits 확인) 후 answer 전송
+	var sessionID string
+	var ch chan *av.Packet
+	var clientDelete func()
+	if CodecHasH265(codecs) {
+		if !Storage.ServerWebRTCTranscode() {
+			c.IndentedJSON(415, Message{Status: 0, Payload: gin.H{"error": ErrorStreamCodecWebRTC.Error(), "codecs": CodecInfo(codecs)}})
+			log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [CodecHasH265] stream=%s channel=%s: %s", streamID, channelID, ErrorStreamCodecWebRTC.Error())
+			return
+		}
+		if err = Storage.ClientLimit(streamID, channelID); err != nil {
+			c.IndentedJSON(429, Message{Status: 0, Payload: err.Error()})
+			log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [ClientLimit] stream=%s channel=%s: %s", streamID, channelID, err.Error())
+			return
+		}
+		codecs, sessionID, ch, err = StreamTranscodeH264Add(streamID, channelID, c.ClientIP(), c.Query("token"))
+		if err != nil {
+			c.IndentedJSON(500, Message{Status: 0, Payload: err.Error()})
+			log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [StreamTranscodeH264Add] stream=%s channel=%s: %s", streamID, channelID, err.Error())
+			return
+		}
+		clientDelete = func() {
+			StreamTranscodeH264Delete(streamID, channelID, sessionID)
+		}
+	} else {
+		sessionID, ch, _, err = Storage.ClientAdd(streamID, channelID, WEBRTC, c.ClientIP(), c.Query("token"), false)
+		if err != nil {
+			code := 400
+			if limitIsError(err) {
+				code = 429
+			}
+			c.IndentedJSON(code, Message{Status: 0, Payload: err.Error()})
+			log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [ClientAdd] stream=%s channel=%s: %s", streamID, channelID, err.Error())
+			return
+		}
+		clientDelete = func() {
+			Storage.ClientDelete(streamID, sessionID, channelID)
+		}
+	}
+
 	muxerWebRTC := webrtc.NewMuxer(webrtc.Options{ICEServers: Storage.ServerICEServers(), ICEUsername: Storage.ServerICEUsername(), ICECredential: Storage.ServerICECredential(), PortMin: Storage.ServerWebRTCPortMin(), PortMax: Storage.ServerWebRTCPortMax()})
 	answer, err := muxerWebRTC.WriteHeader(codecs, c.PostForm("data"))
 	if err != nil {
+		clientDelete()
 		c.IndentedJSON(400, Message{Status: 0, Payload: err.Error()})
-		log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [WriteHeader] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), err.Error())
+		log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [WriteHeader] stream=%s channel=%s: %s", streamID, channelID, err.Error())
 		return
 	}
 	_, err = c.Writer.Write([]byte(answer))
 	if err != nil {
+		clientDelete()
 		c.IndentedJSON(400, Message{Status: 0, Payload: err.Error()})
-		log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [Write] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), err.Error())
+		log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [Write] stream=%s channel=%s: %s", streamID, channelID, err.Error())
 		return
 	}
 	go func() {
-		cid, ch, _, err := Storage.ClientAdd(c.Param("uuid"), c.Param("channel"), WEBRTC)
-		if err != nil {
-			c.IndentedJSON(400, Message{Status: 0, Payload: err.Error()})
-			log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [ClientAdd] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), err.Error())
-			return
-		}
-		defer Storage.ClientDelete(c.Param("uuid"), cid, c.Param("channel"))
+		defer clientDelete()
 		var videoStart bool
 		noVideo := time.NewTimer(20 * time.Second)
 		for {
 			select {
 			case <-noVideo.C:
 				//				c.IndentedJSON(500, Message{Status: 0, Payload: ErrorStreamNoVideo.Error()})
-				log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [ErrorStreamNoVideo] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), ErrorStreamNoVideo.Error())
+				log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [ErrorStreamNoVideo] stream=%s channel=%s: %s", streamID, channelID, ErrorStreamNoVideo.Error())
 				return
-			case pck := <-ch:
+			case pck, ok := <-ch:
+				if !ok {
+					log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [ClientQueue] stream=%s channel=%s: queue closed (slow client or transcode stopped)", streamID, channelID)
+					return
+				}
 				if pck.IsKeyFrame {
 					noVideo.Reset(10 * time.Second)
 					videoStart = true
@@ -101,9 +145,10 @@ func HTTPAPIServerStreamWebRTC(c *gin.Context) {
 				}
 				err = muxerWebRTC.WritePacket(*pck)
 				if err != nil {
-					log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [WritePacket] stream=%s channel=%s: %s", c.Param("uuid"), c.Param("channel"), err.Error())
+					log.Printf("[ERROR] [http_webrtc] [HTTPAPIServerStreamWebRTC] [WritePacket] stream=%s channel=%s: %s", streamID, channelID, err.Error())
 					return
 				}
+				Sessions.Sent(sessionID, len(pck.Data))
 			}
 		}
 	}()
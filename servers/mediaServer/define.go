@@ -44,10 +44,40 @@ var (
 	ErrorStreamRestart              = errors.New("stream restart")
 	ErrorStreamStopCoreSignal       = errors.New("stream stop core signal")
 	ErrorStreamStopRTSPSignal       = errors.New("stream stop rtsp signal")
+	ErrorStreamStopSourceSignal     = errors.New("stream stop source signal")
+	ErrorStreamSourceUnsupported    = errors.New("stream source url scheme not supported")
+	ErrorStreamSourceTimeout        = errors.New("stream source dial timeout")
+	ErrorStreamChannelNotPush       = errors.New("stream channel not push mode")
+	ErrorStreamPushBusy             = errors.New("stream channel already has publisher")
+	ErrorHLSPlaylistInvalid         = errors.New("hls playlist invalid")
+	ErrorHLSFMP4Unsupported         = errors.New("hls fmp4 segment not supported")
+	ErrorONVIFNoMediaService        = errors.New("onvif media service not found")
+	ErrorONVIFNoPTZService          = errors.New("onvif ptz service not found")
+	ErrorONVIFNotConfigured         = errors.New("stream channel onvif not configured")
 	ErrorStreamChannelNotFound      = errors.New("stream channel not found")
 	ErrorStreamChannelCodecNotFound = errors.New("stream channel codec not ready, possible stream offline")
+	ErrorStreamCodecWebRTC          = errors.New("webrtc does not support h265, use mse or ll-hls or enable webrtc_transcode")
+	ErrorWebRTCNoTrack              = errors.New("no webrtc track for channel codecs")
+	ErrorWebRTCSessionClosed        = errors.New("webrtc session closed before answer")
+	ErrorTranscodeStopped           = errors.New("transcode stopped while starting")
+	ErrorWHEPICERestart             = errors.New("ice restart not supported")
 	ErrorStreamsLen0                = errors.New("streams len zero")
 	ErrorStreamUnauthorized         = errors.New("stream request unauthorized")
+	ErrorClientSlow                 = errors.New("client queue closed, too slow")
+	ErrorSessionNotFound            = errors.New("session not found")
+	ErrorSessionKicked              = errors.New("session closed by admin")
+	ErrorLimitViewersChannel        = errors.New("channel viewer limit reached")
+	ErrorLimitViewersStream         = errors.New("stream viewer limit reached")
+	ErrorLimitViewersServer         = errors.New("server viewer limit reached")
+	ErrorLimitBandwidth             = errors.New("server egress bandwidth limit reached")
+	ErrorRTSPUDPPorts               = errors.New("no free rtsp udp port pair")
+	ErrorRTSPMulticastDisabled      = errors.New("multicast not configured for channel")
+	ErrorRTSPRequestInvalid         = errors.New("rtsp request invalid")
+	ErrorRTSPRequestTooLarge        = errors.New("rtsp request too large")
+	ErrorRTSPRecordCodec            = errors.New("rtsp publish needs h264 or h265 video")
+	ErrorRTSPReplayNotFound         = errors.New("no recording at replay time")
+	ErrorRTSPReplayRange            = errors.New("invalid replay range")
+	ErrorRTSPTunnelNotFound         = errors.New("rtsp tunnel session cookie not found")
 )
 
 // StorageST main storage struct
@@ -70,20 +100,75 @@ type ServerST struct {
 	HTTPDir            string            `json:"http_dir" groups:"api,config"`
 	HTTPPort           string            `json:"http_port" groups:"api,config"`
 	RTSPPort           string            `json:"rtsp_port" groups:"api,config"`
+	RTMPPort           string            `json:"rtmp_port,omitempty" groups:"api,config"`  // rtmp publish 서버, 비어있으면 사용안함
+	RTSPSPort          string            `json:"rtsps_port,omitempty" groups:"api,config"` // rtsp over tls, 비어있으면 사용안함
 	HTTPS              bool              `json:"https" groups:"api,config"`
 	HTTPSPort          string            `json:"https_port" groups:"api,config"`
 	HTTPSCert          string            `json:"https_cert" groups:"api,config"`
 	HTTPSKey           string            `json:"https_key" groups:"api,config"`
 	HTTPSAutoTLSEnable bool              `json:"https_auto_tls" groups:"api,config"`
 	HTTPSAutoTLSName   string            `json:"https_auto_tls_name" groups:"api,config"`
+	TrustedProxies     []string          `json:"trusted_proxies,omitempty" groups:"api,config"` // X-Forwarded-For 를 믿을 proxy, 없으면 연결 주소
 	ICEServers         []string          `json:"ice_servers" groups:"api,config"`
 	ICEUsername        string            `json:"ice_username" groups:"api,config"`
 	ICECredential      string            `json:"ice_credential" groups:"api,config"`
 	Token              Token             `json:"token,omitempty" groups:"api,config"`
 	WebRTCPortMin      uint16            `json:"webrtc_port_min" groups:"api,config"`
 	WebRTCPortMax      uint16            `json:"webrtc_port_max" groups:"api,config"`
+	WebRTCTranscode    bool              `json:"webrtc_transcode" groups:"api,config"`            // h265 채널 webrtc 요청시 ffmpeg 로 h264 변환
+	RTSPUDPPortMin     uint16            `json:"rtsp_udp_port_min,omitempty" groups:"api,config"` // rtsp udp server_port 범위, 없으면 임의 port
+	RTSPUDPPortMax     uint16            `json:"rtsp_udp_port_max,omitempty" groups:"api,config"`
+	RTSPAuth           RTSPAuthST        `json:"rtsp_auth" groups:"api,config"`
 	FFMPEGPath         string            `json:"ffmpeg_path" groups:"api,config"`
 	Maintenance        MaintenanceConfig `json:"maintenance" groups:"api,config"`
+	Reconnect          ReconnectST       `json:"reconnect" groups:"api,config"`
+	Backpressure       BackpressureST    `json:"backpressure" groups:"api,config"`
+	Limits             LimitsST          `json:"limits" groups:"api,config"`
+}
+
+// RTSPAuthST built-in rtsp server authentication
+type RTSPAuthST struct {
+	Enable bool         `json:"enable" groups:"api,config"`           // 모든 stream 인증 필요, 꺼져 있어도 stream rtsp_users 가 있으면 그 stream 은 인증
+	Method string       `json:"method,omitempty" groups:"api,config"` // basic, digest, 비어있으면 둘 다
+	Realm  string       `json:"realm,omitempty" groups:"api,config"`  // 기본 RTSPtoWeb
+	Users  []RTSPUserST `json:"users,omitempty" groups:"api,config"`  // 모든 stream 접근
+}
+
+// RTSPUserST rtsp credential
+type RTSPUserST struct {
+	User     string `json:"user" groups:"api,config"`
+	Password string `json:"password" groups:"api,config"`
+}
+
+// realm digest / basic realm
+func (val RTSPAuthST) realm() string {
+	if val.Realm == "" {
+		return "RTSPtoWeb"
+	}
+	return val.Realm
+}
+
+// LimitsST viewer and egress limits, 0 unlimited. 로컬 (recorder, transcoder) client 는 제외
+type LimitsST struct {
+	MaxViewers        int     `json:"max_viewers" groups:"api,config"`         // 서버 전체 동시 시청자
+	MaxViewersStream  int     `json:"max_viewers_stream" groups:"api,config"`  // stream 기본값, stream max_viewers 로 덮어씀
+	MaxViewersChannel int     `json:"max_viewers_channel" groups:"api,config"` // channel 기본값, channel max_viewers 로 덮어씀
+	MaxEgressKbps     float64 `json:"max_egress_kbps" groups:"api,config"`     // 채널 bitrate x 시청자 합
+}
+
+// BackpressureST slow client policy of packet fan-out
+type BackpressureST struct {
+	Policy    string  `json:"policy" groups:"api,config"`     // drop (기본), disconnect, priority
+	QueueSize int     `json:"queue_size" groups:"api,config"` // client queue 에 쌓인 packet 이 이 수를 넘으면 밀린 client (기본 1000)
+	MaxLag    float64 `json:"max_lag" groups:"api,config"`    // disconnect 정책, 밀린 상태 유지 초 (기본 10)
+}
+
+// ReconnectST source reconnect backoff, seconds
+type ReconnectST struct {
+	Min        float64 `json:"min" groups:"api,config"`        // 첫 재연결 대기 (기본 2)
+	Max        float64 `json:"max" groups:"api,config"`        // 최대 대기 (기본 60)
+	Multiplier float64 `json:"multiplier" groups:"api,config"` // 실패마다 곱함 (기본 2)
+	Jitter     float64 `json:"jitter" groups:"api,config"`     // 0 ~ 1, 대기시간 +- 비율 (기본 0.2)
 }
 
 // Token auth
@@ -94,19 +179,34 @@ type Token struct {
 
 // ServerST stream storage section
 type StreamST struct {
-	Name     string               `json:"name,omitempty" groups:"api,config"`
-	Channels map[string]ChannelST `json:"channels,omitempty" groups:"api,config"`
+	Name       string               `json:"name,omitempty" groups:"api,config"`
+	Channels   map[string]ChannelST `json:"channels,omitempty" groups:"api,config"`
+	MaxViewers int                  `json:"max_viewers,omitempty" groups:"api,config"` // 0 이면 server limits 기본값
+	RTSPUsers  []RTSPUserST         `json:"rtsp_users,omitempty" groups:"api,config"`  // 이 stream 만 접근하는 rtsp 계정
 }
 
 type ChannelST struct {
-	Name               string `json:"name,omitempty" groups:"api,config"`
-	URL                string `json:"url,omitempty" groups:"api,config"`
-	OnDemand           bool   `json:"on_demand,omitempty" groups:"api,config"`
-	Debug              bool   `json:"debug,omitempty" groups:"api,config"`
-	Status             int    `json:"status,omitempty" groups:"api"`
-	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty" groups:"api,config"`
-	Audio              bool   `json:"audio,omitempty" groups:"api,config"`
-	OnRecording        bool   `json:"on_recording,omitempty" groups:"api,config"` // 현재 녹화 상태
+	Name               string              `json:"name,omitempty" groups:"api,config"`
+	URL                string              `json:"url,omitempty" groups:"api,config"`
+	OnDemand           bool                `json:"on_demand,omitempty" groups:"api,config"`
+	Debug              bool                `json:"debug,omitempty" groups:"api,config"`
+	Status             int                 `json:"status,omitempty" groups:"api"`
+	InsecureSkipVerify bool                `json:"insecure_skip_verify,omitempty" groups:"api,config"`
+	Audio              bool                `json:"audio,omitempty" groups:"api,config"`
+	OnRecording        bool                `json:"on_recording,omitempty" groups:"api,config"`       // 현재 녹화 상태
+	Push               bool                `json:"push,omitempty" groups:"api,config"`               // url 대신 인코더가 publish (rtmp)
+	StreamKey          string              `json:"stream_key,omitempty" groups:"config"`             // push publish 인증 키, api 로는 쓰기만
+	StreamKeySet       bool                `json:"stream_key_set,omitempty" groups:"api"`            // stream_key 설정 여부 (api 응답용)
+	ONVIF              *ChannelONVIFST     `json:"onvif,omitempty" groups:"api,config"`              // onvif 로 추가된 채널 (ptz 제어용)
+	BackupURLs         []string            `json:"backup_urls,omitempty" groups:"api,config"`        // url 실패 시 순서대로 전환
+	FailoverThreshold  int                 `json:"failover_threshold,omitempty" groups:"api,config"` // 전환 전 연속 실패 횟수 (기본 3)
+	FailoverFallback   bool                `json:"failover_fallback,omitempty" groups:"api,config"`  // backup 사용 중 primary 복구되면 되돌아감
+	FailoverProbe      int                 `json:"failover_probe,omitempty" groups:"api,config"`     // primary 확인 주기 초 (기본 30)
+	ActiveURL          string              `json:"active_url,omitempty" groups:"api"`                // 현재 연결된 url
+	ActiveURLIndex     int                 `json:"active_url_index,omitempty" groups:"api"`          // 0 primary, 1~ backup_urls
+	Reconnect          ChannelReconnectST  `json:"reconnect" groups:"api"`                           // 재연결 통계
+	MaxViewers         int                 `json:"max_viewers,omitempty" groups:"api,config"`        // 0 이면 server limits 기본값
+	Multicast          *ChannelMulticastST `json:"multicast,omitempty" groups:"api,config"`          // rtsp multicast 허용
 	runLock            bool
 	codecs             []av.CodecData
 	sdp                []byte
@@ -118,10 +218,68 @@ type ChannelST struct {
 	clients            map[string]ClientST
 	ack                time.Time
 	hlsMuxer           *MuxerHLS `json:"-"`
+	ingest             ChannelIngestST
+	gop                streamGOP // 마지막 키프레임부터, 새 client 에 먼저 전송
+	keyFrameRequest    time.Time // 마지막 source keyframe 요청 (viewer pli)
 
 	Recording *RecordingST `json:"recording,omitempty"` // Recording 제어를 위해 필요한 값 (ffmpeg 등..)
 }
 
+// ChannelMulticastST rtsp multicast of channel, source 가 있으면 ssm (232.0.0.0/8)
+type ChannelMulticastST struct {
+	Group  string `json:"group" groups:"api,config"`            // 239.x.x.x (asm), 232.x.x.x (ssm)
+	Port   int    `json:"port,omitempty" groups:"api,config"`   // 첫 track rtp port, track 마다 +2 (기본 5000)
+	TTL    int    `json:"ttl,omitempty" groups:"api,config"`    // 기본 16
+	Source string `json:"source,omitempty" groups:"api,config"` // ssm 송신 주소 (서버 주소)
+}
+
+// port first track rtp port
+func (val *ChannelMulticastST) port() int {
+	if val.Port <= 0 {
+		return 5000
+	}
+	return val.Port &^ 1
+}
+
+// ttl multicast ttl
+func (val *ChannelMulticastST) ttl() int {
+	if val.TTL <= 0 {
+		return 16
+	}
+	return val.TTL
+}
+
+// ChannelReconnectST source reconnect accounting of channel
+type ChannelReconnectST struct {
+	Attempts         int       `json:"attempts" groups:"api"`
+	LastError        string    `json:"last_error,omitempty" groups:"api"`
+	LastConnected    time.Time `json:"last_connected" groups:"api"`
+	LastDisconnected time.Time `json:"last_disconnected" groups:"api"`
+	Backoff          float64   `json:"backoff" groups:"api"` // 현재 재연결 대기 초, 연결 중이면 0
+}
+
+// ChannelIngestST ingest statistics of channel source
+type ChannelIngestST struct {
+	BitrateKbps      float64   `json:"bitrate_kbps"`      // 최근 5초 평균
+	FPS              float64   `json:"fps"`               // 최근 5초 평균
+	GOPLength        int       `json:"gop_length"`        // 마지막 GOP 프레임 수
+	KeyFrameInterval float64   `json:"keyframe_interval"` // 마지막 키프레임 간격 초
+	LastKeyFrame     time.Time `json:"last_keyframe"`
+	VideoCodec       string    `json:"video_codec,omitempty"`
+	Width            int       `json:"width,omitempty"`
+	Height           int       `json:"height,omitempty"`
+	Packets          uint64    `json:"packets"`
+	Bytes            uint64    `json:"bytes"`
+}
+
+// ChannelONVIFST onvif device info of channel
+type ChannelONVIFST struct {
+	XAddr        string `json:"xaddr,omitempty" groups:"api,config"`
+	Username     string `json:"username,omitempty" groups:"api,config"`
+	Password     string `json:"password,omitempty" groups:"config"`
+	ProfileToken string `json:"profile_token,omitempty" groups:"api,config"`
+}
+
 // ClientST client storage section
 type ClientST struct {
 	mode              int
@@ -129,6 +287,16 @@ type ClientST struct {
 	outgoingAVPacket  chan *av.Packet
 	outgoingRTPPacket chan *[]byte
 	socket            net.Conn
+	// backpressure
+	dropped     uint64        // 버린 packet 수
+	lagged      int           // queue 가 가득 찬 횟수
+	lag         time.Duration // 현재 밀린 시간, 따라잡으면 0
+	maxLag      time.Duration
+	behindSince time.Time
+	waitKey     bool // 다음 키프레임까지 버리는 중
+	keyPart     bool // 보내는 중인 키프레임의 나머지 rtp packet 도 전송
+	replay      int  // queue 앞에 남은 gop replay packet 수, 밀린 것으로 보지 않음
+	internal    bool // 로컬 client, limits 제외
 }
 
 // SegmentOld HLS cache section
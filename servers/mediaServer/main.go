@@ -15,6 +15,9 @@ func StartServer() {
 	go HTTPAPIServer()
 	// RTSP 서버
 	go RTSPServer()
+	go RTSPSServer()
+	// RTMP publish 서버 (push 채널)
+	go RTMPServer()
 	go Storage.StreamChannelRunAll()
 
 	// 유지보수 매니저 (자정 녹화 재시작, 녹화 디스크 정리 ..)
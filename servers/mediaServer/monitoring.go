@@ -49,6 +49,7 @@ type StreamMetricsST struct {
 	TotalClients int                        `json:"total_clients"`
 	Status       string                     `json:"status"` // "online", "offline"
 	LastUpdate   time.Time                  `json:"last_update"`
+	Limit        LimitUsageST               `json:"limit"` // 시청자 / egress 사용량과 limit
 }
 
 // ChannelMetrics 채널별 메트릭
@@ -62,6 +63,26 @@ type ChannelMetrics struct {
 	UpTime         string    `json:"uptime"`
 	LastKeyFrame   time.Time `json:"last_keyframe"`
 	BitrateKbps    float64   `json:"bitrate_kbps"`
+	FPS            float64   `json:"fps"`
+	GOPLength      int       `json:"gop_length"`
+	KeyFrameIntv   float64   `json:"keyframe_interval"` // 초
+	VideoCodec     string    `json:"video_codec,omitempty"`
+	Width          int       `json:"width,omitempty"`
+	Height         int       `json:"height,omitempty"`
+	Packets        uint64    `json:"packets"`
+	Bytes          uint64    `json:"bytes"`
+	ActiveURL      string    `json:"active_url,omitempty"`       // 계정 정보 제외
+	ActiveURLIndex int       `json:"active_url_index,omitempty"` // 0 primary, 1~ backup
+
+	Reconnect ChannelReconnectST `json:"reconnect"` // 재연결 통계 (flapping 카메라 확인)
+
+	DroppedPackets uint64  `json:"dropped_packets"` // 접속중 client 가 backpressure 로 버린 packet 합
+	SlowClients    int     `json:"slow_clients"`    // 현재 밀려있는 client 수
+	MaxLag         float64 `json:"max_lag"`         // 현재 client 중 가장 긴 밀린 시간 초
+
+	Limit LimitUsageST `json:"limit"` // 시청자 (hls 포함, 로컬 제외) / egress 사용량과 limit
+
+	RTCP SessionRTCPSummaryST `json:"rtcp"` // webrtc 시청자 손실 / jitter / rtt
 }
 
 // MonitoringResponse API 응답 구조체
@@ -77,12 +98,13 @@ type MonitoringResponse struct {
 
 // MonitoringSummary 요약 정보
 type MonitoringSummary struct {
-	TotalStreams      int `json:"total_streams"`
-	OnlineStreams     int `json:"online_streams"`
-	OfflineStreams    int `json:"offline_streams"`
-	TotalChannels     int `json:"total_channels"`
-	TotalClients      int `json:"total_clients"`
-	RecordingChannels int `json:"recording_channels"`
+	TotalStreams      int          `json:"total_streams"`
+	OnlineStreams     int          `json:"online_streams"`
+	OfflineStreams    int          `json:"offline_streams"`
+	TotalChannels     int          `json:"total_channels"`
+	TotalClients      int          `json:"total_clients"`
+	RecordingChannels int          `json:"recording_channels"`
+	Limit             LimitUsageST `json:"limit"` // 서버 전체 시청자 / egress
 }
 
 // MonitoringAlert 경고 정보
@@ -316,6 +338,9 @@ func collectStreamMetrics() {
 
 	// 기존 메트릭 초기화
 	Monitoring.StreamMetrics = make(map[string]*StreamMetricsST)
+	rtcpSummary := Sessions.RTCPSummary()
+	// limits, channel 마다 다시 세지 않도록 한 번에
+	channelUsages, streamUsages, _ := Storage.limitUsages()
 
 	for streamID, stream := range Storage.Streams {
 		streamMetric := &StreamMetricsST{
@@ -325,15 +350,45 @@ func collectStreamMetrics() {
 			TotalClients: 0,
 			Status:       "offline",
 			LastUpdate:   time.Now(),
+			Limit:        streamUsages[streamID],
 		}
 
 		for channelID, channel := range stream.Channels {
 			channelMetric := &ChannelMetrics{
 				ChannelID: channelID,
 				// URL:         channel.URL,
-				ClientCount: len(channel.clients),
+				ClientCount:    len(channel.clients),
+				ActiveURL:      failoverRedactURL(channel.ActiveURL),
+				ActiveURLIndex: channel.ActiveURLIndex,
+				Reconnect:      channel.Reconnect,
+				LastKeyFrame:   channel.ingest.LastKeyFrame,
+				BitrateKbps:    channel.ingest.BitrateKbps,
+				FPS:            channel.ingest.FPS,
+				GOPLength:      channel.ingest.GOPLength,
+				KeyFrameIntv:   channel.ingest.KeyFrameInterval,
+				VideoCodec:     channel.ingest.VideoCodec,
+				Width:          channel.ingest.Width,
+				Height:         channel.ingest.Height,
+				Packets:        channel.ingest.Packets,
+				Bytes:          channel.ingest.Bytes,
 			}
 
+			// backpressure
+			for _, client := range channel.clients {
+				channelMetric.DroppedPackets += client.dropped
+				if client.lag > 0 {
+					channelMetric.SlowClients++
+				}
+				if client.lag.Seconds() > channelMetric.MaxLag {
+					channelMetric.MaxLag = client.lag.Seconds()
+				}
+			}
+
+			channelMetric.RTCP = rtcpSummary[streamID+"/"+channelID]
+
+			// limits
+			channelMetric.Limit = channelUsages[streamID+"/"+channelID]
+
 			// 상태 확인
 			if channel.Status == ONLINE {
 				channelMetric.Status = "online"
@@ -409,6 +464,7 @@ func GetMonitoringData(includeHistory bool) MonitoringResponse {
 	// 요약 정보 생성
 	summary := MonitoringSummary{
 		TotalStreams: len(Storage.Streams),
+		Limit:        Storage.ServerLimitUsage(),
 	}
 
 	for _, metric := range Monitoring.StreamMetrics {
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ONVIF WS-Discovery multicast address
+var ONVIFDiscoveryAddress = "239.255.255.250:3702"
+
+// ONVIFDevice discovered device
+type ONVIFDevice struct {
+	Endpoint string   `json:"endpoint"`
+	XAddrs   []string `json:"xaddrs"`
+	Types    []string `json:"types"`
+	Scopes   []string `json:"scopes"`
+	Name     string   `json:"name,omitempty"`
+	Hardware string   `json:"hardware,omitempty"`
+	IP       string   `json:"ip"`
+}
+
+// ONVIFProfile media profile with stream uri
+type ONVIFProfile struct {
+	Token     string `json:"token"`
+	Name      string `json:"name"`
+	Encoding  string `json:"encoding,omitempty"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	StreamURI string `json:"stream_uri,omitempty"`
+	PTZ       bool   `json:"ptz"`
+}
+
+// ONVIFClient soap client with ws-security username token
+type ONVIFClient struct {
+	XAddr      string
+	Username   string
+	Password   string
+	MediaXAddr string
+	PTZXAddr   string
+	client     *http.Client
+	timeOffset time.Duration
+}
+
+// NewONVIFClient connect device service, sync clock and read service address
+func NewONVIFClient(xaddr, username, password string) (*ONVIFClient, error) {
+	client := &ONVIFClient{
+		XAddr:    xaddr,
+		Username: username,
+		Password: password,
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+	// 카메라 시간이 다르면 digest 인증 실패하므로 시간차 보정
+	var dateTime struct {
+		UTCDateTime struct {
+			Date struct {
+				Year  int `xml:"Year"`
+				Month int `xml:"Month"`
+				Day   int `xml:"Day"`
+			} `xml:"Date"`
+			Time struct {
+				Hour   int `xml:"Hour"`
+				Minute int `xml:"Minute"`
+				Second int `xml:"Second"`
+			} `xml:"Time"`
+		} `xml:"SystemDateAndTime>UTCDateTime"`
+	}
+	if err := client.call(xaddr, `<GetSystemDateAndTime xmlns="http://www.onvif.org/ver10/device/wsdl"/>`, &dateTime, false); err == nil && dateTime.UTCDateTime.Date.Year > 0 {
+		d, t := dateTime.UTCDateTime.Date, dateTime.UTCDateTime.Time
+		client.timeOffset = time.Date(d.Year, time.Month(d.Month), d.Day, t.Hour, t.Minute, t.Second, 0, time.UTC).Sub(time.Now().UTC())
+	}
+	var capabilities struct {
+		Media string `xml:"Capabilities>Media>XAddr"`
+		PTZ   string `xml:"Capabilities>PTZ>XAddr"`
+	}
+	if err := client.call(xaddr, `<GetCapabilities xmlns="http://www.onvif.org/ver10/device/wsdl"><Category>All</Category></GetCapabilities>`, &capabilities, true); err != nil {
+		return nil, err
+	}
+	client.MediaXAddr = client.fixXAddr(capabilities.Media)
+	client.PTZXAddr = client.fixXAddr(capabilities.PTZ)
+	if client.MediaXAddr == "" {
+		return nil, ErrorONVIFNoMediaService
+	}
+	return client, nil
+}
+
+// fixXAddr NAT 뒤 카메라가 내부 주소를 주면 접속한 host 로 변경
+func (client *ONVIFClient) fixXAddr(xaddr string) string {
+	if xaddr == "" {
+		return ""
+	}
+	service, err := url.Parse(xaddr)
+	if err != nil {
+		return xaddr
+	}
+	device, err := url.Parse(client.XAddr)
+	if err != nil {
+		return xaddr
+	}
+	if port := service.Port(); port != "" {
+		service.Host = net.JoinHostPort(device.Hostname(), port)
+	} else {
+		service.Host = device.Host
+	}
+	return service.String()
+}
+
+// security ws-security UsernameToken PasswordDigest header
+func (client *ONVIFClient) security() string {
+	if client.Username == "" {
+		return ""
+	}
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	created := time.Now().UTC().Add(client.timeOffset).Format("2006-01-02T15:04:05.000Z")
+	hash := sha1.New()
+	hash.Write(nonce)
+	hash.Write([]byte(created))
+	hash.Write([]byte(client.Password))
+	digest := base64.StdEncoding.EncodeToString(hash.Sum(nil))
+	return `<Security s:mustUnderstand="1" xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd">` +
+		`<UsernameToken><Username>` + onvifEscape(client.Username) + `</Username>` +
+		`<Password Type="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest">` + digest + `</Password>` +
+		`<Nonce EncodingType="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary">` + base64.StdEncoding.EncodeToString(nonce) + `</Nonce>` +
+		`<Created xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">` + created + `</Created>` +
+		`</UsernameToken></Security>`
+}
+
+// call soap request, response body unmarshal to out
+func (client *ONVIFClient) call(xaddr string, body string, out interface{}, auth bool) error {
+	var header string
+	if auth {
+		header = client.security()
+	}
+	envelope := `<?xml version="1.0" encoding="UTF-8"?><s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Header>` + header + `</s:Header><s:Body>` + body + `</s:Body></s:Envelope>`
+	res, err := client.client.Post(xaddr, "application/soap+xml; charset=utf-8", strings.NewReader(envelope))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	var response struct {
+		Body struct {
+			Fault *struct {
+				Reason string `xml:"Reason>Text"`
+				Code   string `xml:"Code>Subcode>Value"`
+			} `xml:"Fault"`
+			Inner []byte `xml:",innerxml"`
+		} `xml:"Body"`
+	}
+	if err = xml.Unmarshal(data, &response); err != nil {
+		return fmt.Errorf("onvif response invalid: status=%d error=%v", res.StatusCode, err)
+	}
+	if response.Body.Fault != nil {
+		return fmt.Errorf("onvif fault: %s %s", response.Body.Fault.Code, response.Body.Fault.Reason)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("onvif status %d", res.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	// body 의 첫 element (xxxResponse) 기준으로 unmarshal
+	return xml.Unmarshal(response.Body.Inner, out)
+}
+
+// Profiles media profiles with rtsp stream uri
+func (client *ONVIFClient) Profiles() ([]ONVIFProfile, error) {
+	var response struct {
+		Profiles []struct {
+			Token    string `xml:"token,attr"`
+			Name     string `xml:"Name"`
+			Encoding string `xml:"VideoEncoderConfiguration>Encoding"`
+			Width    int    `xml:"VideoEncoderConfiguration>Resolution>Width"`
+			Height   int    `xml:"VideoEncoderConfiguration>Resolution>Height"`
+			PTZ      *struct {
+				Token string `xml:"token,attr"`
+			} `xml:"PTZConfiguration"`
+		} `xml:"Profiles"`
+	}
+	if err := client.call(client.MediaXAddr, `<GetProfiles xmlns="http://www.onvif.org/ver10/media/wsdl"/>`, &response, true); err != nil {
+		return nil, err
+	}
+	var profiles []ONVIFProfile
+	for _, item := range response.Profiles {
+		profile := ONVIFProfile{
+			Token:    item.Token,
+			Name:     item.Name,
+			Encoding: item.Encoding,
+			Width:    item.Width,
+			Height:   item.Height,
+			PTZ:      item.PTZ != nil,
+		}
+		uri, err := client.StreamURI(item.Token)
+		if err != nil {
+			return nil, err
+		}
+		profile.StreamURI = uri
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// StreamURI rtsp unicast uri of profile
+func (client *ONVIFClient) StreamURI(profileToken string) (string, error) {
+	var response struct {
+		URI string `xml:"MediaUri>Uri"`
+	}
+	body := `<GetStreamUri xmlns="http://www.onvif.org/ver10/media/wsdl"><StreamSetup>` +
+		`<Stream xmlns="http://www.onvif.org/ver10/schema">RTP-Unicast</Stream>` +
+		`<Transport xmlns="http://www.onvif.org/ver10/schema"><Protocol>RTSP</Protocol></Transport>` +
+		`</StreamSetup><ProfileToken>` + onvifEscape(profileToken) + `</ProfileToken></GetStreamUri>`
+	if err := client.call(client.MediaXAddr, body, &response, true); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(response.URI), nil
+}
+
+// StreamURIWithCredentials rtsp uri with user:password for channel url
+func (client *ONVIFClient) StreamURIWithCredentials(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || client.Username == "" {
+		return uri
+	}
+	u.User = url.UserPassword(client.Username, client.Password)
+	return u.String()
+}
+
+// ONVIFDiscovery ws-discovery probe NetworkVideoTransmitter on local subnet
+func ONVIFDiscovery(timeout time.Duration) ([]ONVIFDevice, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	address, err := net.ResolveUDPAddr("udp4", ONVIFDiscoveryAddress)
+	if err != nil {
+		return nil, err
+	}
+	messageID := make([]byte, 16)
+	rand.Read(messageID)
+	probe := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<e:Envelope xmlns:e="http://www.w3.org/2003/05/soap-envelope" xmlns:w="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:dn="http://www.onvif.org/ver10/network/wsdl">` +
+		`<e:Header><w:MessageID>uuid:` + hex.EncodeToString(messageID) + `</w:MessageID>` +
+		`<w:To e:mustUnderstand="true">urn:schemas-xmlsoap-org:ws:2005:04:discovery</w:To>` +
+		`<w:Action e:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</w:Action></e:Header>` +
+		`<e:Body><d:Probe><d:Types>dn:NetworkVideoTransmitter</d:Types></d:Probe></e:Body></e:Envelope>`
+	if _, err = conn.WriteToUDP([]byte(probe), address); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	devices := []ONVIFDevice{}
+	found := make(map[string]bool)
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// deadline 까지 수집
+			break
+		}
+		var response struct {
+			Matches []struct {
+				Endpoint string `xml:"EndpointReference>Address"`
+				Types    string `xml:"Types"`
+				Scopes   string `xml:"Scopes"`
+				XAddrs   string `xml:"XAddrs"`
+			} `xml:"Body>ProbeMatches>ProbeMatch"`
+		}
+		if xml.Unmarshal(buf[:n], &response) != nil {
+			continue
+		}
+		for _, match := range response.Matches {
+			key := match.Endpoint
+			if key == "" {
+				key = match.XAddrs
+			}
+			if found[key] {
+				continue
+			}
+			found[key] = true
+			device := ONVIFDevice{
+				Endpoint: match.Endpoint,
+				XAddrs:   strings.Fields(match.XAddrs),
+				Types:    strings.Fields(match.Types),
+				Scopes:   strings.Fields(match.Scopes),
+				IP:       from.IP.String(),
+			}
+			for _, scope := range device.Scopes {
+				if value, ok := onvifScope(scope, "onvif://www.onvif.org/name/"); ok {
+					device.Name = value
+				} else if value, ok := onvifScope(scope, "onvif://www.onvif.org/hardware/"); ok {
+					device.Hardware = value
+				}
+			}
+			devices = append(devices, device)
+		}
+	}
+	return devices, nil
+}
+
+// onvifScope read scope value by prefix
+func onvifScope(scope string, prefix string) (string, bool) {
+	if !strings.HasPrefix(scope, prefix) {
+		return "", false
+	}
+	value, err := url.PathUnescape(scope[len(prefix):])
+	if err != nil {
+		return scope[len(prefix):], true
+	}
+	return value, true
+}
+
+// onvifEscape escape xml text
+func onvifEscape(value string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(value))
+	return buf.String()
+}
+
+// ONVIFPreset ptz preset
+type ONVIFPreset struct {
+	Token string `json:"token"`
+	Name  string `json:"name"`
+}
+
+// ONVIFVector ptz pan, tilt, zoom value (-1 ~ 1 velocity, position space of camera)
+// zoom 이 없으면 보내지 않아서 absolute move 에서 현재 zoom 유지
+type ONVIFVector struct {
+	Pan  float64  `json:"pan"`
+	Tilt float64  `json:"tilt"`
+	Zoom *float64 `json:"zoom,omitempty"`
+}
+
+// xml ptz vector element
+func (vector ONVIFVector) xml(name string) string {
+	element := `<` + name + `><PanTilt xmlns="http://www.onvif.org/ver10/schema" x="` + strconv.FormatFloat(vector.Pan, 'f', -1, 64) + `" y="` + strconv.FormatFloat(vector.Tilt, 'f', -1, 64) + `"/>`
+	if vector.Zoom != nil {
+		element += `<Zoom xmlns="http://www.onvif.org/ver10/schema" x="` + strconv.FormatFloat(*vector.Zoom, 'f', -1, 64) + `"/>`
+	}
+	return element + `</` + name + `>`
+}
+
+// ptz call ptz service
+func (client *ONVIFClient) ptz(body string, out interface{}) error {
+	if client.PTZXAddr == "" {
+		return ErrorONVIFNoPTZService
+	}
+	return client.call(client.PTZXAddr, body, out, true)
+}
+
+// ContinuousMove move with velocity until Stop or timeout
+func (client *ONVIFClient) ContinuousMove(profileToken string, velocity ONVIFVector, timeout time.Duration) error {
+	body := `<ContinuousMove xmlns="http://www.onvif.org/ver20/ptz/wsdl"><ProfileToken>` + onvifEscape(profileToken) + `</ProfileToken>` + velocity.xml("Velocity")
+	if timeout > 0 {
+		body += `<Timeout>PT` + strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64) + `S</Timeout>`
+	}
+	return client.ptz(body+`</ContinuousMove>`, nil)
+}
+
+// Stop stop pan tilt and zoom
+func (client *ONVIFClient) Stop(profileToken string) error {
+	return client.ptz(`<Stop xmlns="http://www.onvif.org/ver20/ptz/wsdl"><ProfileToken>`+onvifEscape(profileToken)+`</ProfileToken><PanTilt>true</PanTilt><Zoom>true</Zoom></Stop>`, nil)
+}
+
+// AbsoluteMove move to position
+func (client *ONVIFClient) AbsoluteMove(profileToken string, position ONVIFVector) error {
+	return client.ptz(`<AbsoluteMove xmlns="http://www.onvif.org/ver20/ptz/wsdl"><ProfileToken>`+onvifEscape(profileToken)+`</ProfileToken>`+position.xml("Position")+`</AbsoluteMove>`, nil)
+}
+
+// RelativeMove move by translation
+func (client *ONVIFClient) RelativeMove(profileToken string, translation ONVIFVector) error {
+	return client.ptz(`<RelativeMove xmlns="http://www.onvif.org/ver20/ptz/wsdl"><ProfileToken>`+onvifEscape(profileToken)+`</ProfileToken>`+translation.xml("Translation")+`</RelativeMove>`, nil)
+}
+
+// Presets list ptz presets
+func (client *ONVIFClient) Presets(profileToken string) ([]ONVIFPreset, error) {
+	var response struct {
+		Presets []struct {
+			Token string `xml:"token,attr"`
+			Name  string `xml:"Name"`
+		} `xml:"Preset"`
+	}
+	if err := client.ptz(`<GetPresets xmlns="http://www.onvif.org/ver20/ptz/wsdl"><ProfileToken>`+onvifEscape(profileToken)+`</ProfileToken></GetPresets>`, &response); err != nil {
+		return nil, err
+	}
+	presets := []ONVIFPreset{}
+	for _, preset := range response.Presets {
+		presets = append(presets, ONVIFPreset{Token: preset.Token, Name: preset.Name})
+	}
+	return presets, nil
+}
+
+// GotoPreset move to preset
+func (client *ONVIFClient) GotoPreset(profileToken string, presetToken string) error {
+	return client.ptz(`<GotoPreset xmlns="http://www.onvif.org/ver20/ptz/wsdl"><ProfileToken>`+onvifEscape(profileToken)+`</ProfileToken><PresetToken>`+onvifEscape(presetToken)+`</PresetToken></GotoPreset>`, nil)
+}
+
+// SetPreset save current position as preset, empty token make new preset
+func (client *ONVIFClient) SetPreset(profileToken string, name string, presetToken string) (string, error) {
+	body := `<SetPreset xmlns="http://www.onvif.org/ver20/ptz/wsdl"><ProfileToken>` + onvifEscape(profileToken) + `</ProfileToken>`
+	if name != "" {
+		body += `<PresetName>` + onvifEscape(name) + `</PresetName>`
+	}
+	if presetToken != "" {
+		body += `<PresetToken>` + onvifEscape(presetToken) + `</PresetToken>`
+	}
+	var response struct {
+		Token string `xml:"PresetToken"`
+	}
+	if err := client.ptz(body+`</SetPreset>`, &response); err != nil {
+		return "", err
+	}
+	return response.Token, nil
+}
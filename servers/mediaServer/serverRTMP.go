@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"strings"
+
+	"github.com/deepch/vdk/format/rtmp"
+)
+
+// RTMPServer rtmp publish server for push channels
+// publish url rtmp://host/<app>/<stream>/<channel>?key=<stream_key>
+func RTMPServer() {
+	port := Storage.ServerRTMPPort()
+	if port == "" {
+		log.Printf("[INFO] [rtmp_server] [RTMPServer] Server RTMP disabled")
+		return
+	}
+	log.Printf("[INFO] [rtmp_server] [RTMPServer] [Start] Server RTMP start: port=%s", port)
+	server := &rtmp.Server{
+		Addr:          port,
+		HandlePublish: RTMPServerPublishHandle,
+	}
+	if err := server.ListenAndServe(); err != nil {
+		log.Printf("[ERROR] [rtmp_server] [RTMPServer] [Listen] %v", err)
+	}
+}
+
+// RTMPServerPublishHandle check stream key and feed push channel
+func RTMPServerPublishHandle(conn *rtmp.Conn) {
+	defer conn.Close()
+	remote := conn.NetConn().RemoteAddr().String()
+	// app 은 무시, 마지막 두 path 가 stream, channel
+	path := strings.Split(strings.Trim(conn.URL.Path, "/"), "/")
+	if len(path) < 3 {
+		log.Printf("[WARN] [rtmp_server] [RTMPServerPublishHandle] bad publish path: remote=%s path=%s", remote, conn.URL.Path)
+		return
+	}
+	streamID, channelID := path[len(path)-2], path[len(path)-1]
+	opt, err := Storage.StreamChannelControl(streamID, channelID)
+	if err != nil {
+		log.Printf("[WARN] [rtmp_server] [RTMPServerPublishHandle] stream not found: remote=%s stream=%s channel=%s", remote, streamID, channelID)
+		return
+	}
+	key := conn.URL.Query().Get("key")
+	if !opt.Push || opt.StreamKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(opt.StreamKey)) != 1 {
+		log.Printf("[WARN] [rtmp_server] [RTMPServerPublishHandle] publish unauthorized: remote=%s stream=%s channel=%s", remote, streamID, channelID)
+		return
+	}
+	source, err := NewSourceDemux(conn, conn)
+	if err != nil {
+		log.Printf("[ERROR] [rtmp_server] [RTMPServerPublishHandle] read codec failed: remote=%s stream=%s error=%v", remote, streamID, err)
+		return
+	}
+	if err = SourcePushPublish(streamID, channelID, source); err != nil {
+		log.Printf("[WARN] [rtmp_server] [RTMPServerPublishHandle] publish rejected: remote=%s stream=%s channel=%s error=%v", remote, streamID, channelID, err)
+		source.Close()
+		return
+	}
+	log.Printf("[INFO] [rtmp_server] [RTMPServerPublishHandle] publish start: remote=%s stream=%s channel=%s", remote, streamID, channelID)
+	<-source.Done()
+	log.Printf("[INFO] [rtmp_server] [RTMPServerPublishHandle] publish stop: remote=%s stream=%s channel=%s", remote, streamID, channelID)
+}
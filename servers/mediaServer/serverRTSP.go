@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
@@ -17,16 +19,23 @@ import (
 var (
 	Version   = "RTSP/1.0"
 	UserAgent = "Lavf58.29.100"
-	Session   = "000a959d6816"
 )
 var (
-	OPTIONS  = "OPTIONS"
-	DESCRIBE = "DESCRIBE"
-	SETUP    = "SETUP"
-	PLAY     = "PLAY"
-	TEARDOWN = "TEARDOWN"
+	OPTIONS       = "OPTIONS"
+	DESCRIBE      = "DESCRIBE"
+	SETUP         = "SETUP"
+	PLAY          = "PLAY"
+	TEARDOWN      = "TEARDOWN"
+	GET_PARAMETER = "GET_PARAMETER"
+	SET_PARAMETER = "SET_PARAMETER"
+	ANNOUNCE      = "ANNOUNCE"
+	RECORD        = "RECORD"
+	PAUSE         = "PAUSE"
 )
 
+// RTSPServerPublic OPTIONS Public header
+var RTSPServerPublic = "OPTIONS, DESCRIBE, SETUP, PLAY, PAUSE, TEARDOWN, GET_PARAMETER, SET_PARAMETER, ANNOUNCE, RECORD"
+
 // RTSP response status codes
 const (
 	StatusContinue                      = 100
@@ -150,131 +159,527 @@ func RTSPServer() {
 	}
 }
 
+// RTSPSServer rtsp over tls, https 인증서 (https_cert / https_key, 없으면 sslgen) 사용
+func RTSPSServer() {
+	port := Storage.ServerRTSPSPort()
+	if port == "" {
+		log.Printf("[INFO] [rtsp_server] [RTSPSServer] Server RTSPS disabled")
+		return
+	}
+	certFile, keyFile := Storage.ServerHTTPSCert(), Storage.ServerHTTPSKey()
+	if _, err := os.Stat(certFile); err != nil {
+		sslgen := initMediaSSLgen(Storage.ServerICEServerIP())
+		if sslgen == nil {
+			log.Printf("[ERROR] [rtsp_server] [RTSPSServer] [initMediaSSLgen] certificate not found: cert=%s", certFile)
+			return
+		}
+		certFile, keyFile = sslgen.certpemfilepath, sslgen.keypemfilepath
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		log.Printf("[ERROR] [rtsp_server] [RTSPSServer] [LoadX509KeyPair] cert=%s key=%s: %v", certFile, keyFile, err)
+		return
+	}
+	l, err := tls.Listen("tcp", port, &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		log.Printf("[ERROR] [rtsp_server] [RTSPSServer] [Listen] %v", err)
+		return
+	}
+	defer l.Close()
+	log.Printf("[INFO] [rtsp_server] [RTSPSServer] [Start] Server RTSPS start: port=%s", port)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Printf("[ERROR] [rtsp_server] [RTSPSServer] [Accept] %v", err)
+			return
+		}
+		go RTSPServerClientHandle(conn)
+	}
+}
+
 // RTSPServerClientHandle func
 func RTSPServerClientHandle(conn net.Conn) {
-	buf := make([]byte, 4096)
-	token, uuid, channel, in, cSEQ := "", "", "0", 0, 0
-	var playStarted bool
+	reader := bufio.NewReaderSize(conn, 4096)
+	token, uuid, channel, in := "", "", "0", 0
+	// 연결마다 session id, SETUP 이후 응답에 포함
+	sessionID, sessionStarted := rtspRandomHex(8), false
+	var playStarted, multicastJoined bool
+	// transport 는 첫 SETUP 기준, udp 세션은 PLAY 후 play goroutine 이 닫는다
+	var transportMode string
+	var udp *rtspUDPSession
+	// ANNOUNCE 한 publisher, RECORD 후에는 push channel core 가 source 를 가진다
+	var record *SourceRTSPRecord
+	var recording bool
+	// .../replay 요청은 live 대신 녹화 재생
+	var replay *rtspReplay
+	var replayRequested bool
+	var replayBegin time.Time
+	auth := newRTSPAuth()
+	done := make(chan struct{})
 	defer func() {
+		close(done)
+		if replay != nil {
+			replay.close()
+		}
+		if record != nil {
+			if recording {
+				record.stop()
+				log.Printf("[INFO] [rtsp_server] [handleRTSPServerRequest] publish stop: remote=%s stream=%s channel=%s", conn.RemoteAddr().String(), uuid, channel)
+			} else {
+				record.Close()
+			}
+		}
+		if udp != nil && !playStarted {
+			udp.close()
+		}
+		if multicastJoined {
+			rtspMulticastLeave(uuid, channel, conn)
+		}
+		// play goroutine, kick, publish 종료에서 먼저 닫았을 수 있다
 		err := conn.Close()
-		if err != nil {
+		if err != nil && !errors.Is(err, net.ErrClosed) {
 			log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [Close] stream=%s channel=%s: %s", uuid, channel, err.Error())
 		}
 
 	}()
-	err := conn.SetDeadline(time.Now().Add(10 * time.Second))
+	// 첫 요청까지 10초, 이후 요청이나 client rtcp 마다 session timeout 연장
+	// write deadline 은 두지 않는다 (keepalive 없이 재생만 하는 client)
+	err := conn.SetReadDeadline(time.Now().Add(10 * time.Second))
 	if err != nil {
 		log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [SetDeadline] stream=%s channel=%s: %s", uuid, channel, err.Error())
 		return
 	}
-	for {
-		n, err := conn.Read(buf)
-		if err != nil {
-			log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [Read] stream=%s channel=%s: %s", uuid, channel, err.Error())
+	// tcp interleaved 재생 중에는 연결 자체가 keepalive
+	keepalive := func(byte, []byte) {
+		if playStarted && udp == nil && !multicastJoined {
 			return
 		}
-		cSEQ = parsecSEQ(buf[:n])
-		stage, err := parseStage(buf[:n])
-		if err != nil {
-			log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] stream=%s: error=%s", uuid, err.Error())
+		conn.SetReadDeadline(time.Now().Add(rtspSessionTimeout * time.Second))
+	}
+	interleaved := func(ch byte, data []byte) {
+		keepalive(ch, data)
+		if recording {
+			record.writeRTP(ch, data)
 		}
-		err = conn.SetDeadline(time.Now().Add(60 * time.Second))
+	}
+	for {
+		req, err := readRTSPRequest(reader, interleaved)
 		if err != nil {
-			log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] stream=%s: error=%s", uuid, err.Error())
+			log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [Read] stream=%s channel=%s: %s", uuid, channel, err.Error())
+			if err == ErrorRTSPRequestInvalid || err == ErrorRTSPRequestTooLarge {
+				RTSPServerClientResponse(uuid, channel, conn, StatusBadRequest, map[string]string{})
+			}
 			return
 		}
+		keepalive(0, nil)
+		headers := func(val map[string]string) map[string]string {
+			val["CSeq"] = req.cSeq()
+			val["Server"] = UserAgent
+			if sessionStarted {
+				val["Session"] = sessionID + ";timeout=" + strconv.Itoa(rtspSessionTimeout)
+			}
+			return val
+		}
 
-		switch stage {
-		case OPTIONS:
-			if playStarted {
-				err = RTSPServerClientResponse(uuid, channel, conn, 200, map[string]string{"CSeq": strconv.Itoa(cSEQ), "Public": "DESCRIBE, SETUP, TEARDOWN, PLAY"})
-				if err != nil {
-					return
-				}
-				continue
+		// 다른 연결의 session 요청은 거부
+		if session := req.session(); session != "" && (!sessionStarted || session != sessionID) {
+			err = RTSPServerClientResponse(uuid, channel, conn, StatusSessionNotFound, headers(map[string]string{}))
+			if err != nil {
+				return
 			}
-			uuid, channel, token, err = parseStreamChannel(buf[:n])
+			continue
+		}
+
+		// 첫 요청 url 의 stream / channel, OPTIONS 없이 DESCRIBE 부터 하는 client 도 있다
+		if uuid == "" && req.URL != "*" {
+			uuid, channel, token, err = parseStreamChannel(req.URL)
 			if err != nil {
 				log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [parseStreamChannel] stream=%s channel=%s: %s", uuid, channel, err.Error())
+				RTSPServerClientResponse(uuid, channel, conn, StatusNotFound, headers(map[string]string{}))
 				return
 			}
 			if !Storage.StreamChannelExist(uuid, channel) {
 				log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [StreamChannelExist] stream=%s channel=%s: %s", uuid, channel, ErrorStreamNotFound.Error())
-				err = RTSPServerClientResponse(uuid, channel, conn, 404, map[string]string{"CSeq": strconv.Itoa(cSEQ)})
-				if err != nil {
-					return
-				}
+				RTSPServerClientResponse(uuid, channel, conn, StatusNotFound, headers(map[string]string{}))
 				return
 			}
+			replayRequested, replayBegin, err = rtspReplayURL(req.URL)
+			if err != nil {
+				log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [rtspReplayURL] stream=%s channel=%s: %s", uuid, channel, err.Error())
+				RTSPServerClientResponse(uuid, channel, conn, StatusBadRequest, headers(map[string]string{}))
+				return
+			}
+			authType := "RTSP"
+			if replayRequested {
+				authType = "recording"
+			}
 
-			if !RemoteAuthorization("RTSP", uuid, channel, token, conn.RemoteAddr().String()) {
-				log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [StreamChannelExist] stream=%s channel=%s: %s", uuid, channel, ErrorStreamUnauthorized.Error())
-				err = RTSPServerClientResponse(uuid, channel, conn, 401, map[string]string{"CSeq": strconv.Itoa(cSEQ)})
-				if err != nil {
-					return
-				}
+			if !RemoteAuthorization(authType, uuid, channel, token, conn.RemoteAddr().String()) {
+				log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [RemoteAuthorization] stream=%s channel=%s: %s", uuid, channel, ErrorStreamUnauthorized.Error())
+				RTSPServerClientResponse(uuid, channel, conn, StatusUnauthorized, headers(map[string]string{}))
 				return
 			}
+			if !replayRequested {
+				Storage.StreamChannelRun(uuid, channel)
+			}
+		}
 
-			Storage.StreamChannelRun(uuid, channel)
-			err = RTSPServerClientResponse(uuid, channel, conn, 200, map[string]string{"CSeq": strconv.Itoa(cSEQ), "Public": "DESCRIBE, SETUP, TEARDOWN, PLAY"})
+		// OPTIONS, TEARDOWN, keepalive 외에는 rtsp 인증 (basic / digest), 연결당 한번
+		// publisher 는 ANNOUNCE 에서 stream_key 로 인증
+		if (req.Method == DESCRIBE || req.Method == SETUP && record == nil || req.Method == PLAY) && !auth.authorized && !auth.check(req.Method, req.header("Authorization"), uuid, conn.RemoteAddr().String()) {
+			log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [Authorization] stream=%s channel=%s: %s", uuid, channel, ErrorStreamUnauthorized.Error())
+			err = RTSPServerClientResponse(uuid, channel, conn, StatusUnauthorized, auth.challenge(uuid, req.cSeq()))
+			if err != nil {
+				return
+			}
+			continue
+		}
+
+		switch req.Method {
+		case OPTIONS:
+			err = RTSPServerClientResponse(uuid, channel, conn, 200, headers(map[string]string{"Public": RTSPServerPublic}))
+			if err != nil {
+				return
+			}
+		case GET_PARAMETER, SET_PARAMETER:
+			// keepalive, parameter 는 지원하지 않음
+			status := StatusOK
+			if req.Method == SET_PARAMETER && len(req.Body) > 0 {
+				status = StatusInvalidparameter
+			}
+			err = RTSPServerClientResponse(uuid, channel, conn, status, headers(map[string]string{}))
 			if err != nil {
 				return
 			}
 		case SETUP:
-			if !strings.Contains(string(buf[:n]), "interleaved") {
-				err = RTSPServerClientResponse(uuid, channel, conn, 461, map[string]string{"CSeq": strconv.Itoa(cSEQ)})
+			if uuid == "" {
+				err = RTSPServerClientResponse(uuid, channel, conn, StatusNotFound, headers(map[string]string{}))
 				if err != nil {
 					return
 				}
 				continue
 			}
-			err = RTSPServerClientResponse(uuid, channel, conn, 200, map[string]string{"CSeq": strconv.Itoa(cSEQ), "User-Agent:": UserAgent, "Session": Session, "Transport": "RTP/AVP/TCP;unicast;interleaved=" + strconv.Itoa(in) + "-" + strconv.Itoa(in+1)})
+			transport, ok := parseRTSPTransport(req.header("Transport"))
+			if record != nil {
+				// publish 는 tcp interleaved 만, client 가 정한 channel 사용
+				if !ok || transport.mode != rtspTransportTCP {
+					err = RTSPServerClientResponse(uuid, channel, conn, StatusUnsupportedTransport, headers(map[string]string{}))
+					if err != nil {
+						return
+					}
+					continue
+				}
+				if transport.interleaved < 0 {
+					transport.interleaved = in
+				}
+				if !record.setup(req.URL, byte(transport.interleaved)) {
+					err = RTSPServerClientResponse(uuid, channel, conn, StatusNotFound, headers(map[string]string{}))
+					if err != nil {
+						return
+					}
+					continue
+				}
+				sessionStarted = true
+				err = RTSPServerClientResponse(uuid, channel, conn, 200, headers(map[string]string{"Transport": "RTP/AVP/TCP;unicast;interleaved=" + strconv.Itoa(transport.interleaved) + "-" + strconv.Itoa(transport.interleaved+1) + ";mode=record"}))
+				if err != nil {
+					return
+				}
+				in = transport.interleaved + 2
+				continue
+			}
+			if ok && transport.mode == rtspTransportMulticast && Storage.StreamChannelMulticast(uuid, channel) == nil {
+				ok = false
+			}
+			// 녹화 재생은 tcp interleaved 만
+			if ok && replayRequested && transport.mode != rtspTransportTCP {
+				ok = false
+			}
+			if !ok || playStarted || transportMode != "" && transport.mode != transportMode {
+				err = RTSPServerClientResponse(uuid, channel, conn, StatusUnsupportedTransport, headers(map[string]string{}))
+				if err != nil {
+					return
+				}
+				continue
+			}
+			transportMode = transport.mode
+			var transportHeader string
+			switch transport.mode {
+			case rtspTransportUDP:
+				if udp == nil {
+					udp = &rtspUDPSession{tracks: make(map[byte]*rtspRTPTrack)}
+				}
+				clockRate := uint32(90000)
+				if sdp, err := Storage.StreamChannelSDP(uuid, channel); err == nil {
+					if rates := rtspSDPClockRates(sdp); in/2 < len(rates) {
+						clockRate = rates[in/2]
+					}
+				}
+				serverPort, err := udp.setup(byte(in), conn.RemoteAddr(), transport, clockRate)
+				if err != nil {
+					log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [setup] stream=%s channel=%s: %s", uuid, channel, err.Error())
+					err = RTSPServerClientResponse(uuid, channel, conn, StatusInternalServerError, headers(map[string]string{}))
+					if err != nil {
+						return
+					}
+					continue
+				}
+				transportHeader = "RTP/AVP;unicast;client_port=" + strconv.Itoa(transport.clientRTP) + "-" + strconv.Itoa(transport.clientRTCP) + ";server_port=" + strconv.Itoa(serverPort) + "-" + strconv.Itoa(serverPort+1)
+			case rtspTransportMulticast:
+				transportHeader = rtspMulticastTransport(Storage.StreamChannelMulticast(uuid, channel), byte(in))
+			default:
+				transportHeader = "RTP/AVP/TCP;unicast;interleaved=" + strconv.Itoa(in) + "-" + strconv.Itoa(in+1)
+			}
+			sessionStarted = true
+			err = RTSPServerClientResponse(uuid, channel, conn, 200, headers(map[string]string{"Transport": transportHeader}))
 			if err != nil {
 				return
 			}
 			in = in + 2
 		case DESCRIBE:
-			sdp, err := Storage.StreamChannelSDP(uuid, channel)
+			var sdp []byte
+			if replayRequested {
+				if replay == nil {
+					replay, err = newRTSPReplay(uuid, channel, replayBegin)
+				}
+				if replay != nil {
+					sdp = replay.packetizer.SDP()
+				}
+			} else {
+				sdp, err = Storage.StreamChannelSDP(uuid, channel)
+			}
 			if err != nil {
 				log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [StreamSDP] stream=%s channel=%s: %s", uuid, channel, err.Error())
+				RTSPServerClientResponse(uuid, channel, conn, StatusNotFound, headers(map[string]string{}))
 				return
 			}
-			err = RTSPServerClientResponse(uuid, channel, conn, 200, map[string]string{"CSeq": strconv.Itoa(cSEQ), "User-Agent:": UserAgent, "Session": Session, "Content-Type": "application/sdp\r\nContent-Length: " + strconv.Itoa(len(sdp)), "sdp": string(sdp)})
+			err = RTSPServerClientResponse(uuid, channel, conn, 200, headers(map[string]string{"Content-Base": req.URL + "/", "Content-Type": "application/sdp", "sdp": string(sdp)}))
 			if err != nil {
 				return
 			}
 		case PLAY:
-			err = RTSPServerClientResponse(uuid, channel, conn, 200, map[string]string{"CSeq": strconv.Itoa(cSEQ), "User-Agent:": UserAgent, "Session": Session})
+			if !sessionStarted || record != nil {
+				err = RTSPServerClientResponse(uuid, channel, conn, StatusMethodNotValidInThisState, headers(map[string]string{}))
+				if err != nil {
+					return
+				}
+				continue
+			}
+			if replayRequested {
+				// Range 로 이동, Scale 배속, Range 없으면 PAUSE 위치에서 재개
+				if replay == nil {
+					if replay, err = newRTSPReplay(uuid, channel, replayBegin); err != nil {
+						log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [newRTSPReplay] stream=%s channel=%s: %s", uuid, channel, err.Error())
+						RTSPServerClientResponse(uuid, channel, conn, StatusNotFound, headers(map[string]string{}))
+						return
+					}
+				}
+				scale := 1.0
+				if val := req.header("Scale"); val != "" {
+					if scale, err = strconv.ParseFloat(val, 64); err != nil || scale <= 0 {
+						err = RTSPServerClientResponse(uuid, channel, conn, StatusHeaderFieldNotValid, headers(map[string]string{}))
+						if err != nil {
+							return
+						}
+						continue
+					}
+				}
+				from, end, err := replay.rangeTime(req.header("Range"))
+				if err != nil {
+					log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [rangeTime] stream=%s channel=%s range=%s: %s", uuid, channel, req.header("Range"), err.Error())
+					err = RTSPServerClientResponse(uuid, channel, conn, StatusInvalidRange, headers(map[string]string{}))
+					if err != nil {
+						return
+					}
+					continue
+				}
+				// 첫 PLAY 에 live viewer 처럼 session 등록, limits 적용
+				if replay.session == "" {
+					user := token
+					if auth.user != "" {
+						user = auth.user
+					}
+					if err = replay.register(sessionRemote(conn.RemoteAddr()), user, conn); err != nil {
+						log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [register] stream=%s channel=%s: %s", uuid, channel, err.Error())
+						status := StatusInternalServerError
+						if limitIsError(err) {
+							status = StatusNotEnoughBandwidth
+						}
+						RTSPServerClientResponse(uuid, channel, conn, status, headers(map[string]string{}))
+						return
+					}
+				}
+				playRange := "clock=" + from.UTC().Format(rtspClockLayout) + "-"
+				if !end.IsZero() {
+					playRange += end.UTC().Format(rtspClockLayout)
+				}
+				err = RTSPServerClientResponse(uuid, channel, conn, 200, headers(map[string]string{"Range": playRange, "Scale": strconv.FormatFloat(scale, 'f', -1, 64)}))
+				if err != nil {
+					return
+				}
+				playStarted = true
+				conn.SetReadDeadline(time.Time{})
+				replay.play(from, end, scale, conn)
+				continue
+			}
+			if playStarted {
+				err = RTSPServerClientResponse(uuid, channel, conn, 200, headers(map[string]string{}))
+				if err != nil {
+					return
+				}
+				continue
+			}
+			user := token
+			if auth.user != "" {
+				user = auth.user
+			}
+			if transportMode == rtspTransportMulticast {
+				err = rtspMulticastJoin(uuid, channel, conn, user)
+				if err != nil {
+					log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [rtspMulticastJoin] stream=%s channel=%s: %s", uuid, channel, err.Error())
+					status := StatusInternalServerError
+					if limitIsError(err) {
+						status = StatusNotEnoughBandwidth
+					}
+					RTSPServerClientResponse(uuid, channel, conn, status, headers(map[string]string{}))
+					return
+				}
+				multicastJoined = true
+				playStarted = true
+				err = RTSPServerClientResponse(uuid, channel, conn, 200, headers(map[string]string{}))
+				if err != nil {
+					return
+				}
+				continue
+			}
+			internal := rtspInternalClient(auth, req.header("User-Agent"), conn.RemoteAddr().String())
+			cid, _, ch, err := Storage.ClientAdd(uuid, channel, RTSP, sessionRemote(conn.RemoteAddr()), user, internal)
+			if err != nil {
+				log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [ClientAdd] stream=%s channel=%s: %s", uuid, channel, err.Error())
+				if limitIsError(err) {
+					RTSPServerClientResponse(uuid, channel, conn, StatusNotEnoughBandwidth, headers(map[string]string{}))
+				}
+				return
+			}
+			err = RTSPServerClientResponse(uuid, channel, conn, 200, headers(map[string]string{}))
 			if err != nil {
+				Storage.ClientDelete(uuid, cid, channel)
 				return
 			}
 			playStarted = true
-			go RTSPServerClientPlay(uuid, channel, conn)
-		case TEARDOWN:
-			err = RTSPServerClientResponse(uuid, channel, conn, 200, map[string]string{"CSeq": strconv.Itoa(cSEQ), "User-Agent:": UserAgent, "Session": Session})
+			if udp != nil {
+				go RTSPServerClientPlayUDP(uuid, channel, cid, ch, udp, conn, done)
+			} else {
+				conn.SetReadDeadline(time.Time{})
+				go RTSPServerClientPlay(uuid, channel, cid, ch, conn)
+			}
+		case PAUSE:
+			if replay == nil || !playStarted {
+				err = RTSPServerClientResponse(uuid, channel, conn, StatusMethodNotValidInThisState, headers(map[string]string{}))
+				if err != nil {
+					return
+				}
+				continue
+			}
+			replay.pause()
+			err = RTSPServerClientResponse(uuid, channel, conn, 200, headers(map[string]string{}))
 			if err != nil {
 				return
 			}
+		case ANNOUNCE:
+			opt, err := Storage.StreamChannelControl(uuid, channel)
+			if err != nil {
+				err = RTSPServerClientResponse(uuid, channel, conn, StatusNotFound, headers(map[string]string{}))
+				if err != nil {
+					return
+				}
+				continue
+			}
+			if !opt.Push || playStarted || record != nil {
+				log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [ANNOUNCE] stream=%s channel=%s: %s", uuid, channel, ErrorStreamChannelNotPush.Error())
+				err = RTSPServerClientResponse(uuid, channel, conn, StatusMethodNotValidInThisState, headers(map[string]string{}))
+				if err != nil {
+					return
+				}
+				continue
+			}
+			var key string
+			if u, err := url.Parse(req.URL); err == nil {
+				key = u.Query().Get("key")
+			}
+			if !auth.checkPublish(req.Method, req.header("Authorization"), key, opt.StreamKey, conn.RemoteAddr().String()) {
+				log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [ANNOUNCE] publish unauthorized: remote=%s stream=%s channel=%s", conn.RemoteAddr().String(), uuid, channel)
+				err = RTSPServerClientResponse(uuid, channel, conn, StatusUnauthorized, auth.challengePublish(req.cSeq()))
+				if err != nil {
+					return
+				}
+				continue
+			}
+			if !strings.HasPrefix(req.header("Content-Type"), "application/sdp") {
+				err = RTSPServerClientResponse(uuid, channel, conn, StatusUnsupportedMediaType, headers(map[string]string{}))
+				if err != nil {
+					return
+				}
+				continue
+			}
+			record, err = NewSourceRTSPRecord(req.Body)
+			if err != nil {
+				log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [NewSourceRTSPRecord] stream=%s channel=%s: %s", uuid, channel, err.Error())
+				err = RTSPServerClientResponse(uuid, channel, conn, StatusUnsupportedMediaType, headers(map[string]string{}))
+				if err != nil {
+					return
+				}
+				continue
+			}
+			record.conn = conn
+			err = RTSPServerClientResponse(uuid, channel, conn, 200, headers(map[string]string{}))
+			if err != nil {
+				return
+			}
+		case RECORD:
+			if record == nil || !sessionStarted || recording {
+				err = RTSPServerClientResponse(uuid, channel, conn, StatusMethodNotValidInThisState, headers(map[string]string{}))
+				if err != nil {
+					return
+				}
+				continue
+			}
+			err = SourcePushPublish(uuid, channel, record)
+			if err != nil {
+				log.Printf("[WARN] [rtsp_server] [handleRTSPServerRequest] publish rejected: remote=%s stream=%s channel=%s error=%v", conn.RemoteAddr().String(), uuid, channel, err)
+				RTSPServerClientResponse(uuid, channel, conn, StatusServiceUnavailable, headers(map[string]string{}))
+				return
+			}
+			recording = true
+			// core 가 source 를 닫으면 (channel 정지, 재시작) publisher 연결도 종료
+			go func(source *SourceRTSPRecord) {
+				<-source.Done()
+				conn.Close()
+			}(record)
+			log.Printf("[INFO] [rtsp_server] [handleRTSPServerRequest] publish start: remote=%s stream=%s channel=%s", conn.RemoteAddr().String(), uuid, channel)
+			err = RTSPServerClientResponse(uuid, channel, conn, 200, headers(map[string]string{}))
+			if err != nil {
+				return
+			}
+		case TEARDOWN:
+			RTSPServerClientResponse(uuid, channel, conn, 200, headers(map[string]string{}))
 			return
 		default:
-			log.Printf("[INFO] [rtsp_server] [handleRTSPServerRequest] [Stage] stage bad: stream=%s channel=%s stage=%s", uuid, channel, stage)
+			log.Printf("[INFO] [rtsp_server] [handleRTSPServerRequest] [Method] method not allowed: stream=%s channel=%s method=%s", uuid, channel, req.Method)
+			err = RTSPServerClientResponse(uuid, channel, conn, StatusMethodNotAllowed, headers(map[string]string{"Allow": RTSPServerPublic}))
+			if err != nil {
+				return
+			}
 		}
 	}
 }
 
 // handleRTSPServerPlay func
-func RTSPServerClientPlay(uuid string, channel string, conn net.Conn) {
-	cid, _, ch, err := Storage.ClientAdd(uuid, channel, RTSP)
-	if err != nil {
-		log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [ClientAdd] stream=%s channel=%s: %s", uuid, channel, err.Error())
-		return
-	}
+func RTSPServerClientPlay(uuid string, channel string, cid string, ch chan *[]byte, conn net.Conn) {
 	defer func() {
 		Storage.ClientDelete(uuid, cid, channel)
 		log.Printf("[INFO] [rtsp_server] [handleRTSPServerRequest] [ClientDelete] Client offline: stream=%s channel=%s", uuid, channel)
 		err := conn.Close()
-		if err != nil {
+		if err != nil && !errors.Is(err, net.ErrClosed) {
 			log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [Close] stream=%s channel=%s: %s", uuid, channel, err.Error())
 		}
 	}()
@@ -285,13 +690,18 @@ func RTSPServerClientPlay(uuid string, channel string, conn net.Conn) {
 		select {
 		case <-noVideo.C:
 			return
-		case pck := <-ch:
+		case pck, ok := <-ch:
+			if !ok {
+				log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [ErrorClientSlow] stream=%s channel=%s: %s", uuid, channel, ErrorClientSlow.Error())
+				return
+			}
 			noVideo.Reset(10 * time.Second)
 			_, err := conn.Write(*pck)
 			if err != nil {
 				log.Printf("[ERROR] [rtsp_server] [handleRTSPServerRequest] [Write] stream=%s channel=%s: %s", uuid, channel, err.Error())
 				return
 			}
+			Sessions.Sent(cid, len(*pck))
 		}
 	}
 }
@@ -308,6 +718,9 @@ func RTSPServerClientResponse(uuid string, channel string, conn net.Conn, status
 		}
 		builder.WriteString(fmt.Sprintf("%s: %s\r\n", k, v))
 	}
+	if sdp != "" {
+		builder.WriteString(fmt.Sprintf("Content-Length: %d\r\n", len(sdp)))
+	}
 	builder.WriteString(fmt.Sprintf("\r\n"))
 	builder.WriteString(sdp)
 	// log.Printf("[INFO] [rtsp_server] [RTSPServerClientResponse] %s", builder.String())
@@ -318,26 +731,11 @@ func RTSPServerClientResponse(uuid string, channel string, conn net.Conn, status
 	return nil
 }
 
-// parsecSEQ func
-func parsecSEQ(buf []byte) int {
-	return stringToInt(stringInBetween(string(buf), "CSeq: ", "\r\n"))
-}
-
-// parseStage func
-func parseStage(buf []byte) (string, error) {
-	st := strings.Split(string(buf), " ")
-	if len(st) > 0 {
-		return st[0], nil
-	}
-	return "", errors.New("parse stage error " + string(buf))
-}
-
 // parseStreamChannel func
-func parseStreamChannel(buf []byte) (string, string, string, error) {
+func parseStreamChannel(uri string) (string, string, string, error) {
 
 	var token string
 
-	uri := stringInBetween(string(buf), " ", " ")
 	u, err := url.Parse(uri)
 	if err == nil {
 		token = u.Query().Get("token")
@@ -350,5 +748,5 @@ func parseStreamChannel(buf []byte) (string, string, string, error) {
 		return st[1], st[2], token, nil
 	}
 
-	return "", "0", token, errors.New("parse stream error " + string(buf))
+	return "", "0", token, errors.New("parse stream error " + uri)
 }
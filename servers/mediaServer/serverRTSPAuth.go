@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Default rtsp auth methods
+const (
+	RTSPAuthBasic  = "basic"
+	RTSPAuthDigest = "digest"
+)
+
+// rtspInternalUser recorder / transcoder loopback 접속용 계정, 실행마다 새 password
+var rtspInternalUser, rtspInternalPassword = "internal", rtspRandomHex(16)
+
+// rtspInternalAgent User-Agent of recorder / transcoder ffmpeg, rtsp 인증이 꺼져 있어도 internal client 구분
+var rtspInternalAgent = "internal/" + rtspInternalPassword
+
+// rtspRandomHex random hex string of n bytes
+func rtspRandomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// rtspInternalURL loopback rtsp url of channel with internal credential, storage lock 없이 port 를 받는다
+func rtspInternalURL(port string, streamID string, channelID string) string {
+	return fmt.Sprintf("rtsp://%s:%s@localhost%s/%s/%s", rtspInternalUser, rtspInternalPassword, port, streamID, channelID)
+}
+
+// rtspInternalClient loopback connection with internal credential or internal User-Agent, limits 와 viewer 수 제외
+func rtspInternalClient(auth *rtspAuth, userAgent string, remote string) bool {
+	if !remoteLoopback(remote) {
+		return false
+	}
+	return auth.user == rtspInternalUser || subtle.ConstantTimeCompare([]byte(userAgent), []byte(rtspInternalAgent)) == 1
+}
+
+// rtspAuth authentication state of one rtsp connection
+type rtspAuth struct {
+	nonce      string
+	user       string
+	authorized bool
+}
+
+// newRTSPAuth new connection, digest nonce per connection
+func newRTSPAuth() *rtspAuth {
+	return &rtspAuth{nonce: rtspRandomHex(16)}
+}
+
+// check authorize request, not required 이면 통과. 인증된 사용자는 auth.user
+func (auth *rtspAuth) check(method string, header string, streamID string, remote string) bool {
+	config, required := Storage.StreamRTSPAuth(streamID)
+	if !required {
+		auth.authorized = true
+		return true
+	}
+	scheme, credentials, _ := strings.Cut(header, " ")
+	var user string
+	var ok bool
+	switch {
+	case strings.EqualFold(scheme, "Basic") && config.Method != RTSPAuthDigest:
+		user, ok = rtspAuthBasic(credentials, config.Users, remote)
+	case strings.EqualFold(scheme, "Digest") && config.Method != RTSPAuthBasic:
+		user, ok = rtspAuthDigest(method, credentials, auth.nonce, config.realm(), config.Users, remote)
+	}
+	if ok {
+		auth.user = user
+		auth.authorized = true
+	}
+	return ok
+}
+
+// checkPublish authorize ANNOUNCE, url key 또는 password (basic / digest, user 는 아무거나) 가 channel stream_key
+func (auth *rtspAuth) checkPublish(method string, header string, key string, streamKey string, remote string) bool {
+	if streamKey == "" {
+		return false
+	}
+	if key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(streamKey)) == 1 {
+		return true
+	}
+	config, _ := Storage.StreamRTSPAuth("")
+	scheme, credentials, _ := strings.Cut(header, " ")
+	users := []RTSPUserST{{User: rtspAuthUsername(scheme, credentials), Password: streamKey}}
+	switch {
+	case strings.EqualFold(scheme, "Basic"):
+		_, ok := rtspAuthBasic(credentials, users, remote)
+		return ok
+	case strings.EqualFold(scheme, "Digest"):
+		_, ok := rtspAuthDigest(method, credentials, auth.nonce, config.realm(), users, remote)
+		return ok
+	}
+	return false
+}
+
+// challenge 401 headers, basic 과 digest 모두 허용이면 WWW-Authenticate 두 줄
+func (auth *rtspAuth) challenge(streamID string, cSEQ string) map[string]string {
+	config, _ := Storage.StreamRTSPAuth(streamID)
+	return auth.challengeConfig(config, cSEQ)
+}
+
+// challengePublish 401 headers of ANNOUNCE, 항상 digest 와 basic
+func (auth *rtspAuth) challengePublish(cSEQ string) map[string]string {
+	config, _ := Storage.StreamRTSPAuth("")
+	config.Method = ""
+	return auth.challengeConfig(config, cSEQ)
+}
+
+// challengeConfig WWW-Authenticate of auth config
+func (auth *rtspAuth) challengeConfig(config RTSPAuthST, cSEQ string) map[string]string {
+	digest := fmt.Sprintf(`Digest realm="%s", nonce="%s"`, config.realm(), auth.nonce)
+	basic := fmt.Sprintf(`Basic realm="%s"`, config.realm())
+	var challenge string
+	switch config.Method {
+	case RTSPAuthDigest:
+		challenge = digest
+	case RTSPAuthBasic:
+		challenge = basic
+	default:
+		challenge = digest + "\r\nWWW-Authenticate: " + basic
+	}
+	return map[string]string{"CSeq": cSEQ, "WWW-Authenticate": challenge}
+}
+
+// rtspAuthPassword password of user, internal 계정은 loopback 에서만
+func rtspAuthPassword(user string, users []RTSPUserST, remote string) (string, bool) {
+	if user == rtspInternalUser && remoteLoopback(remote) {
+		return rtspInternalPassword, true
+	}
+	for _, val := range users {
+		if val.User == user && val.User != rtspInternalUser {
+			return val.Password, true
+		}
+	}
+	return "", false
+}
+
+// rtspAuthUsername user name of Authorization credentials
+func rtspAuthUsername(scheme string, credentials string) string {
+	if strings.EqualFold(scheme, "Basic") {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+		if err != nil {
+			return ""
+		}
+		user, _, _ := strings.Cut(string(decoded), ":")
+		return user
+	}
+	for _, param := range strings.Split(credentials, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if strings.EqualFold(key, "username") {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+// rtspAuthBasic check Basic base64(user:password)
+func rtspAuthBasic(credentials string, users []RTSPUserST, remote string) (string, bool) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if err != nil {
+		return "", false
+	}
+	user, password, _ := strings.Cut(string(decoded), ":")
+	expected, ok := rtspAuthPassword(user, users, remote)
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(expected)) != 1 {
+		return "", false
+	}
+	return user, true
+}
+
+// rtspAuthDigest check Digest response (rfc 2069, qop=auth 도 허용)
+func rtspAuthDigest(method string, credentials string, nonce string, realm string, users []RTSPUserST, remote string) (string, bool) {
+	params := make(map[string]string)
+	for _, param := range strings.Split(credentials, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		params[strings.ToLower(key)] = strings.Trim(value, `"`)
+	}
+	if params["nonce"] != nonce || params["realm"] != realm {
+		return "", false
+	}
+	password, ok := rtspAuthPassword(params["username"], users, remote)
+	if !ok {
+		return "", false
+	}
+	ha1 := rtspMD5(params["username"] + ":" + realm + ":" + password)
+	ha2 := rtspMD5(method + ":" + params["uri"])
+	expected := rtspMD5(ha1 + ":" + nonce + ":" + ha2)
+	if params["qop"] == "auth" {
+		expected = rtspMD5(ha1 + ":" + nonce + ":" + params["nc"] + ":" + params["cnonce"] + ":auth:" + ha2)
+	}
+	if subtle.ConstantTimeCompare([]byte(strings.ToLower(params["response"])), []byte(expected)) != 1 {
+		return "", false
+	}
+	return params["username"], true
+}
+
+// rtspMD5 hex md5
+func rtspMD5(val string) string {
+	sum := md5.Sum([]byte(val))
+	return hex.EncodeToString(sum[:])
+}
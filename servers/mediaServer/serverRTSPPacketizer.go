@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/aacparser"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/codec/h265parser"
+)
+
+// rtpMaxPayload keep interleaved rtp under common mtu
+const rtpMaxPayload = 1400
+
+// RTSPPacketizer make sdp and interleaved rtp from av packets
+// used by sources without own rtp (rtmp, srt ...) so rtsp re-serve and recording work
+type RTSPPacketizer struct {
+	tracks []*rtspPacketizerTrack
+}
+
+type rtspPacketizerTrack struct {
+	codec       av.CodecData
+	payloadType uint8
+	clockRate   uint32
+	channel     uint8
+	ssrc        uint32
+	sequence    uint16
+	baseTS      uint32
+}
+
+// NewRTSPPacketizer new packetizer, unsupported codecs skipped
+func NewRTSPPacketizer(codecs []av.CodecData) *RTSPPacketizer {
+	packetizer := &RTSPPacketizer{}
+	for i, codec := range codecs {
+		track := &rtspPacketizerTrack{
+			codec:    codec,
+			channel:  uint8(i * 2),
+			ssrc:     rand.Uint32(),
+			sequence: uint16(rand.Uint32()),
+			baseTS:   rand.Uint32(),
+		}
+		switch codec.Type() {
+		case av.H264, av.H265:
+			track.payloadType = uint8(96 + i)
+			track.clockRate = 90000
+		case av.AAC, av.OPUS:
+			track.payloadType = uint8(96 + i)
+			track.clockRate = uint32(codec.(av.AudioCodecData).SampleRate())
+		case av.PCM_MULAW:
+			track.payloadType = 0
+			track.clockRate = 8000
+		case av.PCM_ALAW:
+			track.payloadType = 8
+			track.clockRate = 8000
+		default:
+			track = nil
+		}
+		packetizer.tracks = append(packetizer.tracks, track)
+	}
+	return packetizer
+}
+
+// SDP session description for rtsp DESCRIBE
+func (packetizer *RTSPPacketizer) SDP() []byte {
+	var out strings.Builder
+	out.WriteString("v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=MediaServer\r\nc=IN IP4 0.0.0.0\r\nt=0 0\r\n")
+	for i, track := range packetizer.tracks {
+		if track == nil {
+			continue
+		}
+		pt := strconv.Itoa(int(track.payloadType))
+		switch codec := track.codec.(type) {
+		case h264parser.CodecData:
+			sps, pps := codec.SPS(), codec.PPS()
+			out.WriteString("m=video 0 RTP/AVP " + pt + "\r\n")
+			out.WriteString("a=rtpmap:" + pt + " H264/90000\r\n")
+			fmtp := "a=fmtp:" + pt + " packetization-mode=1"
+			if len(sps) >= 4 {
+				fmtp += ";profile-level-id=" + hex.EncodeToString(sps[1:4])
+			}
+			if len(sps) > 0 && len(pps) > 0 {
+				fmtp += ";sprop-parameter-sets=" + base64.StdEncoding.EncodeToString(sps) + "," + base64.StdEncoding.EncodeToString(pps)
+			}
+			out.WriteString(fmtp + "\r\n")
+		case h265parser.CodecData:
+			out.WriteString("m=video 0 RTP/AVP " + pt + "\r\n")
+			out.WriteString("a=rtpmap:" + pt + " H265/90000\r\n")
+			out.WriteString("a=fmtp:" + pt + " sprop-vps=" + base64.StdEncoding.EncodeToString(codec.VPS()) + ";sprop-sps=" + base64.StdEncoding.EncodeToString(codec.SPS()) + ";sprop-pps=" + base64.StdEncoding.EncodeToString(codec.PPS()) + "\r\n")
+		case aacparser.CodecData:
+			out.WriteString("m=audio 0 RTP/AVP " + pt + "\r\n")
+			out.WriteString("a=rtpmap:" + pt + " MPEG4-GENERIC/" + strconv.Itoa(codec.SampleRate()) + "/" + strconv.Itoa(codec.ChannelLayout().Count()) + "\r\n")
+			out.WriteString("a=fmtp:" + pt + " streamtype=5;profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=" + hex.EncodeToString(codec.MPEG4AudioConfigBytes()) + "\r\n")
+		default:
+			audio, ok := track.codec.(av.AudioCodecData)
+			if !ok {
+				continue
+			}
+			out.WriteString("m=audio 0 RTP/AVP " + pt + "\r\n")
+			switch track.codec.Type() {
+			case av.OPUS:
+				out.WriteString("a=rtpmap:" + pt + " opus/" + strconv.Itoa(audio.SampleRate()) + "/2\r\n")
+			case av.PCM_MULAW:
+				out.WriteString("a=rtpmap:" + pt + " PCMU/8000\r\n")
+			case av.PCM_ALAW:
+				out.WriteString("a=rtpmap:" + pt + " PCMA/8000\r\n")
+			}
+		}
+		out.WriteString("a=control:trackID=" + strconv.Itoa(i) + "\r\n")
+	}
+	return []byte(out.String())
+}
+
+// WritePacket split av packet to interleaved rtp packets
+func (packetizer *RTSPPacketizer) WritePacket(packet *av.Packet) []*[]byte {
+	if int(packet.Idx) >= len(packetizer.tracks) || packetizer.tracks[packet.Idx] == nil {
+		return nil
+	}
+	track := packetizer.tracks[packet.Idx]
+	timestamp := track.baseTS + uint32((packet.Time+packet.CompositionTime)*time.Duration(track.clockRate)/time.Second)
+	switch codec := track.codec.(type) {
+	case h264parser.CodecData:
+		nalus, _ := h264parser.SplitNALUs(packet.Data)
+		if packet.IsKeyFrame {
+			nalus = append([][]byte{codec.SPS(), codec.PPS()}, nalus...)
+		}
+		return track.writeNALUs(nalus, timestamp, false)
+	case h265parser.CodecData:
+		nalus, _ := h265parser.SplitNALUs(packet.Data)
+		if packet.IsKeyFrame {
+			nalus = append([][]byte{codec.VPS(), codec.SPS(), codec.PPS()}, nalus...)
+		}
+		return track.writeNALUs(nalus, timestamp, true)
+	case aacparser.CodecData:
+		payload := make([]byte, 4+len(packet.Data))
+		binary.BigEndian.PutUint16(payload[0:2], 16)
+		binary.BigEndian.PutUint16(payload[2:4], uint16(len(packet.Data)<<3))
+		copy(payload[4:], packet.Data)
+		return []*[]byte{track.rtp(payload, timestamp, true)}
+	default:
+		return []*[]byte{track.rtp(packet.Data, timestamp, true)}
+	}
+}
+
+// writeNALUs single nal or fragmentation unit (h264 FU-A, h265 FU)
+func (track *rtspPacketizerTrack) writeNALUs(nalus [][]byte, timestamp uint32, hevc bool) []*[]byte {
+	var out []*[]byte
+	headerLen := 1
+	if hevc {
+		headerLen = 2
+	}
+	for i, nalu := range nalus {
+		if len(nalu) <= headerLen {
+			continue
+		}
+		last := i == len(nalus)-1
+		if len(nalu) <= rtpMaxPayload {
+			out = append(out, track.rtp(nalu, timestamp, last))
+			continue
+		}
+		var fuIndicator []byte
+		var naluType byte
+		if hevc {
+			naluType = (nalu[0] >> 1) & 0x3f
+			fuIndicator = []byte{(nalu[0] & 0x81) | (49 << 1), nalu[1]}
+		} else {
+			naluType = nalu[0] & 0x1f
+			fuIndicator = []byte{(nalu[0] & 0xe0) | 28}
+		}
+		data := nalu[headerLen:]
+		first := true
+		for len(data) > 0 {
+			size := rtpMaxPayload - headerLen - 1
+			if size > len(data) {
+				size = len(data)
+			}
+			fuHeader := naluType
+			if first {
+				fuHeader |= 0x80
+			}
+			end := size == len(data)
+			if end {
+				fuHeader |= 0x40
+			}
+			payload := make([]byte, 0, len(fuIndicator)+1+size)
+			payload = append(payload, fuIndicator...)
+			payload = append(payload, fuHeader)
+			payload = append(payload, data[:size]...)
+			out = append(out, track.rtp(payload, timestamp, last && end))
+			data = data[size:]
+			first = false
+		}
+	}
+	return out
+}
+
+// rtp make interleaved rtp packet ($ channel length header + rtp header + payload)
+func (track *rtspPacketizerTrack) rtp(payload []byte, timestamp uint32, marker bool) *[]byte {
+	buf := make([]byte, 4+12+len(payload))
+	buf[0] = 0x24
+	buf[1] = track.channel
+	binary.BigEndian.PutUint16(buf[2:4], uint16(12+len(payload)))
+	buf[4] = 0x80
+	buf[5] = track.payloadType
+	if marker {
+		buf[5] |= 0x80
+	}
+	binary.BigEndian.PutUint16(buf[6:8], track.sequence)
+	binary.BigEndian.PutUint32(buf[8:12], timestamp)
+	binary.BigEndian.PutUint32(buf[12:16], track.ssrc)
+	copy(buf[16:], payload)
+	track.sequence++
+	return &buf
+}
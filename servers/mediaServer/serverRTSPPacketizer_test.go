@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/aacparser"
+	"github.com/deepch/vdk/codec/h264parser"
+)
+
+// 480x768 baseline sps / pps
+var (
+	packetizerTestSPS = []byte{0x27, 0x42, 0xe0, 0x29, 0x89, 0x8d, 0x50, 0xf0, 0x30, 0xd2, 0x10, 0x00, 0x00, 0x04, 0x00, 0x00, 0x40, 0x00, 0x00, 0x05, 0x00, 0x01, 0xa5, 0x80, 0x15, 0x18, 0x00, 0xee, 0x6b, 0xde, 0xec, 0x1e, 0x2c, 0x4b, 0x70}
+	packetizerTestPPS = []byte{0x28, 0xce, 0x04, 0x72}
+)
+
+// packetizerTestCodecs h264 + aac 44100 stereo
+func packetizerTestCodecs(t *testing.T) []av.CodecData {
+	t.Helper()
+	video, err := h264parser.NewCodecDataFromSPSAndPPS(packetizerTestSPS, packetizerTestPPS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audio, err := aacparser.NewCodecDataFromMPEG4AudioConfigBytes([]byte{0x12, 0x10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []av.CodecData{video, audio}
+}
+
+// packetizerTestRTP interleaved rtp header fields
+type packetizerTestRTP struct {
+	channel     byte
+	marker      bool
+	payloadType byte
+	sequence    uint16
+	timestamp   uint32
+	ssrc        uint32
+	payload     []byte
+}
+
+func packetizerTestParse(t *testing.T, packet []byte) packetizerTestRTP {
+	t.Helper()
+	if len(packet) < 16 || packet[0] != 0x24 || int(binary.BigEndian.Uint16(packet[2:4])) != len(packet)-4 || packet[4] != 0x80 {
+		t.Fatalf("bad interleaved rtp % x", packet[:16])
+	}
+	return packetizerTestRTP{
+		channel:     packet[1],
+		marker:      packet[5]&0x80 != 0,
+		payloadType: packet[5] & 0x7f,
+		sequence:    binary.BigEndian.Uint16(packet[6:8]),
+		timestamp:   binary.BigEndian.Uint32(packet[8:12]),
+		ssrc:        binary.BigEndian.Uint32(packet[12:16]),
+		payload:     packet[16:],
+	}
+}
+
+func TestRTSPPacketizerSDP(t *testing.T) {
+	sdp := string(NewRTSPPacketizer(packetizerTestCodecs(t)).SDP())
+	for _, line := range []string{
+		"m=video 0 RTP/AVP 96",
+		"a=rtpmap:96 H264/90000",
+		"a=fmtp:96 packetization-mode=1;profile-level-id=42e029;sprop-parameter-sets=" + base64.StdEncoding.EncodeToString(packetizerTestSPS) + "," + base64.StdEncoding.EncodeToString(packetizerTestPPS),
+		"a=control:trackID=0",
+		"m=audio 0 RTP/AVP 97",
+		"a=rtpmap:97 MPEG4-GENERIC/44100/2",
+		"config=1210",
+		"a=control:trackID=1",
+	} {
+		if !strings.Contains(sdp, line) {
+			t.Errorf("sdp missing %q\n%s", line, sdp)
+		}
+	}
+	if rates := rtspSDPClockRates([]byte(sdp)); len(rates) != 2 || rates[0] != 90000 || rates[1] != 44100 {
+		t.Errorf("sdp clock rates %v", rates)
+	}
+}
+
+func TestRTSPPacketizerH264(t *testing.T) {
+	packetizer := NewRTSPPacketizer(packetizerTestCodecs(t))
+	// avcc idr 3000 byte, mtu 를 넘어서 FU-A 로 나뉜다
+	idr := make([]byte, 3000)
+	idr[0] = 0x65
+	for i := 1; i < len(idr); i++ {
+		idr[i] = byte(i)
+	}
+	data := binary.BigEndian.AppendUint32(nil, uint32(len(idr)))
+	data = append(data, idr...)
+	out := packetizer.WritePacket(&av.Packet{Idx: 0, IsKeyFrame: true, Data: data})
+	if len(out) != 5 {
+		t.Fatalf("keyframe rtp packets %d, want 5 (sps, pps, 3 x FU-A)", len(out))
+	}
+	var packets []packetizerTestRTP
+	for _, packet := range out {
+		packets = append(packets, packetizerTestParse(t, *packet))
+	}
+	if !bytes.Equal(packets[0].payload, packetizerTestSPS) || !bytes.Equal(packets[1].payload, packetizerTestPPS) {
+		t.Error("keyframe does not start with sps, pps")
+	}
+	var fu []byte
+	for i, packet := range packets {
+		if packet.channel != 0 || packet.payloadType != 96 || packet.ssrc != packets[0].ssrc || packet.timestamp != packets[0].timestamp {
+			t.Errorf("packet %d header %+v", i, packet)
+		}
+		if packet.sequence != packets[0].sequence+uint16(i) {
+			t.Errorf("packet %d sequence %d, want %d", i, packet.sequence, packets[0].sequence+uint16(i))
+		}
+		if packet.marker != (i == len(packets)-1) {
+			t.Errorf("packet %d marker %v", i, packet.marker)
+		}
+		if len(packet.payload) > rtpMaxPayload {
+			t.Errorf("packet %d payload %d over %d", i, len(packet.payload), rtpMaxPayload)
+		}
+		if i < 2 {
+			continue
+		}
+		// FU indicator (nri + 28), FU header (start / end + type)
+		wantHeader := byte(0x05)
+		switch i {
+		case 2:
+			wantHeader |= 0x80
+		case len(packets) - 1:
+			wantHeader |= 0x40
+		}
+		if packet.payload[0] != 0x7c || packet.payload[1] != wantHeader {
+			t.Errorf("packet %d FU-A header % x, want 7c %02x", i, packet.payload[:2], wantHeader)
+		}
+		fu = append(fu, packet.payload[2:]...)
+	}
+	if !bytes.Equal(append([]byte{0x65}, fu...), idr) {
+		t.Error("FU-A fragments do not rebuild idr")
+	}
+	// 90kHz, 40ms 뒤 frame
+	next := packetizer.WritePacket(&av.Packet{Idx: 0, Time: 40 * time.Millisecond, Data: []byte{0, 0, 0, 2, 0x41, 0x9a}})
+	if len(next) != 1 {
+		t.Fatalf("p frame rtp packets %d, want 1", len(next))
+	}
+	packet := packetizerTestParse(t, *next[0])
+	if packet.timestamp-packets[0].timestamp != 3600 || !packet.marker || !bytes.Equal(packet.payload, []byte{0x41, 0x9a}) {
+		t.Errorf("p frame %+v", packet)
+	}
+}
+
+func TestRTSPPacketizerAAC(t *testing.T) {
+	packetizer := NewRTSPPacketizer(packetizerTestCodecs(t))
+	frame := bytes.Repeat([]byte{0xaa}, 300)
+	first := packetizerTestParse(t, *packetizer.WritePacket(&av.Packet{Idx: 1, Data: frame})[0])
+	second := packetizerTestParse(t, *packetizer.WritePacket(&av.Packet{Idx: 1, Time: time.Second, Data: frame})[0])
+	if first.channel != 2 || first.payloadType != 97 || !first.marker {
+		t.Errorf("aac header %+v", first)
+	}
+	// AU-headers-length 16 bit, AU-size 13 bit + index 3 bit
+	if binary.BigEndian.Uint16(first.payload[0:2]) != 16 || binary.BigEndian.Uint16(first.payload[2:4]) != 300<<3 || !bytes.Equal(first.payload[4:], frame) {
+		t.Errorf("aac payload % x", first.payload[:4])
+	}
+	if second.timestamp-first.timestamp != 44100 || second.sequence != first.sequence+1 {
+		t.Errorf("aac second packet %+v, first %+v", second, first)
+	}
+	// 알 수 없는 track 은 무시
+	if out := packetizer.WritePacket(&av.Packet{Idx: 5, Data: frame}); out != nil {
+		t.Errorf("unknown track rtp %d packets", len(out))
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/format/ts"
+)
+
+// 녹화 재생 rtsp://host:5541/<uuid>/<channel>/replay?start=20260202T143912Z
+const (
+	rtspReplayPath = "replay"
+	// rtspClockLayout Range clock= (utc, iso 8601 basic)
+	rtspClockLayout = "20060102T150405Z"
+	// rtspReplayKeyScale 이 배속 이상이면 keyframe 만 전송
+	rtspReplayKeyScale = 4
+	// rtspReplayGap 이 이상 비어있는 구간은 기다리지 않고 건너뜀
+	rtspReplayGap = 2 * time.Second
+	// rtspReplayWriteTimeout 이 시간 안에 쓰지 못하면 전송 중단, pause 가 막히지 않게
+	rtspReplayWriteTimeout = 10 * time.Second
+)
+
+// rtspReplaySegment recorded segment on timeline
+type rtspReplaySegment struct {
+	path     string
+	start    time.Time
+	duration time.Duration
+	key      *hlsKey
+	sequence int
+}
+
+// rtspReplay recording playback of one rtsp connection
+type rtspReplay struct {
+	streamID   string
+	channelID  string
+	begin      time.Time // npt 0
+	segments   []rtspReplaySegment
+	key        []byte
+	codecs     []av.CodecData
+	packetizer *RTSPPacketizer
+	mutex      sync.Mutex
+	position   time.Time // 마지막 전송 위치, PAUSE 후 PLAY 는 여기서 재개
+	stop       chan struct{}
+	done       chan struct{}
+	session    string // viewer session id, PLAY 후 등록
+}
+
+// rtspReplayURL replay request of url, start query (clock 또는 RFC 3339) 없으면 zero
+func rtspReplayURL(uri string) (bool, time.Time, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	st := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(st) < 3 || st[2] != rtspReplayPath {
+		return false, time.Time{}, nil
+	}
+	start := u.Query().Get("start")
+	if start == "" {
+		return true, time.Time{}, nil
+	}
+	begin, err := rtspParseClock(start)
+	if err != nil {
+		begin, err = time.Parse(time.RFC3339, start)
+	}
+	return true, begin, err
+}
+
+// rtspParseClock parse clock= time (20060102T150405Z, 소수 초 허용)
+func rtspParseClock(val string) (time.Time, error) {
+	if begin, err := time.Parse(rtspClockLayout, val); err == nil {
+		return begin, nil
+	}
+	return time.Parse("20060102T150405.999999999Z", val)
+}
+
+// newRTSPReplay index recordings of channel from begin, codec 은 첫 segment 에서
+func newRTSPReplay(streamID string, channelID string, begin time.Time) (*rtspReplay, error) {
+	segments := rtspReplayIndex(Storage.ServerRetentionRoot(), streamID, channelID, begin)
+	if len(segments) == 0 || !begin.IsZero() && !segments[len(segments)-1].end().After(begin) {
+		return nil, ErrorRTSPReplayNotFound
+	}
+	if begin.IsZero() || begin.Before(segments[0].start) {
+		begin = segments[0].start
+	}
+	replay := &rtspReplay{
+		streamID:  streamID,
+		channelID: channelID,
+		begin:     begin,
+		segments:  segments,
+		position:  begin,
+	}
+	// 녹화 암호화 키 (HTTPAPIServerRecordingKey 와 같은 파일)
+	key, err := os.ReadFile(filepath.Join("keys", fmt.Sprintf("%s_%s.key", streamID, channelID)))
+	if err == nil {
+		replay.key = key
+	}
+	demuxer, err := replay.open(segments[replay.find(begin)])
+	if err != nil {
+		return nil, err
+	}
+	if replay.codecs, err = demuxer.Streams(); err != nil {
+		return nil, err
+	}
+	replay.packetizer = NewRTSPPacketizer(replay.codecs)
+	return replay, nil
+}
+
+// rtspReplayIndex segments of all session playlists from day before begin, start 순
+func rtspReplayIndex(root string, streamID string, channelID string, begin time.Time) []rtspReplaySegment {
+	days, err := os.ReadDir(root)
+	if err != nil {
+		return nil
+	}
+	var from string
+	if !begin.IsZero() {
+		from = begin.Local().AddDate(0, 0, -1).Format("2006-01-02")
+	}
+	var segments []rtspReplaySegment
+	found := make(map[string]bool)
+	for _, day := range days {
+		if !day.IsDir() || day.Name() < from {
+			continue
+		}
+		dir := filepath.Join(root, day.Name(), streamID, channelID)
+		playlists, _ := filepath.Glob(filepath.Join(dir, "*.m3u8"))
+		for _, playlistPath := range playlists {
+			data, err := os.ReadFile(playlistPath)
+			if err != nil {
+				continue
+			}
+			playlist, err := hlsParsePlaylist(data, &url.URL{})
+			if err != nil {
+				continue
+			}
+			for _, val := range playlist.segments {
+				name := path.Base(val.uri)
+				if found[name] || len(name) < 18 || !strings.HasSuffix(name, ".ts") {
+					continue
+				}
+				// 세그먼트 파일명 <stream name>_YYYYMMDD_HHMMSS.ts (local time)
+				start, err := time.ParseInLocation("20060102_150405", strings.TrimSuffix(name, ".ts")[len(name)-18:], time.Local)
+				if err != nil {
+					continue
+				}
+				found[name] = true
+				// retention 으로 지워진 segment
+				if _, err = os.Stat(filepath.Join(dir, name)); err != nil {
+					continue
+				}
+				segments = append(segments, rtspReplaySegment{path: filepath.Join(dir, name), start: start, duration: val.duration, key: val.key, sequence: val.sequence})
+			}
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].start.Before(segments[j].start)
+	})
+	return segments
+}
+
+// end func
+func (segment rtspReplaySegment) end() time.Time {
+	return segment.start.Add(segment.duration)
+}
+
+// find segment index of time, 빈 구간이면 다음 segment
+func (replay *rtspReplay) find(at time.Time) int {
+	for i, segment := range replay.segments {
+		if segment.end().After(at) {
+			return i
+		}
+	}
+	return len(replay.segments)
+}
+
+// open read and decrypt segment
+func (replay *rtspReplay) open(segment rtspReplaySegment) (av.Demuxer, error) {
+	data, err := os.ReadFile(segment.path)
+	if err != nil {
+		return nil, err
+	}
+	if segment.key != nil {
+		if segment.key.method != "AES-128" || replay.key == nil {
+			return nil, ErrorStreamSourceUnsupported
+		}
+		if data, err = hlsDecryptAES128(data, replay.key, segment.key.iv, segment.sequence); err != nil {
+			return nil, err
+		}
+	}
+	return ts.NewDemuxer(bytes.NewReader(data)), nil
+}
+
+// rangeTime parse Range (clock= / npt=), 없으면 현재 위치부터
+func (replay *rtspReplay) rangeTime(header string) (time.Time, time.Time, error) {
+	replay.mutex.Lock()
+	from := replay.position
+	replay.mutex.Unlock()
+	if header == "" {
+		return from, time.Time{}, nil
+	}
+	unit, value, _ := strings.Cut(strings.TrimSpace(header), "=")
+	value, _, _ = strings.Cut(value, ";")
+	first, last, _ := strings.Cut(value, "-")
+	var end time.Time
+	var err error
+	switch strings.ToLower(unit) {
+	case "clock":
+		if from, err = rtspParseClock(first); err != nil {
+			return from, end, ErrorRTSPReplayRange
+		}
+		if last != "" {
+			if end, err = rtspParseClock(last); err != nil {
+				return from, end, ErrorRTSPReplayRange
+			}
+		}
+	case "npt":
+		if first != "now" && first != "" {
+			offset, err := rtspParseNPT(first)
+			if err != nil {
+				return from, end, ErrorRTSPReplayRange
+			}
+			from = replay.begin.Add(offset)
+		}
+		if last != "" {
+			offset, err := rtspParseNPT(last)
+			if err != nil {
+				return from, end, ErrorRTSPReplayRange
+			}
+			end = replay.begin.Add(offset)
+		}
+	default:
+		return from, end, ErrorRTSPReplayRange
+	}
+	if replay.find(from) >= len(replay.segments) {
+		return from, end, ErrorRTSPReplayNotFound
+	}
+	return from, end, nil
+}
+
+// rtspParseNPT npt seconds or hh:mm:ss(.f)
+func rtspParseNPT(val string) (time.Duration, error) {
+	var seconds float64
+	for _, part := range strings.Split(val, ":") {
+		number, err := strconv.ParseFloat(part, 64)
+		if err != nil || number < 0 {
+			return 0, ErrorRTSPReplayRange
+		}
+		seconds = seconds*60 + number
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// play start sending from time at scale, 재생 중이면 정지 후 새 위치
+func (replay *rtspReplay) play(from time.Time, end time.Time, scale float64, conn net.Conn) {
+	replay.pause()
+	replay.mutex.Lock()
+	replay.stop, replay.done = make(chan struct{}), make(chan struct{})
+	stop, done := replay.stop, replay.done
+	replay.mutex.Unlock()
+	go func() {
+		defer close(done)
+		err := replay.send(from, end, scale, conn, stop)
+		if err != nil {
+			log.Printf("[ERROR] [rtsp_server] [rtspReplay] [send] stream=%s channel=%s: %s", replay.streamID, replay.channelID, err.Error())
+		}
+	}()
+}
+
+// register viewer session of replay, limits 에서는 hls 처럼 channel client 밖에서 셈. kick 은 연결 종료
+func (replay *rtspReplay) register(remote string, user string, conn net.Conn) error {
+	if err := Storage.ClientLimit(replay.streamID, replay.channelID); err != nil {
+		return err
+	}
+	id, err := generateUUID()
+	if err != nil {
+		return err
+	}
+	replay.session = id
+	Sessions.Add(&SessionST{ID: id, StreamID: replay.streamID, ChannelID: replay.channelID, Protocol: SessionRTSP, Remote: remote, User: user, detached: true, kick: func() {
+		conn.Close()
+	}})
+	return nil
+}
+
+// close stop sending and unregister session
+func (replay *rtspReplay) close() {
+	replay.pause()
+	if replay.session != "" {
+		Sessions.Delete(replay.session)
+	}
+}
+
+// pause stop sending, position 유지
+func (replay *rtspReplay) pause() {
+	replay.mutex.Lock()
+	stop, done := replay.stop, replay.done
+	replay.stop, replay.done = nil, nil
+	replay.mutex.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// send segments from time, wall clock 에 맞춰 (scale 배속) interleaved rtp 전송
+func (replay *rtspReplay) send(from time.Time, end time.Time, scale float64, conn net.Conn, stop chan struct{}) error {
+	// 응답은 같은 연결로 나가므로 끝나면 deadline 해제
+	defer conn.SetWriteDeadline(time.Time{})
+	var wallStart, origin time.Time // 첫 keyframe 의 시간
+	var skip time.Duration          // 건너뛴 빈 구간
+	var started bool
+	for i := replay.find(from); i < len(replay.segments); i++ {
+		segment := replay.segments[i]
+		if gap := segment.start.Sub(replay.segments[max(i-1, 0)].end()); started && gap > rtspReplayGap {
+			skip += gap
+		}
+		demuxer, err := replay.open(segment)
+		if err != nil {
+			log.Printf("[WARN] [rtsp_server] [rtspReplay] [open] segment skipped: path=%s error=%v", segment.path, err)
+			continue
+		}
+		streams, err := demuxer.Streams()
+		if err != nil || len(streams) != len(replay.codecs) {
+			continue
+		}
+		first := time.Duration(-1)
+		for {
+			packet, err := demuxer.ReadPacket()
+			if err != nil {
+				break
+			}
+			if first < 0 {
+				first = packet.Time
+			}
+			at := segment.start.Add(packet.Time - first)
+			video := replay.codecs[packet.Idx].Type().IsVideo()
+			// 요청 위치 이후 첫 keyframe 부터
+			if !started {
+				if at.Before(from) || !video || !packet.IsKeyFrame {
+					continue
+				}
+				started, origin, wallStart = true, at, time.Now()
+			}
+			if !end.IsZero() && at.After(end) {
+				return nil
+			}
+			if scale != 1 && !video || scale >= rtspReplayKeyScale && !packet.IsKeyFrame {
+				continue
+			}
+			wait := time.Until(wallStart.Add(time.Duration(float64(at.Sub(origin)-skip) / scale)))
+			if wait > 0 {
+				select {
+				case <-stop:
+					return nil
+				case <-time.After(wait):
+				}
+			}
+			select {
+			case <-stop:
+				return nil
+			default:
+			}
+			// rtp timestamp 는 녹화 시간 기준 (begin 부터)
+			packet.Time = at.Sub(replay.begin)
+			for _, packetRTP := range replay.packetizer.WritePacket(&packet) {
+				conn.SetWriteDeadline(time.Now().Add(rtspReplayWriteTimeout))
+				if _, err = conn.Write(*packetRTP); err != nil {
+					return err
+				}
+				Sessions.Sent(replay.session, len(*packetRTP))
+			}
+			replay.mutex.Lock()
+			replay.position = at
+			replay.mutex.Unlock()
+		}
+	}
+	log.Printf("[INFO] [rtsp_server] [rtspReplay] end of recordings: stream=%s channel=%s", replay.streamID, replay.channelID)
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// rtspMaxHeader request line + header 최대 크기
+	rtspMaxHeader = 64 * 1024
+	// rtspMaxBody request body 최대 크기 (sdp, parameter)
+	rtspMaxBody = 1024 * 1024
+	// rtspSessionTimeout Session header timeout, 이 시간 요청이나 rtcp 가 없으면 세션 종료
+	rtspSessionTimeout = 60
+)
+
+// rtspRequest parsed rtsp request
+type rtspRequest struct {
+	Method string
+	URL    string
+	Header map[string]string // key 소문자
+	Body   []byte
+}
+
+// header value of header key, case insensitive
+func (req *rtspRequest) header(key string) string {
+	return req.Header[strings.ToLower(key)]
+}
+
+// cSeq CSeq header
+func (req *rtspRequest) cSeq() string {
+	return req.header("CSeq")
+}
+
+// session Session header without parameters (;timeout=)
+func (req *rtspRequest) session() string {
+	session, _, _ := strings.Cut(req.header("Session"), ";")
+	return strings.TrimSpace(session)
+}
+
+// readRTSPRequest read next request, partial read 와 pipelining 은 bufio 가 처리
+// client 가 보내는 interleaved ($) frame (tcp rtcp receiver report 등) 은 interleaved 로 넘기고 건너뛴다
+func readRTSPRequest(reader *bufio.Reader, interleaved func(channel byte, data []byte)) (*rtspRequest, error) {
+	for {
+		first, err := reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if first[0] != 0x24 {
+			break
+		}
+		frame := make([]byte, 4)
+		if _, err = io.ReadFull(reader, frame); err != nil {
+			return nil, err
+		}
+		data := make([]byte, int(frame[2])<<8|int(frame[3]))
+		if _, err = io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		if interleaved != nil {
+			interleaved(frame[1], data)
+		}
+	}
+	req := &rtspRequest{Header: make(map[string]string)}
+	size := 0
+	for {
+		line, err := readRTSPLine(reader, &size)
+		if err != nil {
+			return nil, err
+		}
+		if req.Method == "" {
+			// 요청 사이 빈 줄 허용
+			if line == "" {
+				continue
+			}
+			fields := strings.Fields(line)
+			if len(fields) != 3 || !strings.HasPrefix(fields[2], "RTSP/") {
+				return nil, ErrorRTSPRequestInvalid
+			}
+			req.Method, req.URL = strings.ToUpper(fields[0]), fields[1]
+			continue
+		}
+		if line == "" {
+			break
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, ErrorRTSPRequestInvalid
+		}
+		req.Header[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+	if length := req.header("Content-Length"); length != "" {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 {
+			return nil, ErrorRTSPRequestInvalid
+		}
+		if n > rtspMaxBody {
+			return nil, ErrorRTSPRequestTooLarge
+		}
+		req.Body = make([]byte, n)
+		if _, err = io.ReadFull(reader, req.Body); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// readRTSPLine read one line without "\r\n", header 전체가 rtspMaxHeader 를 넘으면 바로 중단
+// ReadSlice 는 bufio buffer 크기 만큼만 읽으므로 긴 line 도 할당이 제한된다
+func readRTSPLine(reader *bufio.Reader, size *int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		*size += len(chunk)
+		if *size > rtspMaxHeader {
+			return "", ErrorRTSPRequestTooLarge
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rtsp over http (apple tunnel), x-sessioncookie 가 같은 GET / POST 한 쌍
+// GET 연결로 rtsp 응답과 rtp 를 보내고, POST body (base64) 로 rtsp 요청을 받는다
+const rtspTunnelContentType = "application/x-rtsp-tunnelled"
+
+var rtspTunnels = struct {
+	mutex sync.Mutex
+	list  map[string]*rtspTunnelConn
+}{list: make(map[string]*rtspTunnelConn)}
+
+// rtspTunnelPost hijacked POST connection
+type rtspTunnelPost struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// rtspTunnelConn net.Conn of tunnel for RTSPServerClientHandle
+type rtspTunnelConn struct {
+	cookie   string
+	get      net.Conn
+	posts    chan rtspTunnelPost
+	post     *rtspTunnelPost
+	pending  []byte // 아직 decode 안 된 base64
+	decoded  []byte
+	mutex    sync.Mutex
+	deadline time.Time
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// RTSPTunnel middleware, x-sessioncookie 가 있는 GET / POST 는 어느 path 든 tunnel
+func RTSPTunnel() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cookie := c.GetHeader("x-sessioncookie")
+		if cookie == "" {
+			c.Next()
+			return
+		}
+		switch c.Request.Method {
+		case http.MethodGet:
+			c.Abort()
+			rtspTunnelGet(c, cookie)
+		case http.MethodPost:
+			c.Abort()
+			rtspTunnelPostAdd(c, cookie)
+		default:
+			c.Next()
+		}
+	}
+}
+
+// rtspTunnelGet open tunnel, rtsp session 이 끝날 때까지 block
+func rtspTunnelGet(c *gin.Context, cookie string) {
+	tunnel := &rtspTunnelConn{
+		cookie: cookie,
+		posts:  make(chan rtspTunnelPost, 4),
+		closed: make(chan struct{}),
+	}
+	rtspTunnels.mutex.Lock()
+	if _, ok := rtspTunnels.list[cookie]; ok {
+		rtspTunnels.mutex.Unlock()
+		log.Printf("[ERROR] [rtsp_server] [RTSPTunnel] [GET] session cookie in use: remote=%s cookie=%s", c.Request.RemoteAddr, cookie)
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	}
+	rtspTunnels.list[cookie] = tunnel
+	rtspTunnels.mutex.Unlock()
+	conn, _, err := c.Writer.Hijack()
+	if err != nil {
+		tunnel.remove()
+		log.Printf("[ERROR] [rtsp_server] [RTSPTunnel] [Hijack] remote=%s: %s", c.Request.RemoteAddr, err.Error())
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	tunnel.get = conn
+	// 길이 없는 응답, 연결이 닫힐 때까지 rtsp 를 보낸다
+	_, err = conn.Write([]byte("HTTP/1.0 200 OK\r\nServer: " + UserAgent + "\r\nConnection: close\r\nCache-Control: no-store\r\nPragma: no-cache\r\nContent-Type: " + rtspTunnelContentType + "\r\n\r\n"))
+	if err != nil {
+		tunnel.Close()
+		return
+	}
+	log.Printf("[INFO] [rtsp_server] [RTSPTunnel] tunnel open: remote=%s cookie=%s", conn.RemoteAddr().String(), cookie)
+	RTSPServerClientHandle(tunnel)
+}
+
+// rtspTunnelPostAdd hand POST connection to tunnel, POST 에는 응답하지 않는다
+func rtspTunnelPostAdd(c *gin.Context, cookie string) {
+	rtspTunnels.mutex.Lock()
+	tunnel, ok := rtspTunnels.list[cookie]
+	rtspTunnels.mutex.Unlock()
+	if !ok {
+		log.Printf("[ERROR] [rtsp_server] [RTSPTunnel] [POST] remote=%s cookie=%s: %s", c.Request.RemoteAddr, cookie, ErrorRTSPTunnelNotFound.Error())
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	conn, rw, err := c.Writer.Hijack()
+	if err != nil {
+		log.Printf("[ERROR] [rtsp_server] [RTSPTunnel] [Hijack] remote=%s: %s", c.Request.RemoteAddr, err.Error())
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	// client 는 요청마다 POST 를 새로 열 수도 있다
+	select {
+	case tunnel.posts <- rtspTunnelPost{conn: conn, reader: rw.Reader}:
+	case <-tunnel.closed:
+		conn.Close()
+	default:
+		conn.Close()
+	}
+}
+
+// Read decoded rtsp request bytes of POST body
+func (tunnel *rtspTunnelConn) Read(b []byte) (int, error) {
+	buf := make([]byte, 4096)
+	for len(tunnel.decoded) == 0 {
+		if tunnel.post == nil {
+			if err := tunnel.next(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := tunnel.post.reader.Read(buf)
+		for _, val := range buf[:n] {
+			if val != '\r' && val != '\n' && val != ' ' && val != '\t' {
+				tunnel.pending = append(tunnel.pending, val)
+			}
+		}
+		if decodeErr := tunnel.decode(); decodeErr != nil {
+			return 0, decodeErr
+		}
+		if err != nil {
+			tunnel.mutex.Lock()
+			tunnel.post.conn.Close()
+			tunnel.post = nil
+			tunnel.mutex.Unlock()
+			// POST 가 닫히면 다음 POST 를 기다린다
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return 0, err
+			}
+		}
+	}
+	n := copy(b, tunnel.decoded)
+	tunnel.decoded = tunnel.decoded[n:]
+	return n, nil
+}
+
+// decode complete base64 quads, 요청마다 padding 으로 끝나는 client 도 있다
+func (tunnel *rtspTunnelConn) decode() error {
+	for len(tunnel.pending) >= 4 {
+		end := len(tunnel.pending) / 4 * 4
+		if i := bytes.IndexByte(tunnel.pending[:end], '='); i >= 0 {
+			end = (i/4 + 1) * 4
+		}
+		out := make([]byte, base64.StdEncoding.DecodedLen(end))
+		n, err := base64.StdEncoding.Decode(out, tunnel.pending[:end])
+		if err != nil {
+			return ErrorRTSPRequestInvalid
+		}
+		tunnel.decoded = append(tunnel.decoded, out[:n]...)
+		tunnel.pending = tunnel.pending[end:]
+	}
+	return nil
+}
+
+// next wait POST connection until read deadline
+func (tunnel *rtspTunnelConn) next() error {
+	tunnel.mutex.Lock()
+	deadline := tunnel.deadline
+	tunnel.mutex.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case post := <-tunnel.posts:
+		tunnel.mutex.Lock()
+		tunnel.post = &post
+		tunnel.mutex.Unlock()
+		return post.conn.SetReadDeadline(deadline)
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	case <-tunnel.closed:
+		return net.ErrClosed
+	}
+}
+
+// Write rtsp response and interleaved rtp to GET connection
+func (tunnel *rtspTunnelConn) Write(b []byte) (int, error) {
+	return tunnel.get.Write(b)
+}
+
+// Close both connections and remove cookie
+func (tunnel *rtspTunnelConn) Close() error {
+	var err error
+	tunnel.once.Do(func() {
+		close(tunnel.closed)
+		tunnel.remove()
+		err = tunnel.get.Close()
+		tunnel.mutex.Lock()
+		if tunnel.post != nil {
+			tunnel.post.conn.Close()
+		}
+		tunnel.mutex.Unlock()
+		for {
+			select {
+			case post := <-tunnel.posts:
+				post.conn.Close()
+			default:
+				log.Printf("[INFO] [rtsp_server] [RTSPTunnel] tunnel close: remote=%s cookie=%s", tunnel.get.RemoteAddr().String(), tunnel.cookie)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// remove cookie from tunnel list
+func (tunnel *rtspTunnelConn) remove() {
+	rtspTunnels.mutex.Lock()
+	if rtspTunnels.list[tunnel.cookie] == tunnel {
+		delete(rtspTunnels.list, tunnel.cookie)
+	}
+	rtspTunnels.mutex.Unlock()
+}
+
+// LocalAddr of GET connection
+func (tunnel *rtspTunnelConn) LocalAddr() net.Addr {
+	return tunnel.get.LocalAddr()
+}
+
+// RemoteAddr of GET connection
+func (tunnel *rtspTunnelConn) RemoteAddr() net.Addr {
+	return tunnel.get.RemoteAddr()
+}
+
+// SetDeadline func
+func (tunnel *rtspTunnelConn) SetDeadline(t time.Time) error {
+	if err := tunnel.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return tunnel.SetWriteDeadline(t)
+}
+
+// SetReadDeadline POST 연결과 다음 POST 대기에 적용
+func (tunnel *rtspTunnelConn) SetReadDeadline(t time.Time) error {
+	tunnel.mutex.Lock()
+	defer tunnel.mutex.Unlock()
+	tunnel.deadline = t
+	if tunnel.post != nil {
+		return tunnel.post.conn.SetReadDeadline(t)
+	}
+	return nil
+}
+
+// SetWriteDeadline func
+func (tunnel *rtspTunnelConn) SetWriteDeadline(t time.Time) error {
+	return tunnel.get.SetWriteDeadline(t)
+}
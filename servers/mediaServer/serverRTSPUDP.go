@@ -0,0 +1,454 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"golang.org/x/net/ipv4"
+)
+
+// RTSP transport of session (SETUP Transport header)
+const (
+	rtspTransportTCP       = "tcp"       // interleaved
+	rtspTransportUDP       = "udp"       // unicast, server_port 할당
+	rtspTransportMulticast = "multicast" // channel multicast 설정 필요
+)
+
+const (
+	// rtspSenderReport rtcp sender report 주기
+	rtspSenderReport = 5 * time.Second
+)
+
+// rtspTransportST parsed SETUP Transport header
+type rtspTransportST struct {
+	mode        string
+	clientRTP   int
+	clientRTCP  int
+	interleaved int  // client 가 지정한 interleaved rtp channel, 없으면 -1
+	record      bool // mode=record (ANNOUNCE 후 publish)
+}
+
+// parseRTSPTransport first supported transport of SETUP request
+func parseRTSPTransport(header string) (rtspTransportST, bool) {
+	for _, spec := range strings.Split(header, ",") {
+		params := strings.Split(strings.TrimSpace(spec), ";")
+		transport := rtspTransportST{interleaved: -1}
+		switch strings.ToUpper(params[0]) {
+		case "RTP/AVP/TCP":
+			transport.mode = rtspTransportTCP
+		case "RTP/AVP", "RTP/AVP/UDP":
+			transport.mode = rtspTransportUDP
+		default:
+			continue
+		}
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			switch strings.ToLower(key) {
+			case "interleaved":
+				transport.mode = rtspTransportTCP
+				rtp, _, _ := strings.Cut(value, "-")
+				if channel, err := strconv.Atoi(rtp); err == nil && channel >= 0 && channel < 256 {
+					transport.interleaved = channel
+				}
+			case "mode":
+				transport.record = strings.EqualFold(strings.Trim(value, `"`), "record")
+			case "multicast":
+				transport.mode = rtspTransportMulticast
+			case "client_port":
+				rtpPort, rtcpPort, found := strings.Cut(value, "-")
+				transport.clientRTP = stringToInt(rtpPort)
+				transport.clientRTCP = transport.clientRTP + 1
+				if found {
+					transport.clientRTCP = stringToInt(rtcpPort)
+				}
+			}
+		}
+		if transport.mode == rtspTransportUDP && transport.clientRTP <= 0 {
+			continue
+		}
+		return transport, true
+	}
+	return rtspTransportST{}, false
+}
+
+// rtspSDPClockRates rtp clock rate by media order of sdp
+func rtspSDPClockRates(sdp []byte) []uint32 {
+	var rates []uint32
+	for _, line := range strings.Split(string(sdp), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "m="):
+			rate := uint32(90000)
+			if strings.HasPrefix(line, "m=audio") {
+				rate = 8000
+			}
+			rates = append(rates, rate)
+		case strings.HasPrefix(line, "a=rtpmap:") && len(rates) > 0:
+			// a=rtpmap:96 H264/90000, a=rtpmap:97 MPEG4-GENERIC/44100/2
+			if fields := strings.Split(line, "/"); len(fields) > 1 {
+				if rate, err := strconv.Atoi(fields[1]); err == nil && rate > 0 {
+					rates[len(rates)-1] = uint32(rate)
+				}
+			}
+		}
+	}
+	return rates
+}
+
+// rtspRTPTrack udp destination of one media, sender report 통계
+type rtspRTPTrack struct {
+	rtp       *net.UDPConn
+	rtcp      *net.UDPConn
+	dstRTP    *net.UDPAddr
+	dstRTCP   *net.UDPAddr
+	clockRate uint32
+	ssrc      uint32
+	packets   uint32
+	octets    uint32
+	lastTS    uint32
+	lastTime  time.Time
+}
+
+// write send rtp packet (interleaved header 제외)
+func (track *rtspRTPTrack) write(packet []byte, now time.Time) error {
+	if len(packet) < 12 {
+		return nil
+	}
+	if _, err := track.rtp.WriteToUDP(packet, track.dstRTP); err != nil {
+		return err
+	}
+	track.ssrc = binary.BigEndian.Uint32(packet[8:12])
+	track.lastTS = binary.BigEndian.Uint32(packet[4:8])
+	track.lastTime = now
+	track.packets++
+	track.octets += uint32(len(packet) - 12 - int(packet[0]&0x0f)*4)
+	return nil
+}
+
+// senderReport send rtcp SR, rtp timestamp 는 마지막 packet 에서 경과시간 만큼 진행
+func (track *rtspRTPTrack) senderReport(now time.Time) error {
+	if track.packets == 0 {
+		return nil
+	}
+	report, err := (&rtcp.SenderReport{
+		SSRC: track.ssrc,
+		// ntp, 1900 기준
+		NTPTime:     uint64(now.Unix()+2208988800)<<32 | uint64(now.Nanosecond())<<32/1e9,
+		RTPTime:     track.lastTS + uint32(now.Sub(track.lastTime).Seconds()*float64(track.clockRate)),
+		PacketCount: track.packets,
+		OctetCount:  track.octets,
+	}).Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = track.rtcp.WriteToUDP(report, track.dstRTCP)
+	return err
+}
+
+// rtspRTPSend send interleaved packet to track of its channel, source rtcp (홀수 channel) 는 버림
+func rtspRTPSend(tracks map[byte]*rtspRTPTrack, packet []byte, now time.Time) (int, error) {
+	if len(packet) < 4 || packet[0] != 0x24 || packet[1]%2 != 0 {
+		return 0, nil
+	}
+	track, ok := tracks[packet[1]]
+	if !ok {
+		return 0, nil
+	}
+	return len(packet) - 4, track.write(packet[4:], now)
+}
+
+// rtspUDPListenPair even rtp port and rtcp port+1, rtsp_udp_port_min ~ max 또는 임의 port
+func rtspUDPListenPair() (*net.UDPConn, *net.UDPConn, error) {
+	portMin, portMax := Storage.ServerRTSPUDPPorts()
+	for i := 0; i < 100; i++ {
+		port := 0
+		if portMin > 0 && portMax > portMin {
+			port = (int(portMin) + rand.Intn(int(portMax-portMin))) &^ 1
+			if port < int(portMin) {
+				port += 2
+			}
+		}
+		rtp, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+		if err != nil {
+			continue
+		}
+		port = rtp.LocalAddr().(*net.UDPAddr).Port
+		if port%2 != 0 {
+			rtp.Close()
+			continue
+		}
+		rtcp, err := net.ListenUDP("udp", &net.UDPAddr{Port: port + 1})
+		if err != nil {
+			rtp.Close()
+			continue
+		}
+		return rtp, rtcp, nil
+	}
+	return nil, nil, ErrorRTSPUDPPorts
+}
+
+// rtspUDPSession unicast udp tracks of one rtsp session
+type rtspUDPSession struct {
+	tracks map[byte]*rtspRTPTrack
+}
+
+// setup listen server ports for track of interleaved channel, return server rtp port
+func (session *rtspUDPSession) setup(channel byte, remote net.Addr, transport rtspTransportST, clockRate uint32) (int, error) {
+	if track, ok := session.tracks[channel]; ok {
+		return track.rtp.LocalAddr().(*net.UDPAddr).Port, nil
+	}
+	rtp, rtcp, err := rtspUDPListenPair()
+	if err != nil {
+		return 0, err
+	}
+	var ip net.IP
+	if addr, ok := remote.(*net.TCPAddr); ok {
+		ip = addr.IP
+	}
+	session.tracks[channel] = &rtspRTPTrack{
+		rtp:       rtp,
+		rtcp:      rtcp,
+		dstRTP:    &net.UDPAddr{IP: ip, Port: transport.clientRTP},
+		dstRTCP:   &net.UDPAddr{IP: ip, Port: transport.clientRTCP},
+		clockRate: clockRate,
+	}
+	return rtp.LocalAddr().(*net.UDPAddr).Port, nil
+}
+
+// receive read client rtcp (receiver report) until closed, rtcp 가 오면 세션 유지
+func (session *rtspUDPSession) receive(conn net.Conn) {
+	for _, track := range session.tracks {
+		go func(rtcp *net.UDPConn) {
+			buf := make([]byte, 1500)
+			for {
+				if _, _, err := rtcp.ReadFromUDP(buf); err != nil {
+					return
+				}
+				conn.SetReadDeadline(time.Now().Add(rtspSessionTimeout * time.Second))
+			}
+		}(track.rtcp)
+	}
+}
+
+// close close server ports
+func (session *rtspUDPSession) close() {
+	for _, track := range session.tracks {
+		track.rtp.Close()
+		track.rtcp.Close()
+	}
+}
+
+// RTSPServerClientPlayUDP send client queue over udp until queue closed or rtsp session end (done)
+func RTSPServerClientPlayUDP(uuid string, channel string, cid string, ch chan *[]byte, session *rtspUDPSession, conn net.Conn, done chan struct{}) {
+	defer func() {
+		Storage.ClientDelete(uuid, cid, channel)
+		session.close()
+		log.Printf("[INFO] [rtsp_server] [RTSPServerClientPlayUDP] [ClientDelete] Client offline: stream=%s channel=%s", uuid, channel)
+		conn.Close()
+	}()
+	session.receive(conn)
+	report := time.NewTicker(rtspSenderReport)
+	defer report.Stop()
+	noVideo := time.NewTimer(10 * time.Second)
+	for {
+		select {
+		case <-done:
+			return
+		case <-noVideo.C:
+			return
+		case now := <-report.C:
+			for _, track := range session.tracks {
+				if err := track.senderReport(now); err != nil {
+					log.Printf("[ERROR] [rtsp_server] [RTSPServerClientPlayUDP] [senderReport] stream=%s channel=%s: %s", uuid, channel, err.Error())
+					return
+				}
+			}
+		case pck, ok := <-ch:
+			if !ok {
+				log.Printf("[ERROR] [rtsp_server] [RTSPServerClientPlayUDP] [ErrorClientSlow] stream=%s channel=%s: %s", uuid, channel, ErrorClientSlow.Error())
+				return
+			}
+			noVideo.Reset(10 * time.Second)
+			n, err := rtspRTPSend(session.tracks, *pck, time.Now())
+			if err != nil {
+				log.Printf("[ERROR] [rtsp_server] [RTSPServerClientPlayUDP] [Write] stream=%s channel=%s: %s", uuid, channel, err.Error())
+				return
+			}
+			Sessions.Sent(cid, n)
+		}
+	}
+}
+
+// rtspMulticastST multicast sender of channel, rtsp 세션들이 공유
+type rtspMulticastST struct {
+	conn    *net.UDPConn
+	tracks  map[byte]*rtspRTPTrack
+	viewers map[net.Conn]string // viewer 연결, session id
+	done    chan struct{}
+}
+
+// rtspMulticasts running multicast senders by stream/channel
+var rtspMulticasts = struct {
+	mutex sync.Mutex
+	list  map[string]*rtspMulticastST
+}{list: make(map[string]*rtspMulticastST)}
+
+// rtspMulticastTransport Transport header of multicast channel
+func rtspMulticastTransport(config *ChannelMulticastST, channel byte) string {
+	port := config.port() + int(channel)
+	transport := "RTP/AVP;multicast;destination=" + config.Group + ";port=" + strconv.Itoa(port) + "-" + strconv.Itoa(port+1) + ";ttl=" + strconv.Itoa(config.ttl())
+	if config.Source != "" {
+		transport += ";source=" + config.Source
+	}
+	return transport
+}
+
+// rtspMulticastJoin add viewer to channel multicast, first viewer start sender
+// sender 는 internal client 하나, viewer 는 각각 detached session 으로 limits 와 session 목록에 들어간다
+func rtspMulticastJoin(uuid string, channel string, viewer net.Conn, user string) error {
+	key := uuid + "/" + channel
+	rtspMulticasts.mutex.Lock()
+	defer rtspMulticasts.mutex.Unlock()
+	if err := Storage.ClientLimit(uuid, channel); err != nil {
+		return err
+	}
+	if multicast, ok := rtspMulticasts.list[key]; ok {
+		session, err := rtspMulticastSession(uuid, channel, viewer, user)
+		if err != nil {
+			return err
+		}
+		multicast.viewers[viewer] = session
+		return nil
+	}
+	config := Storage.StreamChannelMulticast(uuid, channel)
+	if config == nil {
+		return ErrorRTSPMulticastDisabled
+	}
+	sdp, err := Storage.StreamChannelSDP(uuid, channel)
+	if err != nil {
+		return err
+	}
+	group := net.ParseIP(config.Group)
+	if group == nil || !group.IsMulticast() {
+		return ErrorRTSPMulticastDisabled
+	}
+	// ssm 은 source 주소에서 송신
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP(config.Source)})
+	if err != nil {
+		return err
+	}
+	if err = ipv4.NewPacketConn(conn).SetMulticastTTL(config.ttl()); err != nil {
+		conn.Close()
+		return err
+	}
+	cid, _, ch, err := Storage.ClientAdd(uuid, channel, RTSP, config.Group, "multicast", true)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	session, err := rtspMulticastSession(uuid, channel, viewer, user)
+	if err != nil {
+		Storage.ClientDelete(uuid, cid, channel)
+		conn.Close()
+		return err
+	}
+	multicast := &rtspMulticastST{conn: conn, tracks: make(map[byte]*rtspRTPTrack), viewers: map[net.Conn]string{viewer: session}, done: make(chan struct{})}
+	for i, clockRate := range rtspSDPClockRates(sdp) {
+		port := config.port() + i*2
+		multicast.tracks[byte(i*2)] = &rtspRTPTrack{
+			rtp:       conn,
+			rtcp:      conn,
+			dstRTP:    &net.UDPAddr{IP: group, Port: port},
+			dstRTCP:   &net.UDPAddr{IP: group, Port: port + 1},
+			clockRate: clockRate,
+		}
+	}
+	rtspMulticasts.list[key] = multicast
+	go multicast.run(uuid, channel, cid, ch)
+	log.Printf("[INFO] [rtsp_server] [rtspMulticastJoin] Multicast start: stream=%s channel=%s group=%s", uuid, channel, config.Group)
+	return nil
+}
+
+// rtspMulticastSession register multicast viewer as detached session, kick 은 viewer 연결 종료
+func rtspMulticastSession(uuid string, channel string, viewer net.Conn, user string) (string, error) {
+	id, err := generateUUID()
+	if err != nil {
+		return "", err
+	}
+	Sessions.Add(&SessionST{ID: id, StreamID: uuid, ChannelID: channel, Protocol: SessionRTSP, Remote: sessionRemote(viewer.RemoteAddr()), User: user, detached: true, kick: func() {
+		viewer.Close()
+	}})
+	return id, nil
+}
+
+// rtspMulticastLeave remove viewer, last viewer stop sender
+func rtspMulticastLeave(uuid string, channel string, viewer net.Conn) {
+	key := uuid + "/" + channel
+	rtspMulticasts.mutex.Lock()
+	defer rtspMulticasts.mutex.Unlock()
+	multicast, ok := rtspMulticasts.list[key]
+	if !ok {
+		return
+	}
+	session, ok := multicast.viewers[viewer]
+	if !ok {
+		return
+	}
+	Sessions.Delete(session)
+	delete(multicast.viewers, viewer)
+	if len(multicast.viewers) == 0 {
+		delete(rtspMulticasts.list, key)
+		close(multicast.done)
+	}
+}
+
+// run send channel to group until last viewer leave or queue closed, 종료시 남은 viewer 연결 종료
+func (multicast *rtspMulticastST) run(uuid string, channel string, cid string, ch chan *[]byte) {
+	defer func() {
+		key := uuid + "/" + channel
+		rtspMulticasts.mutex.Lock()
+		if rtspMulticasts.list[key] == multicast {
+			delete(rtspMulticasts.list, key)
+		}
+		for viewer, session := range multicast.viewers {
+			Sessions.Delete(session)
+			viewer.Close()
+		}
+		rtspMulticasts.mutex.Unlock()
+		Storage.ClientDelete(uuid, cid, channel)
+		multicast.conn.Close()
+		log.Printf("[INFO] [rtsp_server] [rtspMulticastRun] Multicast stop: stream=%s channel=%s", uuid, channel)
+	}()
+	report := time.NewTicker(rtspSenderReport)
+	defer report.Stop()
+	for {
+		select {
+		case <-multicast.done:
+			return
+		case now := <-report.C:
+			for _, track := range multicast.tracks {
+				if err := track.senderReport(now); err != nil {
+					log.Printf("[ERROR] [rtsp_server] [rtspMulticastRun] [senderReport] stream=%s channel=%s: %s", uuid, channel, err.Error())
+				}
+			}
+		case pck, ok := <-ch:
+			if !ok {
+				log.Printf("[ERROR] [rtsp_server] [rtspMulticastRun] [ClientQueue] stream=%s channel=%s: %s", uuid, channel, ErrorClientSlow.Error())
+				return
+			}
+			n, err := rtspRTPSend(multicast.tracks, *pck, time.Now())
+			if err != nil {
+				log.Printf("[ERROR] [rtsp_server] [rtspMulticastRun] [Write] stream=%s channel=%s: %s", uuid, channel, err.Error())
+				continue
+			}
+			Sessions.Sent(cid, n)
+		}
+	}
+}
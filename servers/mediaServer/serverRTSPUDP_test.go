@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+func TestParseRTSPTransport(t *testing.T) {
+	tests := []struct {
+		header string
+		ok     bool
+		want   rtspTransportST
+	}{
+		{"RTP/AVP;unicast;client_port=5000-5001", true, rtspTransportST{mode: rtspTransportUDP, clientRTP: 5000, clientRTCP: 5001, interleaved: -1}},
+		{"RTP/AVP/UDP;unicast;client_port=6000", true, rtspTransportST{mode: rtspTransportUDP, clientRTP: 6000, clientRTCP: 6001, interleaved: -1}},
+		{"RTP/AVP/TCP;unicast;interleaved=2-3", true, rtspTransportST{mode: rtspTransportTCP, interleaved: 2}},
+		{"RTP/AVP/TCP;unicast;interleaved=0-1;mode=\"RECORD\"", true, rtspTransportST{mode: rtspTransportTCP, interleaved: 0, record: true}},
+		{"RTP/AVP;multicast;destination=239.0.0.1;port=5000-5001", true, rtspTransportST{mode: rtspTransportMulticast, interleaved: -1}},
+		// udp 는 client_port 가 없으면 다음 후보
+		{"RTP/AVP;unicast, RTP/AVP/TCP;unicast;interleaved=0-1", true, rtspTransportST{mode: rtspTransportTCP, interleaved: 0}},
+		{"RTP/SAVP;unicast;client_port=5000-5001", false, rtspTransportST{}},
+		{"", false, rtspTransportST{}},
+	}
+	for _, test := range tests {
+		got, ok := parseRTSPTransport(test.header)
+		if ok != test.ok || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseRTSPTransport(%q) = %+v, %v, want %+v, %v", test.header, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestRTSPSDPClockRates(t *testing.T) {
+	sdp := "v=0\r\n" +
+		"m=video 0 RTP/AVP 96\r\n" +
+		"a=rtpmap:96 H264/90000\r\n" +
+		"m=audio 0 RTP/AVP 97\r\n" +
+		"a=rtpmap:97 MPEG4-GENERIC/44100/2\r\n" +
+		"m=audio 0 RTP/AVP 0\r\n"
+	got := rtspSDPClockRates([]byte(sdp))
+	want := []uint32{90000, 44100, 8000}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rtspSDPClockRates = %v, want %v", got, want)
+	}
+	if got := rtspSDPClockRates(nil); len(got) != 0 {
+		t.Errorf("rtspSDPClockRates(nil) = %v, want empty", got)
+	}
+}
+
+func TestRTSPUDPListenPair(t *testing.T) {
+	rtspTestStorage(t)
+	Storage.Server.RTSPUDPPortMin, Storage.Server.RTSPUDPPortMax = 41000, 41100
+	rtp, rtcp, err := rtspUDPListenPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rtp.Close()
+	defer rtcp.Close()
+	rtpPort := rtp.LocalAddr().(*net.UDPAddr).Port
+	rtcpPort := rtcp.LocalAddr().(*net.UDPAddr).Port
+	if rtpPort%2 != 0 || rtpPort < 41000 || rtpPort > 41100 {
+		t.Errorf("rtp port %d, want even in 41000-41100", rtpPort)
+	}
+	if rtcpPort != rtpPort+1 {
+		t.Errorf("rtcp port %d, want %d", rtcpPort, rtpPort+1)
+	}
+}
+
+func TestRTSPRTPTrackSenderReport(t *testing.T) {
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	dst := client.LocalAddr().(*net.UDPAddr)
+	track := &rtspRTPTrack{rtp: server, rtcp: server, dstRTP: dst, dstRTCP: dst, clockRate: 90000}
+	// 보낸 packet 이 없으면 sender report 없음
+	now := time.Now()
+	if err = track.senderReport(now); err != nil {
+		t.Fatal(err)
+	}
+	packet := rtspTestRTP(0, 1, 1000, 0x11223344, 100)
+	if err = track.write(packet[4:], now); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if n, _, err := client.ReadFromUDP(buf); err != nil || n != len(packet)-4 {
+		t.Fatalf("rtp read %d, %v", n, err)
+	}
+	// rtp 시간은 마지막 packet 이후 경과 시간만큼 진행
+	if err = track.senderReport(now.Add(500 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	n, _, err := client.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets, err := rtcp.Unmarshal(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, ok := packets[0].(*rtcp.SenderReport)
+	if !ok {
+		t.Fatalf("rtcp %T, want sender report", packets[0])
+	}
+	if report.SSRC != 0x11223344 || report.RTPTime != 1000+45000 || report.PacketCount != 1 || report.OctetCount != 100 {
+		t.Errorf("sender report %+v", report)
+	}
+	if ntp := int64(report.NTPTime>>32) - 2208988800; ntp != now.Add(500*time.Millisecond).Unix() {
+		t.Errorf("ntp seconds %d, want %d", ntp, now.Add(500*time.Millisecond).Unix())
+	}
+}
+
+func TestRTSPMulticastJoinLeave(t *testing.T) {
+	uuid := rtspTestStorage(t)
+	channel := Storage.Streams[uuid].Channels["0"]
+	channel.Multicast = &ChannelMulticastST{Group: "239.255.0.1", Port: 42000}
+	Storage.Streams[uuid].Channels["0"] = channel
+	var viewers []net.Conn
+	for i := 0; i < 2; i++ {
+		viewer, peer := net.Pipe()
+		defer peer.Close()
+		viewers = append(viewers, viewer)
+		if err := rtspMulticastJoin(uuid, "0", viewer, "viewer"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 모든 viewer 가 sender (client) 하나를 공유, viewer 는 각각 session
+	if clients := rtspTestClients(uuid); clients != 1 {
+		t.Fatalf("clients %d after join, want 1", clients)
+	}
+	if sessions := Sessions.List(SessionFilterST{StreamID: uuid, User: "viewer"}); len(sessions) != 2 {
+		t.Fatalf("viewer sessions %d after join, want 2", len(sessions))
+	}
+	if viewers := Sessions.DetachedCounts()[uuid+"/0"]; viewers != 2 {
+		t.Fatalf("detached viewers %d, want 2", viewers)
+	}
+	rtspMulticastLeave(uuid, "0", viewers[0])
+	if clients := rtspTestClients(uuid); clients != 1 {
+		t.Fatalf("clients %d after first leave, want 1", clients)
+	}
+	if sessions := Sessions.List(SessionFilterST{StreamID: uuid, User: "viewer"}); len(sessions) != 1 {
+		t.Fatalf("viewer sessions %d after first leave, want 1", len(sessions))
+	}
+	rtspMulticastLeave(uuid, "0", viewers[1])
+	for i := 0; i < 50 && rtspTestClients(uuid) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if clients := rtspTestClients(uuid); clients != 0 {
+		t.Fatalf("clients %d after last leave, want 0", clients)
+	}
+	if sessions := Sessions.List(SessionFilterST{StreamID: uuid}); len(sessions) != 0 {
+		t.Fatalf("sessions %+v after last leave, want none", sessions)
+	}
+	rtspMulticasts.mutex.Lock()
+	defer rtspMulticasts.mutex.Unlock()
+	if _, ok := rtspMulticasts.list[uuid+"/0"]; ok {
+		t.Error("multicast sender still registered")
+	}
+}
+
+func TestRTSPMulticastDisabled(t *testing.T) {
+	uuid := rtspTestStorage(t)
+	if err := rtspMulticastJoin(uuid, "0", &net.TCPConn{}, ""); err != ErrorRTSPMulticastDisabled {
+		t.Errorf("join without config = %v, want %v", err, ErrorRTSPMulticastDisabled)
+	}
+}
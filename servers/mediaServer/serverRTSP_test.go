@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rtspTestSDP h264 + aac channel sdp
+const rtspTestSDP = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=test\r\n" +
+	"t=0 0\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=control:trackID=0\r\n" +
+	"m=audio 0 RTP/AVP 97\r\n" +
+	"a=rtpmap:97 MPEG4-GENERIC/44100/2\r\n" +
+	"a=control:trackID=1\r\n"
+
+// rtspTestStorage replace Storage with one running channel "0", 테스트 끝나면 원래대로
+func rtspTestStorage(t *testing.T) string {
+	t.Helper()
+	uuid := "rtsp-test"
+	previous := Storage
+	t.Cleanup(func() {
+		Storage = previous
+	})
+	Storage = &StorageST{Streams: map[string]StreamST{uuid: {Name: "test", Channels: map[string]ChannelST{"0": {
+		runLock: true,
+		sdp:     []byte(rtspTestSDP),
+		clients: make(map[string]ClientST),
+		signals: make(chan int, 100),
+		gop:     streamGOP{videoIdx: -1},
+	}}}}}
+	return uuid
+}
+
+// rtspTestClients client count of channel "0"
+func rtspTestClients(uuid string) int {
+	Storage.mutex.RLock()
+	defer Storage.mutex.RUnlock()
+	return len(Storage.Streams[uuid].Channels["0"].clients)
+}
+
+// rtspTestRTP interleaved rtp packet with payload of n bytes
+func rtspTestRTP(channel byte, seq uint16, ts uint32, ssrc uint32, n int) []byte {
+	packet := make([]byte, 4+12+n)
+	packet[0], packet[1] = 0x24, channel
+	binary.BigEndian.PutUint16(packet[2:], uint16(12+n))
+	packet[4], packet[5] = 0x80, 96
+	binary.BigEndian.PutUint16(packet[6:], seq)
+	binary.BigEndian.PutUint32(packet[8:], ts)
+	binary.BigEndian.PutUint32(packet[12:], ssrc)
+	return packet
+}
+
+// rtspTestClient rtsp client connection of test server
+type rtspTestClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *textproto.Reader
+	cseq   int
+}
+
+// rtspTestServe serve one rtsp connection on loopback listener
+func rtspTestServe(t *testing.T) *rtspTestClient {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		RTSPServerClientHandle(conn)
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return &rtspTestClient{t: t, conn: conn, reader: textproto.NewReader(bufio.NewReader(conn))}
+}
+
+// request send request and read response status, headers and body
+func (client *rtspTestClient) request(method string, url string, headers map[string]string) (int, textproto.MIMEHeader, string) {
+	client.t.Helper()
+	client.cseq++
+	request := fmt.Sprintf("%s %s RTSP/1.0\r\nCSeq: %d\r\n", method, url, client.cseq)
+	for key, value := range headers {
+		request += key + ": " + value + "\r\n"
+	}
+	client.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.conn.Write([]byte(request + "\r\n")); err != nil {
+		client.t.Fatal(err)
+	}
+	line, err := client.reader.ReadLine()
+	if err != nil {
+		client.t.Fatal(err)
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		client.t.Fatalf("status line %q", line)
+	}
+	status, _ := strconv.Atoi(fields[1])
+	header, err := client.reader.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		client.t.Fatal(err)
+	}
+	body := make([]byte, stringToInt(header.Get("Content-Length")))
+	if _, err = io.ReadFull(client.reader.R, body); err != nil {
+		client.t.Fatal(err)
+	}
+	return status, header, string(body)
+}
+
+func TestRTSPServerPlayUDP(t *testing.T) {
+	uuid := rtspTestStorage(t)
+	client := rtspTestServe(t)
+	url := "rtsp://" + client.conn.RemoteAddr().String() + "/" + uuid + "/0"
+	if status, header, _ := client.request("OPTIONS", url, nil); status != 200 || !strings.Contains(header.Get("Public"), "PLAY") {
+		t.Fatalf("OPTIONS %d %v", status, header)
+	}
+	status, _, body := client.request("DESCRIBE", url, map[string]string{"Accept": "application/sdp"})
+	if status != 200 || body != rtspTestSDP {
+		t.Fatalf("DESCRIBE %d %q", status, body)
+	}
+	// track 마다 client port 를 열고 SETUP
+	var receivers []*net.UDPConn
+	var session string
+	for i := 0; i < 2; i++ {
+		receiver, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer receiver.Close()
+		receivers = append(receivers, receiver)
+		port := receiver.LocalAddr().(*net.UDPAddr).Port
+		headers := map[string]string{"Transport": fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d", port, port+1)}
+		if session != "" {
+			headers["Session"] = session
+		}
+		status, header, _ := client.request("SETUP", url+"/trackID="+strconv.Itoa(i), headers)
+		if status != 200 {
+			t.Fatalf("SETUP %d %v", status, header)
+		}
+		transport, ok := parseRTSPTransport(header.Get("Transport"))
+		if !ok || transport.mode != rtspTransportUDP || transport.clientRTP != port || !strings.Contains(header.Get("Transport"), "server_port=") {
+			t.Fatalf("SETUP transport %q", header.Get("Transport"))
+		}
+		session, _, _ = strings.Cut(header.Get("Session"), ";")
+	}
+	if status, header, _ := client.request("PLAY", url+"/", map[string]string{"Session": session}); status != 200 {
+		t.Fatalf("PLAY %d %v", status, header)
+	}
+	for i := 0; i < 50 && rtspTestClients(uuid) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if rtspTestClients(uuid) != 1 {
+		t.Fatal("client not registered after PLAY")
+	}
+	// session remote 는 port 없이 host
+	if sessions := Sessions.List(SessionFilterST{StreamID: uuid}); len(sessions) != 1 || sessions[0].Remote != "127.0.0.1" {
+		t.Errorf("sessions %+v, want one from 127.0.0.1", sessions)
+	}
+	// interleaved channel 0 은 video, 2 는 audio client_port 로
+	video := rtspTestRTP(0, 1, 9000, 1, 50)
+	audio := rtspTestRTP(2, 1, 4410, 2, 20)
+	Storage.StreamChannelCastProxy(uuid, "0", &video)
+	Storage.StreamChannelCastProxy(uuid, "0", &audio)
+	for i, want := range [][]byte{video, audio} {
+		buf := make([]byte, 1500)
+		receivers[i].SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := receivers[i].ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("track %d: %s", i, err)
+		}
+		if string(buf[:n]) != string(want[4:]) {
+			t.Errorf("track %d rtp % x, want % x", i, buf[:n], want[4:])
+		}
+	}
+	// TEARDOWN 하면 client 정리
+	client.request("TEARDOWN", url+"/", map[string]string{"Session": session})
+	for i := 0; i < 50 && rtspTestClients(uuid) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if rtspTestClients(uuid) != 0 {
+		t.Error("client not removed after TEARDOWN")
+	}
+}
+
+func TestRTSPServerSetupUnsupportedTransport(t *testing.T) {
+	uuid := rtspTestStorage(t)
+	client := rtspTestServe(t)
+	url := "rtsp://" + client.conn.RemoteAddr().String() + "/" + uuid + "/0"
+	client.request("DESCRIBE", url, nil)
+	// multicast 설정 없는 channel
+	if status, _, _ := client.request("SETUP", url+"/trackID=0", map[string]string{"Transport": "RTP/AVP;multicast"}); status != StatusUnsupportedTransport {
+		t.Errorf("SETUP multicast %d, want %d", status, StatusUnsupportedTransport)
+	}
+	if status, _, _ := client.request("SETUP", url+"/trackID=0", map[string]string{"Transport": "RTP/AVP;unicast"}); status != StatusUnsupportedTransport {
+		t.Errorf("SETUP without client_port %d, want %d", status, StatusUnsupportedTransport)
+	}
+}
+
+// rtspTestEndless header line without end
+type rtspTestEndless struct{}
+
+func (rtspTestEndless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	return len(p), nil
+}
+
+func TestReadRTSPRequestTooLarge(t *testing.T) {
+	reader := bufio.NewReaderSize(io.MultiReader(strings.NewReader("OPTIONS rtsp://127.0.0.1/test/0 RTSP/1.0\r\nX-Long: "), rtspTestEndless{}), 4096)
+	if _, err := readRTSPRequest(reader, nil); err != ErrorRTSPRequestTooLarge {
+		t.Errorf("endless header line = %v, want %v", err, ErrorRTSPRequestTooLarge)
+	}
+	reader = bufio.NewReaderSize(strings.NewReader("OPTIONS rtsp://127.0.0.1/test/0 RTSP/1.0\r\nCSeq: 1\r\n\r\n"), 4096)
+	if req, err := readRTSPRequest(reader, nil); err != nil || req.Method != OPTIONS || req.cSeq() != "1" {
+		t.Errorf("readRTSPRequest = %+v, %v", req, err)
+	}
+}
@@ -6,8 +6,8 @@ import (
 	"github.com/deepch/vdk/av"
 )
 
-//ClientAdd Add New Client to Translations
-func (obj *StorageST) ClientAdd(streamID string, channelID string, mode int) (string, chan *av.Packet, chan *[]byte, error) {
+//ClientAdd Add New Client to Translations, internal (recorder, transcoder) 은 limits 와 viewer 수 제외
+func (obj *StorageST) ClientAdd(streamID string, channelID string, mode int, remote string, user string, internal bool) (string, chan *av.Packet, chan *[]byte, error) {
 	obj.mutex.Lock()
 	defer obj.mutex.Unlock()
 	streamTmp, ok := obj.Streams[streamID]
@@ -19,17 +19,40 @@ func (obj *StorageST) ClientAdd(streamID string, channelID string, mode int) (st
 	if err != nil {
 		return "", nil, nil, err
 	}
-	chAV := make(chan *av.Packet, 2000)
-	chRTP := make(chan *[]byte, 2000)
 	channelTmp, ok := streamTmp.Channels[channelID]
 	if !ok {
 		return "", nil, nil, ErrorStreamNotFound
 	}
-
-	channelTmp.clients[cid] = ClientST{mode: mode, outgoingAVPacket: chAV, outgoingRTPPacket: chRTP, signals: make(chan int, 100)}
+	if !internal {
+		if err = obj.clientLimit(streamID, channelID); err != nil {
+			return "", nil, nil, err
+		}
+	}
+	// 마지막 키프레임부터 먼저 보내서 다음 키프레임까지 기다리지 않게
+	var replayRTP []*[]byte
+	var replayAV []*av.Packet
+	if mode == RTSP {
+		replayRTP = channelTmp.gop.rtp
+	} else {
+		replayAV = channelTmp.gop.Replay()
+	}
+	// queue_size 넘으면 backpressure 정책 적용, replay 는 queue_size 와 별도로 여유
+	policy := obj.Server.Backpressure.withDefaults()
+	chAV := make(chan *av.Packet, policy.QueueSize+len(replayAV))
+	chRTP := make(chan *[]byte, policy.QueueSize+len(replayRTP))
+	for _, packet := range replayRTP {
+		chRTP <- packet
+	}
+	for _, packet := range replayAV {
+		chAV <- packet
+	}
+	channelTmp.clients[cid] = ClientST{mode: mode, outgoingAVPacket: chAV, outgoingRTPPacket: chRTP, signals: make(chan int, 100), internal: internal, replay: len(replayRTP) + len(replayAV)}
 	channelTmp.ack = time.Now()
 	streamTmp.Channels[channelID] = channelTmp
 	obj.Streams[streamID] = streamTmp
+	Sessions.Add(&SessionST{ID: cid, StreamID: streamID, ChannelID: channelID, Protocol: sessionProtocol(mode), Remote: remote, User: user, kick: func() {
+		obj.ClientKick(streamID, channelID, cid)
+	}})
 	return cid, chAV, chRTP, nil
 
 }
@@ -41,6 +64,32 @@ func (obj *StorageST) ClientDelete(streamID string, cid string, channelID string
 	if _, ok := obj.Streams[streamID]; ok {
 		delete(obj.Streams[streamID].Channels[channelID].clients, cid)
 	}
+	Sessions.Delete(cid)
+}
+
+// ClientKick disconnect client, handler exit when queue closed
+func (obj *StorageST) ClientKick(streamID string, channelID string, cid string) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	if streamTmp, ok := obj.Streams[streamID]; ok {
+		if client, ok := streamTmp.Channels[channelID].clients[cid]; ok {
+			client.close()
+			delete(streamTmp.Channels[channelID].clients, cid)
+		}
+	}
+	Sessions.Delete(cid)
+}
+
+// ClientStats backpressure dropped packets and lag seconds of client
+func (obj *StorageST) ClientStats(streamID string, channelID string, cid string) (uint64, float64) {
+	obj.mutex.RLock()
+	defer obj.mutex.RUnlock()
+	if streamTmp, ok := obj.Streams[streamID]; ok {
+		if client, ok := streamTmp.Channels[channelID].clients[cid]; ok {
+			return client.dropped, client.lag.Seconds()
+		}
+	}
+	return 0, 0
 }
 
 //ClientHas check is client ext
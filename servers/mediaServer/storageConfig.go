@@ -119,6 +119,7 @@ func createDefaultConfig() StorageST {
 			HTTPDir:            "media_web",
 			HTTPPort:           ":8083",
 			RTSPPort:           ":5541",
+			RTMPPort:           ":1935",
 			HTTPS:              false,
 			HTTPSPort:          ":443",
 			HTTPSCert:          "server.crt",
@@ -135,6 +136,12 @@ func createDefaultConfig() StorageST {
 			WebRTCPortMin: 49152,
 			WebRTCPortMax: 49750,
 			FFMPEGPath:    "./external_tools/",
+			Reconnect: ReconnectST{
+				Min:        2,
+				Max:        60,
+				Multiplier: 2,
+				Jitter:     0.2,
+			},
 			Maintenance: MaintenanceConfig{
 				DiskCheckInterval:      1, // 1시간마다 디스크 체크
 				RetentionDays:          30,
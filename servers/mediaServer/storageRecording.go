@@ -59,8 +59,7 @@ func (obj *StorageST) StartRecording(streamID, channelID string) error {
 	}
 
 	// RTSP URL 생성
-	rtspURL := fmt.Sprintf("rtsp://localhost%s/%s/%s",
-		obj.Server.RTSPPort, streamID, channelID)
+	rtspURL := rtspInternalURL(obj.Server.RTSPPort, streamID, channelID)
 
 	// 암호화 키 생성 또는 기존 키 사용
 	keyinfoPath, err := obj.getOrCreateEncryptionKey(streamID, channelID, streamName)
@@ -75,7 +74,7 @@ func (obj *StorageST) StartRecording(streamID, channelID string) error {
 	시작시간: %s
 	RTSP URL: %s
 =====================================================================================================
-`, sessionID, now.Format("2006-01-02 15:04:05"), rtspURL)
+`, sessionID, now.Format("2006-01-02 15:04:05"), failoverRedactURL(rtspURL))
 	logFileHandle.WriteString(startMarker)
 
 	// HLS 파일 경로 설정
@@ -95,6 +94,7 @@ func (obj *StorageST) StartRecording(streamID, channelID string) error {
 	// pcm_mulaw 같은 비표준 코덱은 AAC로 변환하여 브라우저 호환성 확보
 	command := exec.Command(tempFFmpegPath,
 		"-rtsp_transport", "tcp",
+		"-user_agent", rtspInternalAgent,
 		"-fflags", "+genpts+discardcorrupt",
 		"-i", rtspURL,
 		"-map", "0:v:0", // 비디오 스트림 매핑
@@ -2,6 +2,7 @@ package main
 
 import (
 	"path/filepath"
+	"runtime"
 
 	"github.com/sirupsen/logrus"
 )
@@ -84,6 +85,27 @@ func (obj *StorageST) ServerRTSPPort() string {
 	return obj.Server.RTSPPort
 }
 
+// ServerRTSPSPort read RTSPS Port options
+func (obj *StorageST) ServerRTSPSPort() string {
+	obj.mutex.RLock()
+	defer obj.mutex.RUnlock()
+	return obj.Server.RTSPSPort
+}
+
+// ServerRetentionRoot read recording root
+func (obj *StorageST) ServerRetentionRoot() string {
+	obj.mutex.RLock()
+	defer obj.mutex.RUnlock()
+	return obj.Server.Maintenance.RetentionRoot
+}
+
+// ServerRTMPPort read RTMP publish Port options
+func (obj *StorageST) ServerRTMPPort() string {
+	obj.mutex.RLock()
+	defer obj.mutex.RUnlock()
+	return obj.Server.RTMPPort
+}
+
 // ServerHTTPS read HTTPS Port options
 func (obj *StorageST) ServerHTTPS() bool {
 	obj.mutex.RLock()
@@ -126,6 +148,13 @@ func (obj *StorageST) ServerHTTPSKey() string {
 	return obj.Server.HTTPSKey
 }
 
+// ServerTrustedProxies read trusted reverse proxies
+func (obj *StorageST) ServerTrustedProxies() []string {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	return obj.Server.TrustedProxies
+}
+
 // ServerICEServers read ICE servers
 func (obj *StorageST) ServerICEServers() []string {
 	obj.mutex.Lock()
@@ -168,6 +197,23 @@ func (obj *StorageST) ServerTokenBackend() string {
 	return obj.Server.Token.Backend
 }
 
+// StreamRTSPAuth rtsp auth of stream with server and stream users, false if not required
+func (obj *StorageST) StreamRTSPAuth(streamID string) (RTSPAuthST, bool) {
+	obj.mutex.RLock()
+	defer obj.mutex.RUnlock()
+	config := obj.Server.RTSPAuth
+	stream := obj.Streams[streamID]
+	config.Users = append(append([]RTSPUserST{}, config.Users...), stream.RTSPUsers...)
+	return config, config.Enable || len(stream.RTSPUsers) > 0
+}
+
+// ServerRTSPUDPPorts read rtsp udp server port range
+func (obj *StorageST) ServerRTSPUDPPorts() (uint16, uint16) {
+	obj.mutex.RLock()
+	defer obj.mutex.RUnlock()
+	return obj.Server.RTSPUDPPortMin, obj.Server.RTSPUDPPortMax
+}
+
 // ServerWebRTCPortMin read WebRTC Port Min
 func (obj *StorageST) ServerWebRTCPortMin() uint16 {
 	obj.mutex.Lock()
@@ -182,6 +228,50 @@ func (obj *StorageST) ServerWebRTCPortMax() uint16 {
 	return obj.Server.WebRTCPortMax
 }
 
+// ServerWebRTCTranscode read WebRTC h265 transcode option
+func (obj *StorageST) ServerWebRTCTranscode() bool {
+	obj.mutex.RLock()
+	defer obj.mutex.RUnlock()
+	return obj.Server.WebRTCTranscode
+}
+
+// ServerBackpressure read slow client policy with defaults
+func (obj *StorageST) ServerBackpressure() BackpressureST {
+	obj.mutex.RLock()
+	defer obj.mutex.RUnlock()
+	return obj.Server.Backpressure.withDefaults()
+}
+
+// ServerLimits read viewer and egress limits
+func (obj *StorageST) ServerLimits() LimitsST {
+	obj.mutex.RLock()
+	defer obj.mutex.RUnlock()
+	return obj.Server.Limits
+}
+
+// ServerReconnect read source reconnect backoff, zero value use default
+func (obj *StorageST) ServerReconnect() ReconnectST {
+	obj.mutex.RLock()
+	defer obj.mutex.RUnlock()
+	reconnect := obj.Server.Reconnect
+	if reconnect.Min <= 0 {
+		reconnect.Min = 2
+	}
+	if reconnect.Max < reconnect.Min {
+		reconnect.Max = 60
+		if reconnect.Max < reconnect.Min {
+			reconnect.Max = reconnect.Min
+		}
+	}
+	if reconnect.Multiplier < 1 {
+		reconnect.Multiplier = 2
+	}
+	if reconnect.Jitter < 0 || reconnect.Jitter > 1 {
+		reconnect.Jitter = 0.2
+	}
+	return reconnect
+}
+
 // Server Config Edit
 func (obj *StorageST) ServerEdit(val ServerST) error {
 	obj.mutex.Lock()
@@ -230,6 +320,64 @@ func (obj *StorageST) ServerEdit(val ServerST) error {
 		obj.Server.RTSPPort = val.RTSPPort
 	}
 
+	// RTMP
+	if len(val.RTMPPort) > 0 {
+		obj.Server.RTMPPort = val.RTMPPort
+	}
+	if len(val.RTSPSPort) > 0 {
+		obj.Server.RTSPSPort = val.RTSPSPort
+	}
+
+	// Reconnect
+	if val.Reconnect.Min > 0 {
+		obj.Server.Reconnect.Min = val.Reconnect.Min
+	}
+	if val.Reconnect.Max > 0 {
+		obj.Server.Reconnect.Max = val.Reconnect.Max
+	}
+	if val.Reconnect.Multiplier > 0 {
+		obj.Server.Reconnect.Multiplier = val.Reconnect.Multiplier
+	}
+	if val.Reconnect.Jitter > 0 {
+		obj.Server.Reconnect.Jitter = val.Reconnect.Jitter
+	}
+
+	// Backpressure
+	if len(val.Backpressure.Policy) > 0 {
+		obj.Server.Backpressure.Policy = val.Backpressure.Policy
+	}
+	if val.Backpressure.QueueSize > 0 {
+		obj.Server.Backpressure.QueueSize = val.Backpressure.QueueSize
+	}
+	if val.Backpressure.MaxLag > 0 {
+		obj.Server.Backpressure.MaxLag = val.Backpressure.MaxLag
+	}
+
+	// Limits
+	if val.Limits.MaxViewers > 0 {
+		obj.Server.Limits.MaxViewers = val.Limits.MaxViewers
+	}
+	if val.Limits.MaxViewersStream > 0 {
+		obj.Server.Limits.MaxViewersStream = val.Limits.MaxViewersStream
+	}
+	if val.Limits.MaxViewersChannel > 0 {
+		obj.Server.Limits.MaxViewersChannel = val.Limits.MaxViewersChannel
+	}
+	if val.Limits.MaxEgressKbps > 0 {
+		obj.Server.Limits.MaxEgressKbps = val.Limits.MaxEgressKbps
+	}
+
+	// RTSP
+	if val.RTSPAuth.Enable || len(val.RTSPAuth.Users) > 0 {
+		obj.Server.RTSPAuth = val.RTSPAuth
+	}
+	if val.RTSPUDPPortMin != 0 {
+		obj.Server.RTSPUDPPortMin = val.RTSPUDPPortMin
+	}
+	if val.RTSPUDPPortMax != 0 {
+		obj.Server.RTSPUDPPortMax = val.RTSPUDPPortMax
+	}
+
 	// WebRTC
 	if val.WebRTCPortMin != 0 {
 		obj.Server.WebRTCPortMin = val.WebRTCPortMin
@@ -237,6 +385,9 @@ func (obj *StorageST) ServerEdit(val ServerST) error {
 	if val.WebRTCPortMax != 0 {
 		obj.Server.WebRTCPortMax = val.WebRTCPortMax
 	}
+	if val.WebRTCTranscode {
+		obj.Server.WebRTCTranscode = val.WebRTCTranscode
+	}
 
 	err := obj.SaveConfig()
 	if err != nil {
@@ -244,3 +395,13 @@ func (obj *StorageST) ServerEdit(val ServerST) error {
 	}
 	return nil
 }
+
+// ServerFFMPEGBinary read ffmpeg tool path (ffmpeg, ffprobe)
+func (obj *StorageST) ServerFFMPEGBinary(name string) string {
+	obj.mutex.RLock()
+	defer obj.mutex.RUnlock()
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return filepath.Join(obj.Server.FFMPEGPath, name)
+}
@@ -6,9 +6,13 @@ import "github.com/liip/sheriff"
 func (obj *StorageST) MarshalledStreamsList() (interface{}, error) {
 	obj.mutex.RLock()
 	defer obj.mutex.RUnlock()
+	streams := make(map[string]StreamST, len(obj.Streams))
+	for uuid, stream := range obj.Streams {
+		streams[uuid] = stream.api()
+	}
 	val, err := sheriff.Marshal(&sheriff.Options{
 		Groups: []string{"api"},
-	}, obj.Streams)
+	}, streams)
 	if err != nil {
 		return nil, err
 	}
@@ -217,3 +221,20 @@ func (obj *StorageST) StreamInfo(uuid string) (*StreamST, error) {
 	}
 	return nil, ErrorStreamNotFound
 }
+
+// api copy of stream for api response, channel stream_key 는 설정 여부만
+func (stream StreamST) api() StreamST {
+	channels := make(map[string]ChannelST, len(stream.Channels))
+	for id, channel := range stream.Channels {
+		channels[id] = channel.api()
+	}
+	stream.Channels = channels
+	return stream
+}
+
+// api copy of channel for api response, stream_key 는 설정 여부만
+func (channel ChannelST) api() ChannelST {
+	channel.StreamKeySet = channel.StreamKey != ""
+	channel.StreamKey = ""
+	return channel
+}
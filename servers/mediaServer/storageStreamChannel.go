@@ -33,8 +33,8 @@ func (obj *StorageST) StreamChannelRunAll() {
 	defer obj.mutex.Unlock()
 	for k, v := range obj.Streams {
 		for ks, vs := range v.Channels {
-			// 녹화 상태가 ON이거나 OnDemand가 아닌 경우 스트림 시작
-			if !vs.OnDemand || vs.OnRecording {
+			// 녹화 상태가 ON이거나 OnDemand가 아니거나 push 채널인 경우 스트림 시작
+			if !vs.OnDemand || vs.OnRecording || vs.Push {
 				vs.runLock = true
 				go StreamServerRunStreamDo(k, ks, v.Name)
 				v.Channels[ks] = vs
@@ -113,6 +113,35 @@ func (obj *StorageST) StreamChannelReload(uuid string, channelID string) error {
 	return ErrorStreamNotFound
 }
 
+// StreamChannelKeyFrameRequest ask source for keyframe, channel 당 streamKeyFrameInterval 에 한번
+func (obj *StorageST) StreamChannelKeyFrameRequest(streamID string, channelID string) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	if tmp, ok := obj.Streams[streamID]; ok {
+		if channelTmp, ok := tmp.Channels[channelID]; ok && time.Since(channelTmp.keyFrameRequest) >= streamKeyFrameInterval {
+			select {
+			case channelTmp.signals <- SignalStreamKeyFrame:
+				channelTmp.keyFrameRequest = time.Now()
+				tmp.Channels[channelID] = channelTmp
+				obj.Streams[streamID] = tmp
+			default:
+			}
+		}
+	}
+}
+
+// StreamChannelGOP cached packets since last keyframe, replay 시간 (gopReplayStep 간격)
+func (obj *StorageST) StreamChannelGOP(streamID string, channelID string) []*av.Packet {
+	obj.mutex.RLock()
+	defer obj.mutex.RUnlock()
+	if tmp, ok := obj.Streams[streamID]; ok {
+		if channelTmp, ok := tmp.Channels[channelID]; ok {
+			return channelTmp.gop.Replay()
+		}
+	}
+	return nil
+}
+
 // StreamInfo return stream info
 func (obj *StorageST) StreamChannelInfo(uuid string, channelID string) (*ChannelST, error) {
 	obj.mutex.RLock()
@@ -151,6 +180,17 @@ func (obj *StorageST) StreamChannelCodecs(streamID string, channelID string) ([]
 	return nil, ErrorStreamChannelCodecNotFound
 }
 
+// StreamChannelMulticast multicast config of channel, nil if disabled
+func (obj *StorageST) StreamChannelMulticast(streamID string, channelID string) *ChannelMulticastST {
+	obj.mutex.RLock()
+	defer obj.mutex.RUnlock()
+	if channelTmp, ok := obj.Streams[streamID].Channels[channelID]; ok && channelTmp.Multicast != nil && channelTmp.Multicast.Group != "" {
+		config := *channelTmp.Multicast
+		return &config
+	}
+	return nil
+}
+
 // StreamChannelStatus change stream status
 func (obj *StorageST) StreamChannelStatus(key string, channelID string, val int) {
 	obj.mutex.Lock()
@@ -164,27 +204,95 @@ func (obj *StorageST) StreamChannelStatus(key string, channelID string, val int)
 	}
 }
 
+// StreamChannelActiveURL set current source url of channel
+func (obj *StorageST) StreamChannelActiveURL(key string, channelID string, url string, index int) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	if tmp, ok := obj.Streams[key]; ok {
+		if channelTmp, ok := tmp.Channels[channelID]; ok {
+			channelTmp.ActiveURL = url
+			channelTmp.ActiveURLIndex = index
+			tmp.Channels[channelID] = channelTmp
+			obj.Streams[key] = tmp
+		}
+	}
+}
+
+// StreamChannelConnected source connected, reset backoff
+func (obj *StorageST) StreamChannelConnected(key string, channelID string) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	if tmp, ok := obj.Streams[key]; ok {
+		if channelTmp, ok := tmp.Channels[channelID]; ok {
+			channelTmp.Reconnect.LastConnected = time.Now()
+			channelTmp.Reconnect.Backoff = 0
+			tmp.Channels[channelID] = channelTmp
+			obj.Streams[key] = tmp
+		}
+	}
+}
+
+// StreamChannelDisconnected source failed or dropped, count reconnect attempt
+func (obj *StorageST) StreamChannelDisconnected(key string, channelID string, err error, backoff time.Duration) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	if tmp, ok := obj.Streams[key]; ok {
+		if channelTmp, ok := tmp.Channels[channelID]; ok {
+			channelTmp.Reconnect.Attempts++
+			channelTmp.Reconnect.LastDisconnected = time.Now()
+			channelTmp.Reconnect.Backoff = backoff.Seconds()
+			if err != nil {
+				channelTmp.Reconnect.LastError = err.Error()
+			}
+			tmp.Channels[channelID] = channelTmp
+			obj.Streams[key] = tmp
+		}
+	}
+}
+
+// StreamChannelIngest update ingest statistics
+func (obj *StorageST) StreamChannelIngest(key string, channelID string, val ChannelIngestST) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	if tmp, ok := obj.Streams[key]; ok {
+		if channelTmp, ok := tmp.Channels[channelID]; ok {
+			channelTmp.ingest = val
+			tmp.Channels[channelID] = channelTmp
+			obj.Streams[key] = tmp
+		}
+	}
+}
+
 // StreamChannelCast broadcast stream
 func (obj *StorageST) StreamChannelCast(key string, channelID string, val *av.Packet) {
 	obj.mutex.Lock()
 	defer obj.mutex.Unlock()
 	if tmp, ok := obj.Streams[key]; ok {
 		if channelTmp, ok := tmp.Channels[channelID]; ok {
+			channelTmp.gop.Packet(val)
 			if len(channelTmp.clients) > 0 {
-				for _, i2 := range channelTmp.clients {
+				policy := obj.Server.Backpressure.withDefaults()
+				now := time.Now()
+				// video 없는 채널은 아무 packet 에서나 다시 시작
+				keyFrame := val.IsKeyFrame || channelTmp.gop.videoIdx < 0
+				for cid, i2 := range channelTmp.clients {
 					if i2.mode == RTSP {
 						continue
 					}
-					if len(i2.outgoingAVPacket) < 1000 {
+					send, disconnect := i2.backpressure(len(i2.outgoingAVPacket), cap(i2.outgoingAVPacket), keyFrame, false, policy, now)
+					if disconnect {
+						i2.disconnect(channelTmp.clients, "StreamChannelCast", key, channelID, cid)
+						continue
+					}
+					if send {
 						i2.outgoingAVPacket <- val
-					} else if len(i2.signals) < 10 {
-						i2.signals <- SignalStreamStop
 					}
+					channelTmp.clients[cid] = i2
 				}
-				channelTmp.ack = time.Now()
-				tmp.Channels[channelID] = channelTmp
-				obj.Streams[key] = tmp
+				channelTmp.ack = now
 			}
+			tmp.Channels[channelID] = channelTmp
+			obj.Streams[key] = tmp
 		}
 	}
 }
@@ -195,21 +303,29 @@ func (obj *StorageST) StreamChannelCastProxy(key string, channelID string, val *
 	defer obj.mutex.Unlock()
 	if tmp, ok := obj.Streams[key]; ok {
 		if channelTmp, ok := tmp.Channels[channelID]; ok {
+			keyFrame, keyPart := channelTmp.gop.RTP(val)
 			if len(channelTmp.clients) > 0 {
-				for _, i2 := range channelTmp.clients {
+				policy := obj.Server.Backpressure.withDefaults()
+				now := time.Now()
+				keyFrame = keyFrame || channelTmp.gop.videoIdx < 0
+				for cid, i2 := range channelTmp.clients {
 					if i2.mode != RTSP {
 						continue
 					}
-					if len(i2.outgoingRTPPacket) < 1000 {
+					send, disconnect := i2.backpressure(len(i2.outgoingRTPPacket), cap(i2.outgoingRTPPacket), keyFrame, keyPart, policy, now)
+					if disconnect {
+						i2.disconnect(channelTmp.clients, "StreamChannelCastProxy", key, channelID, cid)
+						continue
+					}
+					if send {
 						i2.outgoingRTPPacket <- val
-					} else if len(i2.signals) < 10 {
-						i2.signals <- SignalStreamStop
 					}
+					channelTmp.clients[cid] = i2
 				}
-				channelTmp.ack = time.Now()
-				tmp.Channels[channelID] = channelTmp
-				obj.Streams[key] = tmp
+				channelTmp.ack = now
 			}
+			tmp.Channels[channelID] = channelTmp
+			obj.Streams[key] = tmp
 		}
 	}
 }
@@ -222,6 +338,20 @@ func (obj *StorageST) StreamChannelCodecsUpdate(streamID string, channelID strin
 		if channelTmp, ok := tmp.Channels[channelID]; ok {
 			channelTmp.codecs = val
 			channelTmp.sdp = sdp
+			channelTmp.gop = newStreamGOP(val)
+			tmp.Channels[channelID] = channelTmp
+			obj.Streams[streamID] = tmp
+		}
+	}
+}
+
+// StreamChannelGOPFlush drop gop cache, source closed
+func (obj *StorageST) StreamChannelGOPFlush(streamID string, channelID string) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	if tmp, ok := obj.Streams[streamID]; ok {
+		if channelTmp, ok := tmp.Channels[channelID]; ok {
+			channelTmp.gop = streamGOP{videoIdx: -1}
 			tmp.Channels[channelID] = channelTmp
 			obj.Streams[streamID] = tmp
 		}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// streamBackoff exponential reconnect interval with jitter
+type streamBackoff struct {
+	config ReconnectST
+	next   time.Duration
+}
+
+// newStreamBackoff start from min interval
+func newStreamBackoff(config ReconnectST) *streamBackoff {
+	return &streamBackoff{config: config}
+}
+
+// Reset connection healthy, next wait from min interval
+func (backoff *streamBackoff) Reset() {
+	backoff.next = 0
+}
+
+// Next wait before reconnect, grow multiplier until max, +- jitter
+func (backoff *streamBackoff) Next() time.Duration {
+	minInterval := time.Duration(backoff.config.Min * float64(time.Second))
+	maxInterval := time.Duration(backoff.config.Max * float64(time.Second))
+	if backoff.next < minInterval {
+		backoff.next = minInterval
+	} else {
+		backoff.next = time.Duration(float64(backoff.next) * backoff.config.Multiplier)
+	}
+	if backoff.next > maxInterval {
+		backoff.next = maxInterval
+	}
+	wait := backoff.next
+	if backoff.config.Jitter > 0 {
+		wait += time.Duration((rand.Float64()*2 - 1) * backoff.config.Jitter * float64(wait))
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
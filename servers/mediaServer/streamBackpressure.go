@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Default backpressure policy
+const (
+	BackpressureDrop       = "drop"       // queue 가 차면 다음 키프레임까지 버림
+	BackpressureDisconnect = "disconnect" // drop + max_lag 초 이상 밀리면 끊음
+	BackpressurePriority   = "priority"   // 밀리는 동안 키프레임만 전송
+)
+
+// withDefaults policy with default values, storage lock 안에서 호출
+func (val BackpressureST) withDefaults() BackpressureST {
+	switch val.Policy {
+	case BackpressureDrop, BackpressureDisconnect, BackpressurePriority:
+	default:
+		val.Policy = BackpressureDrop
+	}
+	if val.QueueSize <= 0 {
+		val.QueueSize = 1000
+	}
+	if val.MaxLag <= 0 {
+		val.MaxLag = 10
+	}
+	return val
+}
+
+// backpressure decide packet for client queue, queued 현재 queue 길이, capacity queue 크기
+// keyFrame 이면 밀린 client 가 다시 받기 시작할 수 있다. keyPart 는 같은 키프레임의 나머지 rtp packet,
+// 시작 packet 을 보낸 client 에는 끝까지 보낸다. return send, disconnect
+func (client *ClientST) backpressure(queued int, capacity int, keyFrame bool, keyPart bool, policy BackpressureST, now time.Time) (bool, bool) {
+	// replay packet 은 먼저 나가므로 queue 에 남은 만큼 빼고 live packet 만 센다
+	if queued < client.replay {
+		client.replay = queued
+	}
+	live := queued - client.replay
+	if live >= policy.QueueSize {
+		if client.behindSince.IsZero() {
+			client.behindSince = now
+			client.lagged++
+		}
+		client.waitKey = true
+	} else if live <= policy.QueueSize/2 {
+		client.behindSince = time.Time{}
+	}
+	if !client.behindSince.IsZero() {
+		client.lag = now.Sub(client.behindSince)
+		if client.lag > client.maxLag {
+			client.maxLag = client.lag
+		}
+		if policy.Policy == BackpressureDisconnect && client.lag.Seconds() >= policy.MaxLag {
+			return false, true
+		}
+	} else {
+		client.lag = 0
+	}
+	send := !client.waitKey
+	if client.waitKey {
+		switch {
+		case keyFrame && client.behindSince.IsZero():
+			// 따라잡음, 키프레임부터 다시 전송
+			client.waitKey = false
+			send = true
+		case keyFrame && policy.Policy == BackpressurePriority:
+			send = true
+		case keyPart && client.keyPart:
+			// 보내기 시작한 키프레임은 마지막 fragment 까지
+			send = true
+		}
+	}
+	if send && queued >= capacity {
+		send = false
+	}
+	if keyFrame || keyPart {
+		// 사이에 오는 audio 는 상태 유지
+		client.keyPart = send
+	}
+	if !send {
+		client.dropped++
+	}
+	return send, false
+}
+
+// close close client queues, handler 는 queue 가 닫히면 종료. storage lock 안에서 호출
+func (client *ClientST) close() {
+	close(client.outgoingAVPacket)
+	close(client.outgoingRTPPacket)
+}
+
+// disconnect close slow client and remove from channel clients, function 은 로그 태그. storage lock 안에서 호출
+func (client *ClientST) disconnect(clients map[string]ClientST, function string, streamID string, channelID string, cid string) {
+	log.Printf("[WARN] [core] [%s] Slow client disconnect: stream=%s channel=%s client=%s lag=%s dropped=%d", function, streamID, channelID, cid, client.lag, client.dropped)
+	client.close()
+	delete(clients, cid)
+	Sessions.Delete(cid)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackpressurePriorityRTPKeyFrame(t *testing.T) {
+	gop := streamGOP{videoIdx: 0}
+	policy := BackpressureST{Policy: BackpressurePriority}.withDefaults()
+	client := ClientST{}
+	now := time.Now()
+	// queue 가 가득 차서 밀리는 중
+	client.backpressure(policy.QueueSize, policy.QueueSize*2, false, false, policy, now)
+	// FU-A idr 시작, 중간, 끝 fragment 와 같은 timestamp 의 audio, 다음 p frame
+	fuA := func(header byte, ts uint32) []byte {
+		packet := rtspTestRTP(0, 0, ts, 1, 10)
+		packet[16], packet[17] = 0x7c, header
+		return packet
+	}
+	audio := rtspTestRTP(2, 0, 9000, 2, 10)
+	pFrame := rtspTestRTP(0, 0, 12600, 1, 10)
+	pFrame[16] = 0x41
+	tests := []struct {
+		name   string
+		packet []byte
+		send   bool
+	}{
+		{"idr start", fuA(0x85, 9000), true},
+		{"idr middle", fuA(0x05, 9000), true},
+		{"audio", audio, false},
+		{"idr end", fuA(0x45, 9000), true},
+		{"p frame", pFrame, false},
+		{"next frame fragment", fuA(0x01, 12600), false},
+	}
+	for _, test := range tests {
+		keyFrame, keyPart := gop.RTP(&test.packet)
+		send, disconnect := client.backpressure(policy.QueueSize, policy.QueueSize*2, keyFrame, keyPart, policy, now)
+		if send != test.send || disconnect {
+			t.Errorf("%s: send %v disconnect %v, want send %v", test.name, send, disconnect, test.send)
+		}
+	}
+}
+
+func TestBackpressureReplayNotLag(t *testing.T) {
+	policy := BackpressureST{QueueSize: 10}.withDefaults()
+	// gop replay 30 packet 이 queue 에 있는 새 client
+	client := ClientST{replay: 30}
+	now := time.Now()
+	if send, _ := client.backpressure(30, 40, false, false, policy, now); !send || client.waitKey {
+		t.Fatalf("live packet after replay: send %v waitKey %v", send, client.waitKey)
+	}
+	// replay 를 다 보낸 뒤에는 live packet 만 센다
+	if send, _ := client.backpressure(5, 40, false, false, policy, now); !send || client.replay != 5 {
+		t.Fatalf("after drain: send %v replay %d", send, client.replay)
+	}
+	if send, _ := client.backpressure(15, 40, false, false, policy, now); send || !client.waitKey {
+		t.Errorf("live over queue_size: send %v waitKey %v", send, client.waitKey)
+	}
+}
+
+func TestGOPRTPCacheBytes(t *testing.T) {
+	gop := streamGOP{videoIdx: 0}
+	key := rtspTestRTP(0, 0, 9000, 1, 10)
+	key[16] = 0x65
+	gop.RTP(&key)
+	// audio 포함 packet 수가 많아도 byte 한도 안이면 유지
+	for i := 0; i < 2000; i++ {
+		packet := rtspTestRTP(byte(i%2*2), uint16(i), 9000, 1, 100)
+		packet[16] = 0x41
+		gop.RTP(&packet)
+	}
+	if len(gop.rtp) != 2001 {
+		t.Fatalf("rtp cache %d packets, want 2001", len(gop.rtp))
+	}
+	// 한도를 넘는 GOP 는 캐시 안함
+	big := rtspTestRTP(0, 0, 9000, 1, gopRTPCacheBytes)
+	big[16] = 0x41
+	gop.RTP(&big)
+	if len(gop.rtp) != 0 || gop.rtpKey {
+		t.Errorf("rtp cache %d packets after limit", len(gop.rtp))
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/aacparser"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/codec/h265parser"
+	vbits "github.com/deepch/vdk/utils/bits"
+)
+
+// CodecInfoST codec description for player protocol select
+type CodecInfoST struct {
+	Index      int    `json:"index"`
+	Type       string `json:"type"`              // H264, H265, AAC ...
+	Codec      string `json:"codec,omitempty"`   // RFC 6381 (avc1.64001F, hvc1.1.6.L120.90, mp4a.40.2)
+	Profile    string `json:"profile,omitempty"` // High, Main, Main 10 ...
+	Level      string `json:"level,omitempty"`   // 3.1, 4 ...
+	Tier       string `json:"tier,omitempty"`    // h265 Main, High
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	SampleRate int    `json:"sample_rate,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+	MSE        bool   `json:"mse"`    // fmp4 (mse, ll-hls) 가능
+	WebRTC     bool   `json:"webrtc"` // webrtc 그대로 전송 가능
+}
+
+// hevcSPSInfo profile_tier_level and format of h265 sps
+type hevcSPSInfo struct {
+	profileTierLevel   [12]byte
+	maxSubLayers       byte
+	temporalIDNesting  byte
+	chromaFormat       byte
+	bitDepthLuma       byte
+	bitDepthChroma     byte
+	profileSpace, tier byte
+	profileIDC         byte
+	compatibility      uint32
+	levelIDC           byte
+}
+
+// hevcRBSP remove emulation prevention bytes
+func hevcRBSP(nalu []byte) []byte {
+	rbsp := make([]byte, 0, len(nalu))
+	var zeros int
+	for _, b := range nalu {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+	return rbsp
+}
+
+// hevcParseSPS read fields needed by hvcC from sps nalu (with nal header)
+func hevcParseSPS(sps []byte) (info hevcSPSInfo, err error) {
+	rbsp := hevcRBSP(sps)
+	if len(rbsp) < 15 {
+		return info, h265parser.ErrDecconfInvalid
+	}
+	info.maxSubLayers = (rbsp[2]>>1)&0x07 + 1
+	info.temporalIDNesting = rbsp[2] & 0x01
+	copy(info.profileTierLevel[:], rbsp[3:15])
+	info.profileSpace = info.profileTierLevel[0] >> 6
+	info.tier = (info.profileTierLevel[0] >> 5) & 0x01
+	info.profileIDC = info.profileTierLevel[0] & 0x1f
+	info.compatibility = binary.BigEndian.Uint32(info.profileTierLevel[1:5])
+	info.levelIDC = info.profileTierLevel[11]
+	reader := &vbits.GolombBitReader{R: bytes.NewReader(rbsp[15:])}
+	subLayers := int(info.maxSubLayers) - 1
+	profilePresent := make([]uint, subLayers)
+	levelPresent := make([]uint, subLayers)
+	for i := 0; i < subLayers; i++ {
+		if profilePresent[i], err = reader.ReadBit(); err != nil {
+			return
+		}
+		if levelPresent[i], err = reader.ReadBit(); err != nil {
+			return
+		}
+	}
+	if subLayers > 0 {
+		for i := subLayers; i < 8; i++ {
+			if _, err = reader.ReadBits(2); err != nil {
+				return
+			}
+		}
+	}
+	for i := 0; i < subLayers; i++ {
+		if profilePresent[i] == 1 {
+			// sub_layer profile 88 bits
+			if _, err = reader.ReadBits64(64); err != nil {
+				return
+			}
+			if _, err = reader.ReadBits(24); err != nil {
+				return
+			}
+		}
+		if levelPresent[i] == 1 {
+			if _, err = reader.ReadBits(8); err != nil {
+				return
+			}
+		}
+	}
+	// sps_seq_parameter_set_id
+	if _, err = reader.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	chromaFormat, err := reader.ReadExponentialGolombCode()
+	if err != nil {
+		return
+	}
+	info.chromaFormat = byte(chromaFormat)
+	if chromaFormat == 3 {
+		if _, err = reader.ReadBit(); err != nil {
+			return
+		}
+	}
+	// pic_width, pic_height
+	for i := 0; i < 2; i++ {
+		if _, err = reader.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+	conformanceWindow, err := reader.ReadBit()
+	if err != nil {
+		return
+	}
+	if conformanceWindow == 1 {
+		for i := 0; i < 4; i++ {
+			if _, err = reader.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+		}
+	}
+	bitDepthLuma, err := reader.ReadExponentialGolombCode()
+	if err != nil {
+		return
+	}
+	bitDepthChroma, err := reader.ReadExponentialGolombCode()
+	if err != nil {
+		return
+	}
+	info.bitDepthLuma = byte(bitDepthLuma) + 8
+	info.bitDepthChroma = byte(bitDepthChroma) + 8
+	return info, nil
+}
+
+// hevcDecoderConfig build ISO/IEC 14496-15 hvcC
+// vdk 의 record 는 profile/level 자리가 비어있어 safari, chrome 이 hvc1 을 거부한다
+func hevcDecoderConfig(vps, sps, pps []byte, info hevcSPSInfo) []byte {
+	record := make([]byte, 23, 23+3*5+len(vps)+len(sps)+len(pps))
+	record[0] = 1
+	copy(record[1:13], info.profileTierLevel[:])
+	record[13] = 0xf0 // min_spatial_segmentation_idc 0
+	record[15] = 0xfc // parallelismType 0
+	record[16] = 0xfc | info.chromaFormat&0x03
+	record[17] = 0xf8 | (info.bitDepthLuma-8)&0x07
+	record[18] = 0xf8 | (info.bitDepthChroma-8)&0x07
+	record[21] = (info.maxSubLayers&0x07)<<3 | (info.temporalIDNesting&0x01)<<2 | 0x03
+	record[22] = 3
+	for _, nalu := range []struct {
+		naluType byte
+		data     []byte
+	}{{32, vps}, {33, sps}, {34, pps}} {
+		record = append(record, 0x80|nalu.naluType, 0, 1, byte(len(nalu.data)>>8), byte(len(nalu.data)))
+		record = append(record, nalu.data...)
+	}
+	return record
+}
+
+// CodecsNormalize fix codec data before muxers use it (h265 hvcC)
+func CodecsNormalize(codecs []av.CodecData) []av.CodecData {
+	normalized := make([]av.CodecData, len(codecs))
+	copy(normalized, codecs)
+	for i, codec := range normalized {
+		codecH265, ok := codec.(h265parser.CodecData)
+		if !ok || len(codecH265.RecordInfo.VPS) == 0 || len(codecH265.RecordInfo.SPS) == 0 || len(codecH265.RecordInfo.PPS) == 0 {
+			continue
+		}
+		info, err := hevcParseSPS(codecH265.SPS())
+		if err != nil {
+			continue
+		}
+		codecH265.Record = hevcDecoderConfig(codecH265.VPS(), codecH265.SPS(), codecH265.PPS(), info)
+		normalized[i] = codecH265
+	}
+	return normalized
+}
+
+// CodecString RFC 6381 codec string for mse / hls, empty if not fmp4 codec
+func CodecString(codec av.CodecData) string {
+	switch codec := codec.(type) {
+	case h264parser.CodecData:
+		return fmt.Sprintf("avc1.%02X%02X%02X", codec.RecordInfo.AVCProfileIndication, codec.RecordInfo.ProfileCompatibility, codec.RecordInfo.AVCLevelIndication)
+	case h265parser.CodecData:
+		info, err := hevcParseSPS(codec.SPS())
+		if err != nil {
+			return "hvc1.1.6.L120.90"
+		}
+		var codecString strings.Builder
+		codecString.WriteString("hvc1.")
+		if info.profileSpace > 0 {
+			codecString.WriteByte('A' + info.profileSpace - 1)
+		}
+		codecString.WriteString(strconv.Itoa(int(info.profileIDC)))
+		codecString.WriteString("." + strings.ToUpper(strconv.FormatUint(uint64(bits.Reverse32(info.compatibility)), 16)))
+		if info.tier == 1 {
+			codecString.WriteString(".H")
+		} else {
+			codecString.WriteString(".L")
+		}
+		codecString.WriteString(strconv.Itoa(int(info.levelIDC)))
+		constraints := info.profileTierLevel[5:11]
+		last := len(constraints)
+		for last > 0 && constraints[last-1] == 0 {
+			last--
+		}
+		for _, b := range constraints[:last] {
+			codecString.WriteString("." + strings.ToUpper(strconv.FormatUint(uint64(b), 16)))
+		}
+		return codecString.String()
+	case aacparser.CodecData:
+		return "mp4a.40." + strconv.Itoa(int(codec.Config.ObjectType))
+	}
+	return ""
+}
+
+// CodecMeta mse codecs parameter of codec list
+func CodecMeta(codecs []av.CodecData) string {
+	var meta []string
+	for _, codec := range codecs {
+		if codecString := CodecString(codec); codecString != "" {
+			meta = append(meta, codecString)
+		}
+	}
+	return strings.Join(meta, ",")
+}
+
+// CodecHasH265 true if video track is h265
+func CodecHasH265(codecs []av.CodecData) bool {
+	for _, codec := range codecs {
+		if codec.Type() == av.H265 {
+			return true
+		}
+	}
+	return false
+}
+
+// codecH264Profile profile_idc name
+func codecH264Profile(profile uint8) string {
+	switch profile {
+	case 66:
+		return "Baseline"
+	case 77:
+		return "Main"
+	case 88:
+		return "Extended"
+	case 100:
+		return "High"
+	case 110:
+		return "High 10"
+	case 122:
+		return "High 4:2:2"
+	case 244:
+		return "High 4:4:4"
+	}
+	return strconv.Itoa(int(profile))
+}
+
+// codecH265Profile general_profile_idc name
+func codecH265Profile(profile byte) string {
+	switch profile {
+	case 1:
+		return "Main"
+	case 2:
+		return "Main 10"
+	case 3:
+		return "Main Still Picture"
+	case 4:
+		return "Range Extensions"
+	}
+	return strconv.Itoa(int(profile))
+}
+
+// CodecInfo describe codec list
+func CodecInfo(codecs []av.CodecData) []CodecInfoST {
+	infos := make([]CodecInfoST, 0, len(codecs))
+	for i, codec := range codecs {
+		info := CodecInfoST{
+			Index: i,
+			Type:  codec.Type().String(),
+			Codec: CodecString(codec),
+		}
+		info.MSE = info.Codec != ""
+		switch codecTyped := codec.(type) {
+		case h264parser.CodecData:
+			info.Profile = codecH264Profile(codecTyped.RecordInfo.AVCProfileIndication)
+			info.Level = strconv.FormatFloat(float64(codecTyped.RecordInfo.AVCLevelIndication)/10, 'f', -1, 64)
+			info.WebRTC = true
+		case h265parser.CodecData:
+			if sps, err := hevcParseSPS(codecTyped.SPS()); err == nil {
+				info.Profile = codecH265Profile(sps.profileIDC)
+				info.Level = strconv.FormatFloat(float64(sps.levelIDC)/30, 'f', -1, 64)
+				info.Tier = "Main"
+				if sps.tier == 1 {
+					info.Tier = "High"
+				}
+			}
+		}
+		if video, ok := codec.(av.VideoCodecData); ok {
+			info.Width, info.Height = video.Width(), video.Height()
+		}
+		if audio, ok := codec.(av.AudioCodecData); ok {
+			info.SampleRate = audio.SampleRate()
+			info.Channels = audio.ChannelLayout().Count()
+			switch codec.Type() {
+			case av.PCM_ALAW, av.PCM_MULAW, av.OPUS:
+				info.WebRTC = true
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
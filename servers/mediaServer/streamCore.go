@@ -2,14 +2,9 @@ package main
 
 import (
 	"math"
-	"net/url"
-	"strings"
 	"time"
 
-	"github.com/deepch/vdk/format/rtmp"
-
 	"github.com/deepch/vdk/av"
-	"github.com/deepch/vdk/format/rtspv2"
 	"log"
 )
 
@@ -22,6 +17,8 @@ func StreamServerRunStreamDo(streamID string, channelID string, streamName strin
 			Storage.StreamChannelUnlock(streamID, channelID)
 		}
 	}()
+	var failover *streamFailover
+	backoff := newStreamBackoff(Storage.ServerReconnect())
 	for {
 		log.Printf("[INFO] [core] [StreamServerRunStreamDo] Run stream: stream=%s", streamName)
 		opt, err := Storage.StreamChannelControl(streamID, channelID)
@@ -30,47 +27,106 @@ func StreamServerRunStreamDo(streamID string, channelID string, streamName strin
 			return
 		}
 		// 녹화 상태가 ON이면 클라이언트 없어도 스트림 유지
-		if opt.OnDemand && !opt.OnRecording && !Storage.ClientHas(streamID, channelID) {
+		if opt.OnDemand && !opt.OnRecording && !opt.Push && !Storage.ClientHas(streamID, channelID) {
 			log.Printf("[WARN] [core] [StreamServerRunStreamDo] Stop stream no client: stream=%s", streamName)
 			return
 		}
-		status, err = StreamServerRunStream(streamID, channelID, opt, streamName)
+		// backup_urls 가 있으면 현재 active url 로 연결
+		if failover == nil {
+			failover = newStreamFailover(opt, streamName)
+		}
+		opt.URL = failover.URL()
+		Storage.StreamChannelActiveURL(streamID, channelID, opt.URL, failover.Index())
+		failover.ProbeStart(*opt)
+		runStart := time.Now()
+		status, err = StreamServerRunStream(streamID, channelID, opt, streamName, failover)
+		failover.ProbeStop()
 		if status > 0 {
 			log.Printf("[WARN] [core] [StreamServerRunStreamDo] Stream exit by signal or not client: stream=%s", streamName)
 			return
 		}
+		run := time.Since(runStart)
+		// push 채널은 SourcePushWait 가 publisher 를 기다리므로 backoff 없이 바로 다시 대기
+		if opt.Push {
+			backoff.Reset()
+			Storage.StreamChannelDisconnected(streamID, channelID, err, 0)
+			if err != nil {
+				log.Printf("[WARN] [core] [StreamServerRunStreamDo] Publisher disconnected wait next: stream=%s error=%v", streamName, err)
+			}
+			continue
+		}
+		// 다른 url 로 넘어가면 바로 연결 (시청자 no video 타임아웃 전에)
+		if failover.Result(run, err) {
+			backoff.Reset()
+			Storage.StreamChannelDisconnected(streamID, channelID, err, 0)
+			continue
+		}
+		if run >= streamHealthyRun || err == ErrorStreamRestart {
+			backoff.Reset()
+		}
+		wait := backoff.Next()
+		Storage.StreamChannelDisconnected(streamID, channelID, err, wait)
 		if err != nil {
-			log.Printf("[WARN] [core] [StreamServerRunStreamDo] Stream error restart stream: stream=%s error=%v", streamName, err)
+			log.Printf("[WARN] [core] [StreamServerRunStreamDo] Stream error restart stream: stream=%s error=%v backoff=%s", streamName, err, wait.Round(time.Millisecond))
+		}
+		// 대기 중에도 stop, restart 신호 처리
+		status = streamBackoffWait(opt.signals, wait)
+		if status > 0 {
+			return
 		}
-		time.Sleep(2 * time.Second)
+		if status < 0 {
+			// restart 는 backoff 처음부터
+			backoff.Reset()
+			status = 0
+		}
+	}
+}
 
+// streamBackoffWait wait before reconnect, 1 (no client) / 2 (stop) 이면 종료
+// restart 는 대기를 끝내고 -1, 다음 loop 에서 channel 설정을 다시 읽고 바로 연결
+// keyframe 요청 등 다른 신호는 source 가 없으므로 무시하고 계속 대기
+func streamBackoffWait(signals chan int, wait time.Duration) int {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return 0
+		case signal := <-signals:
+			switch signal {
+			case SignalStreamStop:
+				return 2
+			case SignalStreamClient:
+				return 1
+			case SignalStreamRestart:
+				return -1
+			}
+		}
 	}
 }
 
 // StreamServerRunStream core stream
-func StreamServerRunStream(streamID, channelID string, opt *ChannelST, streamName string) (int, error) {
-	if url, err := url.Parse(opt.URL); err == nil && strings.ToLower(url.Scheme) == "rtmp" {
-		return StreamServerRunStreamRTMP(streamID, channelID, opt, streamName)
-	}
+func StreamServerRunStream(streamID, channelID string, opt *ChannelST, streamName string, failover *streamFailover) (int, error) {
 	keyTest := time.NewTimer(20 * time.Second)
 	checkClients := time.NewTimer(20 * time.Second)
 	var start bool
 	var fps int
 	var preKeyTS = time.Duration(0)
 	var Seq []*av.Packet
-	RTSPClient, err := rtspv2.Dial(rtspv2.RTSPClientOptions{
-		URL:                opt.URL,
-		InsecureSkipVerify: opt.InsecureSkipVerify,
-		DisableAudio:       !opt.Audio,
-		DialTimeout:        3 * time.Second,
-		ReadWriteTimeout:   15 * time.Second, // RTP 패킷 받아야하는 타임. 기존 5초
-		Debug:              opt.Debug,
-		OutgoingProxy:      true,
-	})
-	if err != nil {
+	var source Source
+	var err error
+	if opt.Push {
+		// push 채널은 publisher 접속까지 대기
+		log.Printf("[INFO] [core] [StreamServerRunStream] Wait publisher: stream=%s", streamName)
+		var status int
+		if source, status, err = SourcePushWait(streamID, channelID, opt.signals); err != nil {
+			return status, err
+		}
+	} else if source, err = SourceDial(opt); err != nil {
 		return 0, err
 	}
 	Storage.StreamChannelStatus(streamID, channelID, ONLINE)
+	Storage.StreamChannelConnected(streamID, channelID)
 
 	// on_recording이 ON이면 자동으로 녹화 시작
 	if opt.OnRecording {
@@ -81,23 +137,41 @@ func StreamServerRunStream(streamID, channelID string, opt *ChannelST, streamNam
 		}
 	}
 
+	ingest := newStreamIngest()
+	ingestTick := time.NewTicker(time.Second)
+	defer ingestTick.Stop()
 	defer func() {
-		RTSPClient.Close()
+		source.Close()
+		Storage.StreamChannelIngest(streamID, channelID, ingest.Stopped())
 		Storage.StreamChannelStatus(streamID, channelID, OFFLINE)
 		Storage.StreamHLSFlush(streamID, channelID)
+		Storage.StreamChannelGOPFlush(streamID, channelID)
 	}()
 	var WaitCodec bool
+	// source 가 rtp 를 안주면 (rtmp, srt ...) rtsp 재송출용 rtp 를 직접 만든다
+	var packetizer *RTSPPacketizer
+	codecsUpdate := func() {
+		// h265 hvcC 보정 후 muxer (mse, ll-hls) 에 전달
+		codecs := CodecsNormalize(source.CodecData())
+		sdp := source.SDP()
+		if source.RTPPackets() == nil {
+			packetizer = NewRTSPPacketizer(codecs)
+			sdp = packetizer.SDP()
+		}
+		Storage.StreamChannelCodecsUpdate(streamID, channelID, codecs, sdp)
+		ingest.Codecs(codecs)
+	}
 	/*
 		Example wait codec
 	*/
-	if RTSPClient.WaitCodec {
+	if source.WaitCodec() {
 		WaitCodec = true
 	} else {
-		if len(RTSPClient.CodecData) > 0 {
-			Storage.StreamChannelCodecsUpdate(streamID, channelID, RTSPClient.CodecData, RTSPClient.SDPRaw)
+		if len(source.CodecData()) > 0 {
+			codecsUpdate()
 		}
 	}
-	log.Printf("[INFO] [core] [StreamServerRunStream] Success connection RTSP: stream=%s", streamName)
+	log.Printf("[INFO] [core] [StreamServerRunStream] Success connection source: stream=%s", streamName)
 	var ProbeCount int
 	var ProbeFrame int
 	var ProbePTS time.Duration
@@ -108,7 +182,7 @@ func StreamServerRunStream(streamID, channelID string, opt *ChannelST, streamNam
 		//Check stream have clients
 		case <-checkClients.C:
 			// 클라이언트 없어짐. 녹화 옵션 off로 바뀜. -> 스트림 종료
-			if opt.OnDemand && !opt.OnRecording && !Storage.ClientHas(streamID, channelID) {
+			if opt.OnDemand && !opt.OnRecording && !opt.Push && !Storage.ClientHas(streamID, channelID) {
 				return 1, ErrorStreamNoClients
 			}
 			checkClients.Reset(20 * time.Second)
@@ -124,19 +198,40 @@ func StreamServerRunStream(streamID, channelID string, opt *ChannelST, streamNam
 				return 0, ErrorStreamRestart
 			case SignalStreamClient:
 				return 1, ErrorStreamNoClients
+			case SignalStreamKeyFrame:
+				if requester, ok := source.(SourceKeyFrameRequester); ok {
+					requester.RequestKeyFrame()
+				}
 			}
-		//Read rtsp signals
-		case signals := <-RTSPClient.Signals:
+		//Read source signals
+		case signals := <-source.Signals():
 			switch signals {
-			case rtspv2.SignalCodecUpdate:
-				Storage.StreamChannelCodecsUpdate(streamID, channelID, RTSPClient.CodecData, RTSPClient.SDPRaw)
+			case SourceSignalCodecUpdate:
+				codecsUpdate()
 				WaitCodec = false
-			case rtspv2.SignalStreamRTPStop:
-				return 0, ErrorStreamStopRTSPSignal
+			case SourceSignalStop:
+				return 0, ErrorStreamStopSourceSignal
+			}
+		// primary 복구, 새 source 로 끊김 없이 교체
+		case recovered := <-failover.Recovered():
+			source.Close()
+			source = recovered
+			failover.Fallback()
+			Storage.StreamChannelActiveURL(streamID, channelID, failover.URL(), failover.Index())
+			Storage.StreamHLSFlush(streamID, channelID)
+			Seq = []*av.Packet{}
+			preKeyTS = 0
+			packetizer = nil
+			WaitCodec = source.WaitCodec()
+			if !WaitCodec && len(source.CodecData()) > 0 {
+				codecsUpdate()
 			}
-		case packetRTP := <-RTSPClient.OutgoingProxyQueue:
+			keyTest.Reset(20 * time.Second)
+		case <-ingestTick.C:
+			Storage.StreamChannelIngest(streamID, channelID, ingest.Tick())
+		case packetRTP := <-source.RTPPackets():
 			Storage.StreamChannelCastProxy(streamID, channelID, packetRTP)
-		case packetAV := <-RTSPClient.OutgoingPacketQueue:
+		case packetAV := <-source.Packets():
 			if WaitCodec {
 				continue
 			}
@@ -149,132 +244,14 @@ func StreamServerRunStream(streamID, channelID string, opt *ChannelST, streamNam
 				}
 				preKeyTS = packetAV.Time
 			}
+			ingest.Packet(packetAV)
 			Seq = append(Seq, packetAV)
 			Storage.StreamChannelCast(streamID, channelID, packetAV)
-			/*
-			   HLS LL Test
-			*/
-			if packetAV.IsKeyFrame && !start {
-				start = true
-			}
-			/*
-				FPS mode probe
-			*/
-			if start {
-				ProbePTS += packetAV.Duration
-				ProbeFrame++
-				if packetAV.IsKeyFrame && ProbePTS.Seconds() >= 1 {
-					ProbeCount++
-					if ProbeCount == 2 {
-						fps = int(math.Round(float64(ProbeFrame) / ProbePTS.Seconds()))
-					}
-					ProbeFrame = 0
-					ProbePTS = 0
+			if packetizer != nil {
+				for _, packetRTP := range packetizer.WritePacket(packetAV) {
+					Storage.StreamChannelCastProxy(streamID, channelID, packetRTP)
 				}
 			}
-			if start && fps != 0 {
-				//TODO fix it
-				packetAV.Duration = time.Duration((float32(1000)/float32(fps))*1000*1000) * time.Nanosecond
-				Storage.HlsMuxerSetFPS(streamID, channelID, fps)
-				Storage.HlsMuxerWritePacket(streamID, channelID, packetAV)
-			}
-		}
-	}
-}
-func StreamServerRunStreamRTMP(streamID, channelID string, opt *ChannelST, streamName string) (int, error) {
-	keyTest := time.NewTimer(20 * time.Second)
-	checkClients := time.NewTimer(20 * time.Second)
-	OutgoingPacketQueue := make(chan *av.Packet, 1000)
-	Signals := make(chan int, 100)
-	var start bool
-	var fps int
-	var preKeyTS = time.Duration(0)
-	var Seq []*av.Packet
-
-	conn, err := rtmp.DialTimeout(opt.URL, 3*time.Second)
-	if err != nil {
-		return 0, err
-	}
-
-	Storage.StreamChannelStatus(streamID, channelID, ONLINE)
-	defer func() {
-		conn.Close()
-		Storage.StreamChannelStatus(streamID, channelID, OFFLINE)
-		Storage.StreamHLSFlush(streamID, channelID)
-	}()
-	var WaitCodec bool
-
-	codecs, err := conn.Streams()
-	if err != nil {
-		return 0, err
-	}
-	preDur := make([]time.Duration, len(codecs))
-	Storage.StreamChannelCodecsUpdate(streamID, channelID, codecs, []byte{})
-
-	log.Printf("[INFO] [core] [StreamServerRunStreamRTMP] Success connection RTSP: stream=%s", streamName)
-	var ProbeCount int
-	var ProbeFrame int
-	var ProbePTS time.Duration
-	Storage.NewHLSMuxer(streamID, channelID)
-	defer Storage.HLSMuxerClose(streamID, channelID)
-
-	go func() {
-		for {
-			ptk, err := conn.ReadPacket()
-			if err != nil {
-				break
-			}
-			OutgoingPacketQueue <- &ptk
-		}
-		Signals <- 1
-	}()
-
-	for {
-		select {
-		//Check stream have clients
-		case <-checkClients.C:
-			// 클라이언트 없어짐. 녹화 옵션 off로 바뀜. -> 스트림 종료
-			if opt.OnDemand && !opt.OnRecording && !Storage.ClientHas(streamID, channelID) {
-				return 1, ErrorStreamNoClients
-			}
-			checkClients.Reset(20 * time.Second)
-		//Check stream send key
-		case <-keyTest.C:
-			return 0, ErrorStreamNoVideo
-		//Read core signals
-		case signals := <-opt.signals:
-			switch signals {
-			case SignalStreamStop:
-				return 2, ErrorStreamStopCoreSignal
-			case SignalStreamRestart:
-				return 0, ErrorStreamRestart
-			case SignalStreamClient:
-				return 1, ErrorStreamNoClients
-			}
-		//Read rtsp signals
-		case <-Signals:
-			return 0, ErrorStreamStopRTSPSignal
-		case packetAV := <-OutgoingPacketQueue:
-			if preDur[packetAV.Idx] != 0 {
-				packetAV.Duration = packetAV.Time - preDur[packetAV.Idx]
-			}
-
-			preDur[packetAV.Idx] = packetAV.Time
-
-			if WaitCodec {
-				continue
-			}
-
-			if packetAV.IsKeyFrame {
-				keyTest.Reset(20 * time.Second)
-				if preKeyTS > 0 {
-					Storage.StreamHLSAdd(streamID, channelID, Seq, packetAV.Time-preKeyTS)
-					Seq = []*av.Packet{}
-				}
-				preKeyTS = packetAV.Time
-			}
-			Seq = append(Seq, packetAV)
-			Storage.StreamChannelCast(streamID, channelID, packetAV)
 			/*
 			   HLS LL Test
 			*/
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreamBackoffWait(t *testing.T) {
+	signals := make(chan int, 10)
+	// keyframe 요청은 대기를 끝내지 않음
+	signals <- SignalStreamKeyFrame
+	start := time.Now()
+	if status := streamBackoffWait(signals, 50*time.Millisecond); status != 0 || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("wait with keyframe signal = %d after %s, want 0 after 50ms", status, time.Since(start))
+	}
+	for signal, want := range map[int]int{SignalStreamRestart: -1, SignalStreamClient: 1, SignalStreamStop: 2} {
+		signals <- signal
+		if status := streamBackoffWait(signals, time.Minute); status != want {
+			t.Errorf("wait with signal %d = %d, want %d", signal, status, want)
+		}
+	}
+}
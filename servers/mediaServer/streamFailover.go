@@ -0,0 +1,148 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// streamHealthyRun 이 시간 이상 돌았으면 정상 연결로 보고 실패 횟수, backoff 초기화
+	streamHealthyRun = 30 * time.Second
+	// failoverThresholdDefault backup 으로 넘어가기 전 연속 실패 횟수
+	failoverThresholdDefault = 3
+	// failoverProbeDefault backup 사용 중 primary 확인 주기
+	failoverProbeDefault = 30 * time.Second
+)
+
+// streamFailover primary url + backup_urls 전환 상태, StreamServerRunStreamDo 마다 하나
+type streamFailover struct {
+	streamName string
+	urls       []string
+	index      int
+	failures   int
+	threshold  int
+	fallback   bool
+	probe      time.Duration
+	recovered  chan Source // primary 복구 시 probe 가 연결해 둔 source
+	probeStop  chan struct{}
+	probeWait  sync.WaitGroup
+}
+
+// newStreamFailover failover state of channel
+func newStreamFailover(opt *ChannelST, streamName string) *streamFailover {
+	failover := &streamFailover{
+		streamName: streamName,
+		urls:       append([]string{opt.URL}, opt.BackupURLs...),
+		threshold:  opt.FailoverThreshold,
+		fallback:   opt.FailoverFallback,
+		probe:      time.Duration(opt.FailoverProbe) * time.Second,
+		recovered:  make(chan Source),
+	}
+	if failover.threshold <= 0 {
+		failover.threshold = failoverThresholdDefault
+	}
+	if failover.probe <= 0 {
+		failover.probe = failoverProbeDefault
+	}
+	return failover
+}
+
+// Enabled has backup urls
+func (failover *streamFailover) Enabled() bool {
+	return len(failover.urls) > 1
+}
+
+// URL current active url
+func (failover *streamFailover) URL() string {
+	return failover.urls[failover.index]
+}
+
+// Index current active url index, 0 primary
+func (failover *streamFailover) Index() int {
+	return failover.index
+}
+
+// Result count run result, switch to next url after threshold failures. true if switched
+func (failover *streamFailover) Result(run time.Duration, err error) bool {
+	if run >= streamHealthyRun || err == ErrorStreamRestart {
+		failover.failures = 0
+		return false
+	}
+	failover.failures++
+	if !failover.Enabled() || failover.failures < failover.threshold {
+		return false
+	}
+	failover.failures = 0
+	failover.index = (failover.index + 1) % len(failover.urls)
+	log.Printf("[WARN] [core] [streamFailover] Switch url: stream=%s index=%d url=%s", failover.streamName, failover.index, failoverRedactURL(failover.URL()))
+	return true
+}
+
+// Recovered primary source ready to take over (nil channel if probe not running)
+func (failover *streamFailover) Recovered() <-chan Source {
+	return failover.recovered
+}
+
+// Fallback primary source taken over
+func (failover *streamFailover) Fallback() {
+	failover.index = 0
+	failover.failures = 0
+	log.Printf("[INFO] [core] [streamFailover] Fallback to primary url: stream=%s", failover.streamName)
+}
+
+// ProbeStart dial primary periodically while on backup, hand over connected source
+func (failover *streamFailover) ProbeStart(opt ChannelST) {
+	if !failover.fallback || failover.index == 0 {
+		return
+	}
+	opt.URL = failover.urls[0]
+	failover.probeStop = make(chan struct{})
+	failover.probeWait.Add(1)
+	go func(stop chan struct{}) {
+		defer failover.probeWait.Done()
+		ticker := time.NewTicker(failover.probe)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			source, err := SourceDial(&opt)
+			if err != nil {
+				if opt.Debug {
+					log.Printf("[INFO] [core] [streamFailover] Primary not ready: stream=%s error=%v", failover.streamName, err)
+				}
+				continue
+			}
+			select {
+			case failover.recovered <- source:
+			case <-stop:
+				source.Close()
+			}
+			return
+		}
+	}(failover.probeStop)
+}
+
+// ProbeStop stop primary probe and wait
+func (failover *streamFailover) ProbeStop() {
+	if failover.probeStop == nil {
+		return
+	}
+	close(failover.probeStop)
+	failover.probeWait.Wait()
+	failover.probeStop = nil
+}
+
+// failoverRedactURL url without credentials for log, monitoring
+func failoverRedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	u.User = nil
+	return u.String()
+}
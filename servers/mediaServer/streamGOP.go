@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+const (
+	// gopCacheMax av 캐시 (MSE / WebRTC) 최대 video packet 수, 넘는 긴 GOP 는 캐시 안함
+	gopCacheMax = 800
+	// gopRTPCacheBytes rtp 캐시 (RTSP) 최대 크기, audio 와 fragment 를 포함하므로 packet 수 대신 byte 로 제한
+	gopRTPCacheBytes = 4 << 20
+	// gopReplayStep replay 프레임 간격, 캐시된 GOP 를 바로 디코딩해서 live 시점부터 보이게
+	gopReplayStep = time.Millisecond
+	// streamKeyFrameInterval viewer keyframe 요청 (pli / fir) 최소 간격
+	streamKeyFrameInterval = time.Second
+)
+
+// streamGOP packets since last keyframe of channel, replay to new clients (ClientAdd)
+type streamGOP struct {
+	packets    []*av.Packet // video only
+	videoIdx   int8
+	rtp        []*[]byte // rtsp proxy, interleaved
+	rtpChannel byte
+	rtpHEVC    bool
+	rtpKey     bool
+	rtpTS      uint32
+	rtpBytes   int
+	rtpUnit    bool   // 현재 video 프레임이 키프레임
+	rtpUnitTS  uint32 // 현재 video 프레임 rtp timestamp
+}
+
+// newStreamGOP empty cache for codec list
+func newStreamGOP(codecs []av.CodecData) streamGOP {
+	gop := streamGOP{videoIdx: -1}
+	for i, codec := range codecs {
+		if codec.Type().IsVideo() {
+			gop.videoIdx = int8(i)
+			gop.rtpChannel = byte(i * 2)
+			gop.rtpHEVC = codec.Type() == av.H265
+			break
+		}
+	}
+	return gop
+}
+
+// Packet add av packet, keyframe restart cache
+func (gop *streamGOP) Packet(packet *av.Packet) {
+	if packet.Idx != gop.videoIdx {
+		return
+	}
+	if packet.IsKeyFrame {
+		gop.packets = append(gop.packets[:0], packet)
+		return
+	}
+	if len(gop.packets) == 0 {
+		return
+	}
+	if len(gop.packets) >= gopCacheMax {
+		gop.packets = gop.packets[:0]
+		return
+	}
+	gop.packets = append(gop.packets, packet)
+}
+
+// RTP add interleaved rtp packet, first packet of keyframe restart cache
+// return keyFrame 키프레임 시작 packet, keyPart 같은 timestamp 의 나머지 키프레임 packet (FU-A / FU fragment 등)
+func (gop *streamGOP) RTP(packet *[]byte) (bool, bool) {
+	timestamp, keyFrame := gopRTPKeyFrame(*packet, gop.rtpChannel, gop.rtpHEVC)
+	keyPart := false
+	if keyFrame {
+		gop.rtpUnit = true
+		gop.rtpUnitTS = timestamp
+	} else if len(*packet) >= 4+12 && (*packet)[0] == 0x24 && (*packet)[1] == gop.rtpChannel {
+		keyPart = gop.rtpUnit && binary.BigEndian.Uint32((*packet)[8:12]) == gop.rtpUnitTS
+		gop.rtpUnit = keyPart
+	}
+	if keyFrame && (!gop.rtpKey || timestamp != gop.rtpTS) {
+		gop.rtp = append(gop.rtp[:0], packet)
+		gop.rtpBytes = len(*packet)
+		gop.rtpKey = true
+		gop.rtpTS = timestamp
+		return keyFrame, keyPart
+	}
+	if !gop.rtpKey {
+		return keyFrame, keyPart
+	}
+	if gop.rtpBytes+len(*packet) > gopRTPCacheBytes {
+		gop.rtp = gop.rtp[:0]
+		gop.rtpBytes = 0
+		gop.rtpKey = false
+		return keyFrame, keyPart
+	}
+	gop.rtp = append(gop.rtp, packet)
+	gop.rtpBytes += len(*packet)
+	return keyFrame, keyPart
+}
+
+// Replay copy of cached video packets for new client
+// 시간은 마지막 캐시 프레임에 맞춰 gopReplayStep 간격으로 압축, 다음 live 패킷과 이어진다
+func (gop *streamGOP) Replay() []*av.Packet {
+	count := len(gop.packets)
+	if count == 0 {
+		return nil
+	}
+	last := gop.packets[count-1].Time
+	step := gopReplayStep
+	if count > 1 {
+		if span := (last - gop.packets[0].Time) / time.Duration(count-1); span < step {
+			step = span
+		}
+	}
+	replay := make([]*av.Packet, count)
+	for i, packet := range gop.packets {
+		packetTmp := *packet
+		packetTmp.Time = last - time.Duration(count-1-i)*step
+		packetTmp.CompositionTime = 0
+		if i < count-1 {
+			packetTmp.Duration = step
+		}
+		replay[i] = &packetTmp
+	}
+	return replay
+}
+
+// gopRTPKeyFrame rtp timestamp if interleaved packet start keyframe (sps / vps or idr) of video channel
+func gopRTPKeyFrame(packet []byte, channel byte, hevc bool) (uint32, bool) {
+	if len(packet) < 4+12 || packet[0] != 0x24 || packet[1] != channel {
+		return 0, false
+	}
+	rtp := packet[4:]
+	offset := 12 + int(rtp[0]&0x0f)*4
+	if rtp[0]&0x10 != 0 {
+		if len(rtp) < offset+4 {
+			return 0, false
+		}
+		offset += 4 + int(binary.BigEndian.Uint16(rtp[offset+2:]))*4
+	}
+	if len(rtp) < offset+3 {
+		return 0, false
+	}
+	timestamp := binary.BigEndian.Uint32(rtp[4:8])
+	payload := rtp[offset:]
+	if hevc {
+		naluType := (payload[0] >> 1) & 0x3f
+		switch naluType {
+		case 48: // AP, 첫 nal
+			if len(payload) < 5 {
+				return 0, false
+			}
+			naluType = (payload[4] >> 1) & 0x3f
+		case 49: // FU, 시작 fragment 만
+			if payload[2]&0x80 == 0 {
+				return 0, false
+			}
+			naluType = payload[2] & 0x3f
+		}
+		// IDR_W_RADL, IDR_N_LP, CRA, VPS
+		return timestamp, naluType >= 19 && naluType <= 21 || naluType == 32
+	}
+	naluType := payload[0] & 0x1f
+	switch naluType {
+	case 24: // STAP-A, 첫 nal
+		if len(payload) < 4 {
+			return 0, false
+		}
+		naluType = payload[3] & 0x1f
+	case 28: // FU-A, 시작 fragment 만
+		if payload[1]&0x80 == 0 {
+			return 0, false
+		}
+		naluType = payload[1] & 0x1f
+	}
+	// IDR, SPS
+	return timestamp, naluType == 5 || naluType == 7
+}
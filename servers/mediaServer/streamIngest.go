@@ -0,0 +1,98 @@
+package main
+
+import (
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+// ingestWindow rolling window 초 (bitrate, fps)
+const ingestWindow = 5
+
+// streamIngest ingest loop 통계, Tick 마다 storage 반영
+type streamIngest struct {
+	stats       ChannelIngestST
+	videoIdx    int8
+	gopFrames   int
+	lastKeyTime time.Duration
+	hasKey      bool
+	// 초 단위 ring buffer
+	windowBytes  [ingestWindow]int
+	windowFrames [ingestWindow]int
+	windowPos    int
+	windowFilled int
+}
+
+// newStreamIngest empty stats
+func newStreamIngest() *streamIngest {
+	return &streamIngest{videoIdx: -1}
+}
+
+// Codecs video track, resolution, codec name
+func (ingest *streamIngest) Codecs(codecs []av.CodecData) {
+	ingest.videoIdx = -1
+	ingest.stats.VideoCodec = ""
+	ingest.stats.Width, ingest.stats.Height = 0, 0
+	for i, codec := range codecs {
+		if !codec.Type().IsVideo() {
+			continue
+		}
+		ingest.videoIdx = int8(i)
+		ingest.stats.VideoCodec = codec.Type().String()
+		if video, ok := codec.(av.VideoCodecData); ok {
+			ingest.stats.Width, ingest.stats.Height = video.Width(), video.Height()
+		}
+		break
+	}
+	// 새 source 는 timestamp 가 다시 시작
+	ingest.hasKey = false
+	ingest.gopFrames = 0
+}
+
+// Packet count packet
+func (ingest *streamIngest) Packet(packet *av.Packet) {
+	ingest.stats.Packets++
+	ingest.stats.Bytes += uint64(len(packet.Data))
+	ingest.windowBytes[ingest.windowPos] += len(packet.Data)
+	if packet.Idx != ingest.videoIdx {
+		return
+	}
+	ingest.windowFrames[ingest.windowPos]++
+	if packet.IsKeyFrame {
+		if ingest.hasKey {
+			ingest.stats.GOPLength = ingest.gopFrames
+			ingest.stats.KeyFrameInterval = (packet.Time - ingest.lastKeyTime).Seconds()
+		}
+		ingest.hasKey = true
+		ingest.lastKeyTime = packet.Time
+		ingest.gopFrames = 0
+		ingest.stats.LastKeyFrame = time.Now()
+	}
+	ingest.gopFrames++
+}
+
+// Tick close one second slot, return snapshot
+func (ingest *streamIngest) Tick() ChannelIngestST {
+	if ingest.windowFilled < ingestWindow {
+		ingest.windowFilled++
+	}
+	var bytes, frames int
+	for i := 0; i < ingest.windowFilled; i++ {
+		bytes += ingest.windowBytes[i]
+		frames += ingest.windowFrames[i]
+	}
+	ingest.stats.BitrateKbps = float64(bytes*8) / float64(ingest.windowFilled) / 1000
+	ingest.stats.FPS = float64(frames) / float64(ingest.windowFilled)
+	ingest.windowPos = (ingest.windowPos + 1) % ingestWindow
+	ingest.windowBytes[ingest.windowPos] = 0
+	ingest.windowFrames[ingest.windowPos] = 0
+	return ingest.stats
+}
+
+// Stopped snapshot after source closed, rates zero
+func (ingest *streamIngest) Stopped() ChannelIngestST {
+	stats := ingest.stats
+	stats.BitrateKbps = 0
+	stats.FPS = 0
+	return stats
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net"
+)
+
+// LimitUsageST viewers and egress of channel / stream / server with limit (0 unlimited)
+type LimitUsageST struct {
+	Viewers       int     `json:"viewers"`
+	MaxViewers    int     `json:"max_viewers"`
+	EgressKbps    float64 `json:"egress_kbps"` // ingest bitrate x viewers 추정
+	MaxEgressKbps float64 `json:"max_egress_kbps,omitempty"`
+}
+
+// remoteLoopback remote address (ip or ip:port) of connection is loopback
+func remoteLoopback(remote string) bool {
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		host = remote
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// limitIsError true if err is viewer or bandwidth limit rejection
+func limitIsError(err error) bool {
+	switch err {
+	case ErrorLimitViewersChannel, ErrorLimitViewersStream, ErrorLimitViewersServer, ErrorLimitBandwidth:
+		return true
+	}
+	return false
+}
+
+// channelViewers viewer count of channel without internal clients, hls / transcode 는 session 수
+func channelViewers(channel ChannelST, detached int) int {
+	viewers := detached
+	for _, client := range channel.clients {
+		if !client.internal {
+			viewers++
+		}
+	}
+	return viewers
+}
+
+// limitUsages usage of every channel ("stream/channel"), stream and server in one pass, storage lock 안에서 호출
+func (obj *StorageST) limitUsages() (map[string]LimitUsageST, map[string]LimitUsageST, LimitUsageST) {
+	limits := obj.Server.Limits
+	detached := Sessions.DetachedCounts()
+	channels := make(map[string]LimitUsageST)
+	streams := make(map[string]LimitUsageST, len(obj.Streams))
+	var serverUsage LimitUsageST
+	for sid, stream := range obj.Streams {
+		streamUsage := LimitUsageST{MaxViewers: stream.MaxViewers}
+		if streamUsage.MaxViewers == 0 {
+			streamUsage.MaxViewers = limits.MaxViewersStream
+		}
+		for cid, channel := range stream.Channels {
+			channelUsage := LimitUsageST{MaxViewers: channel.MaxViewers}
+			if channelUsage.MaxViewers == 0 {
+				channelUsage.MaxViewers = limits.MaxViewersChannel
+			}
+			channelUsage.Viewers = channelViewers(channel, detached[sid+"/"+cid])
+			channelUsage.EgressKbps = channel.ingest.BitrateKbps * float64(channelUsage.Viewers)
+			channels[sid+"/"+cid] = channelUsage
+			streamUsage.Viewers += channelUsage.Viewers
+			streamUsage.EgressKbps += channelUsage.EgressKbps
+		}
+		streams[sid] = streamUsage
+		serverUsage.Viewers += streamUsage.Viewers
+		serverUsage.EgressKbps += streamUsage.EgressKbps
+	}
+	serverUsage.MaxViewers = limits.MaxViewers
+	serverUsage.MaxEgressKbps = limits.MaxEgressKbps
+	return channels, streams, serverUsage
+}
+
+// limitUsage channel, stream, server usage, storage lock 안에서 호출
+func (obj *StorageST) limitUsage(streamID string, channelID string) (LimitUsageST, LimitUsageST, LimitUsageST) {
+	channels, streams, serverUsage := obj.limitUsages()
+	return channels[streamID+"/"+channelID], streams[streamID], serverUsage
+}
+
+// clientLimit check one more viewer of channel, storage lock 안에서 호출
+func (obj *StorageST) clientLimit(streamID string, channelID string) error {
+	channelUsage, streamUsage, serverUsage := obj.limitUsage(streamID, channelID)
+	switch {
+	case channelUsage.MaxViewers > 0 && channelUsage.Viewers >= channelUsage.MaxViewers:
+		return ErrorLimitViewersChannel
+	case streamUsage.MaxViewers > 0 && streamUsage.Viewers >= streamUsage.MaxViewers:
+		return ErrorLimitViewersStream
+	case serverUsage.MaxViewers > 0 && serverUsage.Viewers >= serverUsage.MaxViewers:
+		return ErrorLimitViewersServer
+	}
+	bitrate := obj.Streams[streamID].Channels[channelID].ingest.BitrateKbps
+	if serverUsage.MaxEgressKbps > 0 && serverUsage.EgressKbps+bitrate > serverUsage.MaxEgressKbps {
+		return ErrorLimitBandwidth
+	}
+	return nil
+}
+
+// ClientLimit check before protocol handshake (mse upgrade, webrtc answer, hls session)
+func (obj *StorageST) ClientLimit(streamID string, channelID string) error {
+	obj.mutex.RLock()
+	defer obj.mutex.RUnlock()
+	return obj.clientLimit(streamID, channelID)
+}
+
+// ServerLimitUsage server viewers and egress
+func (obj *StorageST) ServerLimitUsage() LimitUsageST {
+	obj.mutex.RLock()
+	defer obj.mutex.RUnlock()
+	_, _, serverUsage := obj.limitUsage("", "")
+	return serverUsage
+}
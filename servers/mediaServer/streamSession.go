@@ -0,0 +1,282 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default session protocols
+const (
+	SessionMSE    = "MSE"
+	SessionWebRTC = "WebRTC"
+	SessionRTSP   = "RTSP"
+	SessionHLS    = "HLS"
+)
+
+// sessionHLSIdle hls 는 요청 단위라 이 시간 요청이 없으면 종료로 본다
+const sessionHLSIdle = 30 * time.Second
+
+// SessionST viewer session
+type SessionST struct {
+	ID        string         `json:"id"`
+	StreamID  string         `json:"stream_id"`
+	ChannelID string         `json:"channel_id"`
+	Protocol  string         `json:"protocol"` // MSE, WebRTC, RTSP, HLS
+	Remote    string         `json:"remote"`
+	User      string         `json:"user,omitempty"` // token 또는 인증 사용자
+	Start     time.Time      `json:"start"`
+	LastSeen  time.Time      `json:"last_seen"`
+	BytesSent uint64         `json:"bytes_sent"`
+	Dropped   uint64         `json:"dropped_packets"`
+	Lag       float64        `json:"lag"`            // backpressure 로 밀린 초
+	RTCP      *SessionRTCPST `json:"rtcp,omitempty"` // webrtc viewer receiver report
+	bytes     uint64         // atomic
+	kick      func()         // 연결 종료, nil 이면 hls (다음 요청부터 거부)
+	kicked    bool
+	detached  bool // channel client 가 아님 (hls, transcode, rtsp replay), limits 에서 따로 셈
+}
+
+// SessionRTCPST webrtc viewer rtcp stats (video receiver report, nack / pli / fir 수)
+type SessionRTCPST struct {
+	PacketsLost  int64     `json:"packets_lost"`
+	FractionLost float64   `json:"fraction_lost"` // 마지막 report 구간 손실률 0 ~ 1
+	JitterMs     float64   `json:"jitter_ms"`
+	RTTMs        float64   `json:"rtt_ms"`
+	NACK         uint64    `json:"nack"` // 재전송 요청 packet 수
+	PLI          uint64    `json:"pli"`
+	FIR          uint64    `json:"fir"`
+	Updated      time.Time `json:"updated"`
+}
+
+// SessionRTCPSummaryST rtcp stats of channel webrtc viewers (monitoring)
+type SessionRTCPSummaryST struct {
+	Sessions        int     `json:"sessions"`
+	AvgFractionLost float64 `json:"avg_fraction_lost"`
+	MaxJitterMs     float64 `json:"max_jitter_ms"`
+	MaxRTTMs        float64 `json:"max_rtt_ms"`
+	NACK            uint64  `json:"nack"`
+	KeyFrames       uint64  `json:"keyframe_requests"` // pli + fir
+}
+
+// SessionFilterST GET /api/sessions query
+type SessionFilterST struct {
+	StreamID  string `form:"stream"`
+	ChannelID string `form:"channel"`
+	Protocol  string `form:"protocol"`
+	Remote    string `form:"remote"`
+	User      string `form:"user"`
+}
+
+// SessionRegistryST all viewer sessions
+// Storage 잠금 안에서 Add / Delete 호출됨, registry 잠금 안에서 Storage 호출 금지
+type SessionRegistryST struct {
+	mutex sync.RWMutex
+	list  map[string]*SessionST
+}
+
+// Sessions viewer session registry
+var Sessions = &SessionRegistryST{list: make(map[string]*SessionST)}
+
+// Add register session
+func (obj *SessionRegistryST) Add(session *SessionST) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	session.Start = time.Now()
+	session.LastSeen = session.Start
+	obj.list[session.ID] = session
+}
+
+// Delete unregister session
+func (obj *SessionRegistryST) Delete(id string) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	delete(obj.list, id)
+}
+
+// Sent count bytes sent to viewer
+func (obj *SessionRegistryST) Sent(id string, n int) {
+	if n <= 0 {
+		return
+	}
+	obj.mutex.RLock()
+	session, ok := obj.list[id]
+	obj.mutex.RUnlock()
+	if ok {
+		atomic.AddUint64(&session.bytes, uint64(n))
+	}
+}
+
+// RTCP update rtcp stats of viewer
+func (obj *SessionRegistryST) RTCP(id string, stats SessionRTCPST) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	if session, ok := obj.list[id]; ok {
+		session.RTCP = &stats
+	}
+}
+
+// RTCPSummary rtcp stats by stream/channel
+func (obj *SessionRegistryST) RTCPSummary() map[string]SessionRTCPSummaryST {
+	obj.mutex.RLock()
+	defer obj.mutex.RUnlock()
+	summary := make(map[string]SessionRTCPSummaryST)
+	for _, session := range obj.list {
+		if session.RTCP == nil {
+			continue
+		}
+		key := session.StreamID + "/" + session.ChannelID
+		channel := summary[key]
+		channel.Sessions++
+		channel.AvgFractionLost += (session.RTCP.FractionLost - channel.AvgFractionLost) / float64(channel.Sessions)
+		channel.MaxJitterMs = max(channel.MaxJitterMs, session.RTCP.JitterMs)
+		channel.MaxRTTMs = max(channel.MaxRTTMs, session.RTCP.RTTMs)
+		channel.NACK += session.RTCP.NACK
+		channel.KeyFrames += session.RTCP.PLI + session.RTCP.FIR
+		summary[key] = channel
+	}
+	return summary
+}
+
+// HLS find or create hls session of remote, false if kicked
+func (obj *SessionRegistryST) HLS(streamID string, channelID string, remote string, user string) (string, bool) {
+	id := "hls-" + streamID + "-" + channelID + "-" + remote
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	obj.expire()
+	session, ok := obj.list[id]
+	if !ok {
+		now := time.Now()
+		session = &SessionST{ID: id, StreamID: streamID, ChannelID: channelID, Protocol: SessionHLS, Remote: remote, User: user, Start: now, detached: true}
+		obj.list[id] = session
+	}
+	session.LastSeen = time.Now()
+	return id, !session.kicked
+}
+
+// HLSActive hls session of remote exist (not new viewer)
+func (obj *SessionRegistryST) HLSActive(streamID string, channelID string, remote string) bool {
+	obj.mutex.RLock()
+	defer obj.mutex.RUnlock()
+	session, ok := obj.list["hls-"+streamID+"-"+channelID+"-"+remote]
+	return ok && time.Since(session.LastSeen) <= sessionHLSIdle
+}
+
+// DetachedCounts active hls, transcode and rtsp replay viewers by stream/channel
+func (obj *SessionRegistryST) DetachedCounts() map[string]int {
+	obj.mutex.RLock()
+	defer obj.mutex.RUnlock()
+	counts := make(map[string]int)
+	for _, session := range obj.list {
+		if session.Protocol == SessionHLS && time.Since(session.LastSeen) > sessionHLSIdle {
+			continue
+		}
+		if session.detached && !session.kicked {
+			counts[session.StreamID+"/"+session.ChannelID]++
+		}
+	}
+	return counts
+}
+
+// expire drop idle hls sessions, lock 안에서 호출
+func (obj *SessionRegistryST) expire() {
+	for id, session := range obj.list {
+		if session.Protocol == SessionHLS && time.Since(session.LastSeen) > sessionHLSIdle {
+			delete(obj.list, id)
+		}
+	}
+}
+
+// List sessions matching filter, oldest first
+func (obj *SessionRegistryST) List(filter SessionFilterST) []SessionST {
+	obj.mutex.Lock()
+	obj.expire()
+	list := make([]SessionST, 0, len(obj.list))
+	for _, session := range obj.list {
+		if session.kicked ||
+			filter.StreamID != "" && session.StreamID != filter.StreamID ||
+			filter.ChannelID != "" && session.ChannelID != filter.ChannelID ||
+			filter.Protocol != "" && !strings.EqualFold(session.Protocol, filter.Protocol) ||
+			filter.Remote != "" && !strings.HasPrefix(session.Remote, filter.Remote) ||
+			filter.User != "" && session.User != filter.User {
+			continue
+		}
+		list = append(list, session.snapshot())
+	}
+	obj.mutex.Unlock()
+	// backpressure 통계는 Storage 에서
+	for i := range list {
+		if list[i].Protocol == SessionHLS {
+			continue
+		}
+		list[i].Dropped, list[i].Lag = Storage.ClientStats(list[i].StreamID, list[i].ChannelID, list[i].ID)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Start.Before(list[j].Start)
+	})
+	return list
+}
+
+// snapshot copy of exported fields, lock 안에서 호출
+// bytes 는 Sent 가 잠금 없이 더하므로 struct 통째로 복사하지 않고 atomic 으로 읽는다
+func (session *SessionST) snapshot() SessionST {
+	sessionTmp := SessionST{
+		ID:        session.ID,
+		StreamID:  session.StreamID,
+		ChannelID: session.ChannelID,
+		Protocol:  session.Protocol,
+		Remote:    session.Remote,
+		User:      session.User,
+		Start:     session.Start,
+		LastSeen:  session.LastSeen,
+		BytesSent: atomic.LoadUint64(&session.bytes),
+	}
+	// RTCP 는 잠금 안에서 새 값으로 교체되므로 값을 복사해 둔다
+	if session.RTCP != nil {
+		rtcp := *session.RTCP
+		sessionTmp.RTCP = &rtcp
+	}
+	return sessionTmp
+}
+
+// Kick disconnect viewer
+func (obj *SessionRegistryST) Kick(id string) error {
+	obj.mutex.Lock()
+	session, ok := obj.list[id]
+	if !ok || session.kicked {
+		obj.mutex.Unlock()
+		return ErrorSessionNotFound
+	}
+	kick := session.kick
+	if kick == nil {
+		session.kicked = true
+	}
+	obj.mutex.Unlock()
+	if kick != nil {
+		kick()
+	}
+	return nil
+}
+
+// sessionRemote session remote of connection address, port 없이 host 만 (http 세션의 ClientIP 와 같게)
+func sessionRemote(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// sessionProtocol protocol name of client mode
+func sessionProtocol(mode int) string {
+	switch mode {
+	case WEBRTC:
+		return SessionWebRTC
+	case RTSP:
+		return SessionRTSP
+	}
+	return SessionMSE
+}
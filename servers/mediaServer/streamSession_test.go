@@ -0,0 +1,34 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSessionListWhileSending(t *testing.T) {
+	registry := &SessionRegistryST{list: make(map[string]*SessionST)}
+	registry.Add(&SessionST{ID: "a", StreamID: "s", ChannelID: "0", Protocol: SessionHLS, detached: true})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	// go test -race 에서 Sent 와 List 가 경합하지 않아야 한다
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			registry.Sent("a", 10)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			registry.RTCP("a", SessionRTCPST{NACK: uint64(i)})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		registry.List(SessionFilterST{})
+	}
+	wg.Wait()
+	list := registry.List(SessionFilterST{})
+	if len(list) != 1 || list[0].BytesSent != 10000 || list[0].RTCP == nil || list[0].RTCP.NACK != 999 {
+		t.Errorf("sessions %+v", list)
+	}
+}
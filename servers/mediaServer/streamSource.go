@@ -0,0 +1,169 @@
+package main
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+// Default source signals
+const (
+	SourceSignalCodecUpdate = iota ///< codec data changed, re read CodecData
+	SourceSignalStop               ///< source closed or read failed
+)
+
+// Source ingest source interface (rtsp, rtmp ...)
+// StreamServerRunStream drive any source with the same channel pipeline
+type Source interface {
+	// CodecData current codec list, empty if source still wait codec
+	CodecData() []av.CodecData
+	// SDP raw sdp for rtsp re-serve, nil if source not rtp based
+	SDP() []byte
+	// WaitCodec true if codec not ready, SourceSignalCodecUpdate send when ready
+	WaitCodec() bool
+	// Packets decoded av packet queue
+	Packets() <-chan *av.Packet
+	// RTPPackets raw rtp queue for rtsp proxy, nil if not supported
+	RTPPackets() <-chan *[]byte
+	// Signals source signals queue
+	Signals() <-chan int
+	// Close source
+	Close()
+}
+
+// SourceKeyFrameRequester source that can ask publisher for keyframe (rtcp pli), optional
+type SourceKeyFrameRequester interface {
+	RequestKeyFrame()
+}
+
+// SourceDialer open new source for channel options
+type SourceDialer func(opt *ChannelST) (Source, error)
+
+var (
+	sourceDialersMutex sync.RWMutex
+	sourceDialers      = make(map[string]SourceDialer)
+)
+
+// RegisterSource register source dialer for url scheme
+func RegisterSource(scheme string, dialer SourceDialer) {
+	sourceDialersMutex.Lock()
+	defer sourceDialersMutex.Unlock()
+	sourceDialers[strings.ToLower(scheme)] = dialer
+}
+
+// SourceDial select source by channel url scheme and dial
+func SourceDial(opt *ChannelST) (Source, error) {
+	u, err := url.Parse(opt.URL)
+	if err != nil {
+		return nil, err
+	}
+	sourceDialersMutex.RLock()
+	dialer, ok := sourceDialers[strings.ToLower(u.Scheme)]
+	sourceDialersMutex.RUnlock()
+	if !ok {
+		return nil, ErrorStreamSourceUnsupported
+	}
+	return dialer(opt)
+}
+
+// SourceDemux generic source over av.Demuxer (rtmp, flv, ts ...)
+type SourceDemux struct {
+	demuxer   av.Demuxer
+	closer    io.Closer
+	codecs    []av.CodecData
+	packets   chan *av.Packet
+	signals   chan int
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSourceDemux read demuxer header and start read loop
+func NewSourceDemux(demuxer av.Demuxer, closer io.Closer) (*SourceDemux, error) {
+	codecs, err := demuxer.Streams()
+	if err != nil {
+		closer.Close()
+		return nil, err
+	}
+	source := &SourceDemux{
+		demuxer: demuxer,
+		closer:  closer,
+		codecs:  codecs,
+		packets: make(chan *av.Packet, 1000),
+		signals: make(chan int, 100),
+		done:    make(chan struct{}),
+	}
+	go source.readLoop()
+	return source, nil
+}
+
+// readLoop read packets and fill duration from packet time
+func (source *SourceDemux) readLoop() {
+	defer func() {
+		source.signals <- SourceSignalStop
+	}()
+	preDur := make([]time.Duration, len(source.codecs))
+	for {
+		packet, err := source.demuxer.ReadPacket()
+		if err != nil {
+			return
+		}
+		if int(packet.Idx) < len(preDur) {
+			if preDur[packet.Idx] != 0 {
+				packet.Duration = packet.Time - preDur[packet.Idx]
+			}
+			preDur[packet.Idx] = packet.Time
+		}
+		select {
+		case source.packets <- &packet:
+		case <-source.done:
+			return
+		}
+	}
+}
+
+// CodecData func
+func (source *SourceDemux) CodecData() []av.CodecData {
+	return source.codecs
+}
+
+// SDP func
+func (source *SourceDemux) SDP() []byte {
+	return nil
+}
+
+// WaitCodec func
+func (source *SourceDemux) WaitCodec() bool {
+	return false
+}
+
+// Packets func
+func (source *SourceDemux) Packets() <-chan *av.Packet {
+	return source.packets
+}
+
+// RTPPackets func
+func (source *SourceDemux) RTPPackets() <-chan *[]byte {
+	return nil
+}
+
+// Signals func
+func (source *SourceDemux) Signals() <-chan int {
+	return source.signals
+}
+
+// Close func
+func (source *SourceDemux) Close() {
+	source.closeOnce.Do(func() {
+		close(source.done)
+		source.closer.Close()
+	})
+}
+
+// Done closed after source Close
+func (source *SourceDemux) Done() <-chan struct{} {
+	return source.done
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+	"os/exec"
+	"time"
+
+	"github.com/deepch/vdk/format/ts"
+)
+
+// sourceFFmpegProcess ffmpeg process closer
+type sourceFFmpegProcess struct {
+	cmd *exec.Cmd
+}
+
+// Close kill ffmpeg and wait exit
+func (process *sourceFFmpegProcess) Close() error {
+	if process.cmd.Process != nil {
+		process.cmd.Process.Kill()
+	}
+	return process.cmd.Wait()
+}
+
+// sourceFFmpegRun run ffmpeg input args + output args, MPEG-TS to stdout
+// used for protocols vdk not support natively (srt ...), timeout kill ffmpeg if first codec not arrive
+func sourceFFmpegRun(debug bool, inputArgs []string, outputArgs []string, timeout time.Duration) (*SourceDemux, error) {
+	args := []string{"-hide_banner", "-loglevel", "error"}
+	if debug {
+		args[2] = "info"
+	}
+	args = append(args, inputArgs...)
+	args = append(args, outputArgs...)
+	args = append(args, "-f", "mpegts", "pipe:1")
+	cmd := exec.Command(Storage.ServerFFMPEGBinary("ffmpeg"), args...)
+	cmd.Stderr = log.Writer()
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	process := &sourceFFmpegProcess{cmd: cmd}
+	dialTimeout := time.AfterFunc(timeout, func() {
+		cmd.Process.Kill()
+	})
+	source, err := NewSourceDemux(ts.NewDemuxer(stdout), process)
+	if !dialTimeout.Stop() && err != nil {
+		return nil, ErrorStreamSourceTimeout
+	}
+	if err != nil {
+		return nil, err
+	}
+	return source, nil
+}
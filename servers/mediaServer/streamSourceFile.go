@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/format/mp4"
+	"github.com/deepch/vdk/format/ts"
+)
+
+func init() {
+	RegisterSource("file", SourceFileDial)
+}
+
+// file url options
+// file:///path/clip.mp4?loop=true&start=10s&rate=1.5
+// start 는 초(10, 2.5) 또는 duration(1m30s), rate 는 재생 배속
+
+// sourceFileDemuxer real time paced file demuxer with loop, start offset, rate
+type sourceFileDemuxer struct {
+	path    string
+	loop    bool
+	start   time.Duration
+	rate    float64
+	mutex   sync.Mutex
+	closed  bool
+	file    *os.File
+	demuxer av.Demuxer
+	codecs  []av.CodecData
+	// 재생 상태
+	started   bool
+	wallStart time.Time
+	first     time.Duration // 이번 회차 첫 packet 시간, ts 는 0 이 아닌 원래 pts
+	offset    time.Duration // 이번 회차 파일 시간에서 뺄 값 (첫 회차는 시작 키프레임 시간)
+	base      time.Duration // loop 누적 시간
+	videoIdx  int8
+	last      time.Duration // 마지막 비디오 시간
+	lastDur   time.Duration // 마지막 비디오 프레임 간격
+	passRead  bool
+}
+
+// SourceFileDial open mp4 / ts file source
+func SourceFileDial(opt *ChannelST) (Source, error) {
+	u, err := url.Parse(opt.URL)
+	if err != nil {
+		return nil, err
+	}
+	path := u.Path
+	if u.Host != "" {
+		// file://relative/clip.mp4
+		path = u.Host + u.Path
+	}
+	if runtime.GOOS == "windows" {
+		path = strings.TrimPrefix(path, "/")
+	}
+	query := u.Query()
+	demuxer := &sourceFileDemuxer{
+		path: filepath.FromSlash(path),
+		rate: 1,
+	}
+	demuxer.loop, _ = strconv.ParseBool(query.Get("loop"))
+	if start := query.Get("start"); start != "" {
+		if demuxer.start, err = time.ParseDuration(start); err != nil {
+			seconds, err := strconv.ParseFloat(start, 64)
+			if err != nil {
+				return nil, err
+			}
+			demuxer.start = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	if rate := query.Get("rate"); rate != "" {
+		if demuxer.rate, err = strconv.ParseFloat(rate, 64); err != nil {
+			return nil, err
+		}
+		if demuxer.rate <= 0 {
+			demuxer.rate = 1
+		}
+	}
+	if err = demuxer.open(); err != nil {
+		return nil, err
+	}
+	return NewSourceDemux(demuxer, demuxer)
+}
+
+// open open file and make demuxer by extension
+func (demuxer *sourceFileDemuxer) open() error {
+	demuxer.mutex.Lock()
+	defer demuxer.mutex.Unlock()
+	if demuxer.closed {
+		return ErrorStreamStopSourceSignal
+	}
+	if demuxer.file != nil {
+		demuxer.file.Close()
+	}
+	file, err := os.Open(demuxer.path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(demuxer.path)) {
+	case ".mp4", ".m4v", ".mov":
+		demuxer.demuxer = mp4.NewDemuxer(file)
+	case ".ts":
+		demuxer.demuxer = ts.NewDemuxer(bufio.NewReader(file))
+	default:
+		file.Close()
+		return ErrorStreamSourceUnsupported
+	}
+	demuxer.file = file
+	demuxer.passRead = false
+	return nil
+}
+
+// Streams func
+func (demuxer *sourceFileDemuxer) Streams() ([]av.CodecData, error) {
+	if demuxer.codecs == nil {
+		codecs, err := demuxer.demuxer.Streams()
+		if err != nil {
+			return nil, err
+		}
+		demuxer.codecs = codecs
+	}
+	return demuxer.codecs, nil
+}
+
+// ReadPacket read next packet and sleep until play time
+func (demuxer *sourceFileDemuxer) ReadPacket() (av.Packet, error) {
+	for {
+		packet, err := demuxer.demuxer.ReadPacket()
+		if err != nil {
+			// 끝까지 읽었으면 처음부터 다시, 이어지는 시간으로
+			if demuxer.loop && demuxer.passRead {
+				if err = demuxer.open(); err != nil {
+					return packet, err
+				}
+				demuxer.base = demuxer.last + demuxer.lastDur
+				continue
+			}
+			return packet, err
+		}
+		// 회차 첫 packet 시간 기준으로 이어 붙인다
+		if !demuxer.passRead {
+			demuxer.passRead = true
+			demuxer.first = packet.Time
+			demuxer.offset = packet.Time
+		}
+		// start 는 첫 회차에만, 파일 첫 packet 기준
+		if !demuxer.started && packet.Time-demuxer.first < demuxer.start {
+			continue
+		}
+		// 키프레임부터 시작
+		if !demuxer.started {
+			if !packet.IsKeyFrame {
+				continue
+			}
+			demuxer.started = true
+			demuxer.videoIdx = packet.Idx
+			demuxer.offset = packet.Time
+			demuxer.wallStart = time.Now()
+		}
+		playTime := demuxer.base + packet.Time - demuxer.offset
+		if packet.Idx == demuxer.videoIdx {
+			if playTime > demuxer.last {
+				demuxer.lastDur = playTime - demuxer.last
+			}
+			demuxer.last = playTime
+		}
+		packet.Time = time.Duration(float64(playTime) / demuxer.rate)
+		packet.Duration = time.Duration(float64(packet.Duration) / demuxer.rate)
+		if wait := time.Until(demuxer.wallStart.Add(packet.Time)); wait > 0 {
+			time.Sleep(wait)
+		}
+		return packet, nil
+	}
+}
+
+// Close stop loop and close file
+func (demuxer *sourceFileDemuxer) Close() error {
+	demuxer.mutex.Lock()
+	defer demuxer.mutex.Unlock()
+	demuxer.closed = true
+	if demuxer.file != nil {
+		return demuxer.file.Close()
+	}
+	return nil
+}
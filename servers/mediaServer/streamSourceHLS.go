@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/format/ts"
+)
+
+// hlsPlaylist parsed m3u8 (master or media)
+type hlsPlaylist struct {
+	variants       []hlsVariant
+	targetDuration time.Duration
+	mediaSequence  int
+	segments       []hlsSegmentRef
+	endList        bool
+	fmp4           bool
+}
+
+type hlsVariant struct {
+	bandwidth int
+	uri       string
+}
+
+type hlsSegmentRef struct {
+	sequence      int
+	duration      time.Duration
+	uri           string
+	key           *hlsKey
+	discontinuity bool // 앞 segment 와 timestamp 가 이어지지 않음
+}
+
+type hlsKey struct {
+	method string
+	uri    string
+	iv     []byte
+}
+
+// hlsParseAttributes KEY=VALUE,KEY="VALUE" attribute list
+func hlsParseAttributes(line string) map[string]string {
+	attributes := make(map[string]string)
+	for len(line) > 0 {
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(line[:eq])
+		line = line[eq+1:]
+		var value string
+		if strings.HasPrefix(line, "\"") {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				value, line = line[1:], ""
+			} else {
+				value, line = line[1:end+1], line[end+2:]
+			}
+		} else if comma := strings.IndexByte(line, ','); comma >= 0 {
+			value, line = line[:comma], line[comma:]
+		} else {
+			value, line = line, ""
+		}
+		attributes[key] = value
+		line = strings.TrimPrefix(line, ",")
+	}
+	return attributes
+}
+
+// hlsParsePlaylist parse m3u8, uri resolve by base
+func hlsParsePlaylist(data []byte, base *url.URL) (*hlsPlaylist, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	if !scanner.Scan() || !strings.HasPrefix(strings.TrimSpace(scanner.Text()), "#EXTM3U") {
+		return nil, ErrorHLSPlaylistInvalid
+	}
+	playlist := &hlsPlaylist{}
+	resolve := func(uri string) string {
+		ref, err := url.Parse(uri)
+		if err != nil {
+			return uri
+		}
+		return base.ResolveReference(ref).String()
+	}
+	var key *hlsKey
+	var duration time.Duration
+	var bandwidth int
+	var variant, discontinuity bool
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			variant = true
+			bandwidth, _ = strconv.Atoi(hlsParseAttributes(line[len("#EXT-X-STREAM-INF:"):])["BANDWIDTH"])
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			seconds, _ := strconv.Atoi(line[len("#EXT-X-TARGETDURATION:"):])
+			playlist.targetDuration = time.Duration(seconds) * time.Second
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			playlist.mediaSequence, _ = strconv.Atoi(line[len("#EXT-X-MEDIA-SEQUENCE:"):])
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			attributes := hlsParseAttributes(line[len("#EXT-X-KEY:"):])
+			if attributes["METHOD"] == "" || attributes["METHOD"] == "NONE" {
+				key = nil
+				continue
+			}
+			key = &hlsKey{method: attributes["METHOD"], uri: resolve(attributes["URI"])}
+			if iv := attributes["IV"]; iv != "" {
+				key.iv, _ = hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X"))
+			}
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			playlist.fmp4 = true
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value := line[len("#EXTINF:"):]
+			if comma := strings.IndexByte(value, ','); comma >= 0 {
+				value = value[:comma]
+			}
+			seconds, _ := strconv.ParseFloat(value, 64)
+			duration = time.Duration(seconds * float64(time.Second))
+		case line == "#EXT-X-ENDLIST":
+			playlist.endList = true
+		case line == "#EXT-X-DISCONTINUITY":
+			discontinuity = true
+		case strings.HasPrefix(line, "#"):
+		default:
+			if variant {
+				playlist.variants = append(playlist.variants, hlsVariant{bandwidth: bandwidth, uri: resolve(line)})
+				variant = false
+				continue
+			}
+			playlist.segments = append(playlist.segments, hlsSegmentRef{
+				sequence:      playlist.mediaSequence + len(playlist.segments),
+				duration:      duration,
+				uri:           resolve(line),
+				key:           key,
+				discontinuity: discontinuity,
+			})
+			discontinuity = false
+		}
+	}
+	return playlist, scanner.Err()
+}
+
+// hlsDecryptAES128 decrypt AES-128 segment, iv default media sequence
+func hlsDecryptAES128(data []byte, key []byte, iv []byte, sequence int) ([]byte, error) {
+	if len(iv) != aes.BlockSize {
+		iv = make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], uint64(sequence))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%aes.BlockSize != 0 {
+		return nil, ErrorHLSPlaylistInvalid
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	if n := len(out); n > 0 {
+		// PKCS7 padding
+		if pad := int(out[n-1]); pad > 0 && pad <= aes.BlockSize && pad <= n {
+			out = out[:n-pad]
+		}
+	}
+	return out, nil
+}
+
+// sourceHLSReader follow live playlist and write ts segments to pipe
+type sourceHLSReader struct {
+	client      *http.Client
+	playlistURL *url.URL
+	debug       bool
+	pipeReader  *io.PipeReader
+	pipeWriter  *io.PipeWriter
+	keys        map[string][]byte
+	done        chan struct{}
+	closeOnce   sync.Once
+	// discontinuities pipe 에 쓴 discontinuity segment 수 (atomic), demuxer 가 timeline 을 다시 잡는다
+	discontinuities uint32
+}
+
+// SourceHLSDial pull hls (mpeg-ts segments) live or vod playlist
+func SourceHLSDial(opt *ChannelST) (Source, error) {
+	playlistURL, err := url.Parse(opt.URL)
+	if err != nil {
+		return nil, err
+	}
+	reader := &sourceHLSReader{
+		client:      sourceHTTPClient(opt),
+		playlistURL: playlistURL,
+		debug:       opt.Debug,
+		keys:        make(map[string][]byte),
+		done:        make(chan struct{}),
+	}
+	reader.pipeReader, reader.pipeWriter = io.Pipe()
+	// master playlist 이면 가장 높은 bandwidth 선택
+	playlist, err := reader.playlist()
+	if err != nil {
+		return nil, err
+	}
+	if len(playlist.variants) > 0 {
+		best := playlist.variants[0]
+		for _, variant := range playlist.variants {
+			if variant.bandwidth > best.bandwidth {
+				best = variant
+			}
+		}
+		if reader.playlistURL, err = url.Parse(best.uri); err != nil {
+			return nil, err
+		}
+		if playlist, err = reader.playlist(); err != nil {
+			return nil, err
+		}
+	}
+	if playlist.fmp4 {
+		return nil, ErrorHLSFMP4Unsupported
+	}
+	go reader.followLoop(playlist)
+	dialTimeout := time.AfterFunc(15*time.Second, func() {
+		reader.Close()
+	})
+	source, err := NewSourceDemux(&sourcePacedDemuxer{Demuxer: ts.NewDemuxer(reader.pipeReader), reader: reader}, reader)
+	if !dialTimeout.Stop() && err != nil {
+		return nil, ErrorStreamSourceTimeout
+	}
+	if err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+// get http get body
+func (reader *sourceHLSReader) get(uri string) ([]byte, error) {
+	res, err := reader.client.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("hls status %d: %s", res.StatusCode, uri)
+	}
+	return io.ReadAll(res.Body)
+}
+
+// playlist fetch and parse current playlist
+func (reader *sourceHLSReader) playlist() (*hlsPlaylist, error) {
+	data, err := reader.get(reader.playlistURL.String())
+	if err != nil {
+		return nil, err
+	}
+	return hlsParsePlaylist(data, reader.playlistURL)
+}
+
+// segment download and decrypt segment
+func (reader *sourceHLSReader) segment(segment hlsSegmentRef) ([]byte, error) {
+	data, err := reader.get(segment.uri)
+	if err != nil || segment.key == nil {
+		return data, err
+	}
+	if segment.key.method != "AES-128" {
+		return nil, ErrorStreamSourceUnsupported
+	}
+	key, ok := reader.keys[segment.key.uri]
+	if !ok {
+		if key, err = reader.get(segment.key.uri); err != nil {
+			return nil, err
+		}
+		reader.keys[segment.key.uri] = key
+	}
+	return hlsDecryptAES128(data, key, segment.key.iv, segment.sequence)
+}
+
+// followLoop write new segments in order, reload playlist every half target duration
+func (reader *sourceHLSReader) followLoop(playlist *hlsPlaylist) {
+	var err error
+	defer func() {
+		reader.pipeWriter.CloseWithError(err)
+	}()
+	lastSequence := -1
+	// live 는 끝에서 3 segment 전부터 시작
+	if !playlist.endList && len(playlist.segments) > 3 {
+		lastSequence = playlist.segments[len(playlist.segments)-4].sequence
+	}
+	var failures int
+	for {
+		for _, segment := range playlist.segments {
+			if segment.sequence <= lastSequence {
+				continue
+			}
+			var data []byte
+			data, err = reader.segment(segment)
+			if err != nil {
+				if reader.debug {
+					log.Printf("[WARN] [source] [SourceHLS] segment failed: url=%s error=%v", segment.uri, err)
+				}
+				break
+			}
+			if segment.discontinuity {
+				atomic.AddUint32(&reader.discontinuities, 1)
+			}
+			if _, err = reader.pipeWriter.Write(data); err != nil {
+				return
+			}
+			lastSequence = segment.sequence
+		}
+		if playlist.endList {
+			err = io.EOF
+			return
+		}
+		wait := playlist.targetDuration / 2
+		if wait <= 0 {
+			wait = time.Second
+		}
+		select {
+		case <-reader.done:
+			return
+		case <-time.After(wait):
+		}
+		next, errPlaylist := reader.playlist()
+		if errPlaylist != nil {
+			failures++
+			if failures >= 3 {
+				err = errPlaylist
+				return
+			}
+			continue
+		}
+		failures = 0
+		// 서버가 재시작해서 sequence 가 되돌아간 경우
+		if len(next.segments) > 0 && next.segments[len(next.segments)-1].sequence < lastSequence {
+			lastSequence = next.segments[0].sequence - 1
+		}
+		playlist = next
+	}
+}
+
+// Close func
+func (reader *sourceHLSReader) Close() error {
+	reader.closeOnce.Do(func() {
+		close(reader.done)
+		reader.pipeReader.Close()
+	})
+	return nil
+}
+
+// sourceHLSTimeJump 이 이상 시간이 되돌아가면 (PTS 33 bit wrap, 서버 재시작) 새 timeline,
+// discontinuity 뒤에는 앞으로 튀는 것도. audio / video interleave 차이보다 크게
+const sourceHLSTimeJump = 2 * time.Second
+
+// sourcePacedDemuxer pace bursty segment packets to wall clock, time from zero
+// timeline 이 바뀌면 마지막 시간에 이어 붙여서 시간이 계속 증가
+type sourcePacedDemuxer struct {
+	av.Demuxer
+	reader        *sourceHLSReader // discontinuity 알림
+	started       bool
+	base          time.Duration // 현재 timeline 첫 packet 의 원래 시간
+	offset        time.Duration // 현재 timeline 첫 packet 의 출력 시간
+	last          time.Duration // 가장 큰 출력 시간
+	lastDuration  time.Duration
+	discontinuity uint32 // 처리한 discontinuity 수
+	wallStart     time.Time
+}
+
+// ReadPacket func
+func (demuxer *sourcePacedDemuxer) ReadPacket() (av.Packet, error) {
+	packet, err := demuxer.Demuxer.ReadPacket()
+	if err != nil {
+		return packet, err
+	}
+	var discontinuities uint32
+	if demuxer.reader != nil {
+		discontinuities = atomic.LoadUint32(&demuxer.reader.discontinuities)
+	}
+	if !demuxer.started {
+		demuxer.started = true
+		demuxer.base = packet.Time
+		demuxer.discontinuity = discontinuities
+		demuxer.wallStart = time.Now()
+	}
+	at := packet.Time - demuxer.base + demuxer.offset
+	// discontinuity segment 의 packet 은 이전 segment 남은 packet 뒤에 오므로 시간이 튈 때 새 timeline
+	jump := at - demuxer.last
+	if jump < -sourceHLSTimeJump || discontinuities != demuxer.discontinuity && jump > sourceHLSTimeJump {
+		step := demuxer.lastDuration
+		if step <= 0 {
+			step = time.Millisecond
+		}
+		demuxer.base = packet.Time
+		demuxer.offset = demuxer.last + step
+		demuxer.discontinuity = discontinuities
+		at = demuxer.offset
+	}
+	if at < 0 {
+		at = 0
+	}
+	if at > demuxer.last {
+		demuxer.last = at
+	}
+	demuxer.lastDuration = packet.Duration
+	packet.Time = at
+	if wait := time.Until(demuxer.wallStart.Add(packet.Time)); wait > 0 {
+		time.Sleep(wait)
+	}
+	return packet, nil
+}
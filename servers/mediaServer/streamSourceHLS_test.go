@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+// hlsTestDemuxer demuxer of fixed packets, before 는 packet 을 읽기 전 호출
+type hlsTestDemuxer struct {
+	packets []av.Packet
+	before  func(i int)
+	read    int
+}
+
+func (demuxer *hlsTestDemuxer) Streams() ([]av.CodecData, error) {
+	return nil, nil
+}
+
+func (demuxer *hlsTestDemuxer) ReadPacket() (av.Packet, error) {
+	if demuxer.read >= len(demuxer.packets) {
+		return av.Packet{}, io.EOF
+	}
+	if demuxer.before != nil {
+		demuxer.before(demuxer.read)
+	}
+	demuxer.read++
+	return demuxer.packets[demuxer.read-1], nil
+}
+
+// hlsTestTimes packet times of paced demuxer
+func hlsTestTimes(t *testing.T, demuxer *sourcePacedDemuxer) []time.Duration {
+	t.Helper()
+	var times []time.Duration
+	for {
+		packet, err := demuxer.ReadPacket()
+		if err != nil {
+			return times
+		}
+		times = append(times, packet.Time)
+	}
+}
+
+func TestHLSParsePlaylistDiscontinuity(t *testing.T) {
+	base, _ := url.Parse("http://127.0.0.1/live/index.m3u8")
+	playlist, err := hlsParsePlaylist([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:10\n#EXT-X-DISCONTINUITY-SEQUENCE:1\n"+
+		"#EXTINF:2.0,\na.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:2.0,\nb.ts\n#EXTINF:2.0,\nc.ts\n"), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(playlist.segments) != 3 {
+		t.Fatalf("segments %+v", playlist.segments)
+	}
+	for i, want := range []bool{false, true, false} {
+		if playlist.segments[i].discontinuity != want {
+			t.Errorf("segment %d discontinuity %v, want %v", i, playlist.segments[i].discontinuity, want)
+		}
+	}
+}
+
+func TestSourcePacedDemuxerPTSWrap(t *testing.T) {
+	// 33 bit 90kHz PTS 가 0 으로 돌아감
+	wrap := time.Duration(1<<33) * time.Second / 90000
+	step := 20 * time.Millisecond
+	demuxer := &sourcePacedDemuxer{Demuxer: &hlsTestDemuxer{packets: []av.Packet{
+		{Time: wrap - 2*step, Duration: step},
+		{Time: wrap - step, Duration: step},
+		{Time: 0, Duration: step},
+		{Time: step, Duration: step},
+	}}}
+	times := hlsTestTimes(t, demuxer)
+	for i, got := range times {
+		if want := time.Duration(i) * step; got != want {
+			t.Errorf("packet %d time %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestSourcePacedDemuxerDiscontinuity(t *testing.T) {
+	reader := &sourceHLSReader{}
+	step := 20 * time.Millisecond
+	demuxer := &sourcePacedDemuxer{reader: reader, Demuxer: &hlsTestDemuxer{
+		packets: []av.Packet{
+			{Time: 10 * time.Second, Duration: step},
+			{Time: 10*time.Second + step, Duration: step},
+			// 이전 segment 의 남은 packet
+			{Time: 10*time.Second + 2*step, Duration: step},
+			// 광고 등으로 timeline 이 앞으로 튐
+			{Time: 500 * time.Second, Duration: step},
+			{Time: 500*time.Second + step, Duration: step},
+		},
+		before: func(i int) {
+			if i == 2 {
+				atomic.AddUint32(&reader.discontinuities, 1)
+			}
+		},
+	}}
+	times := hlsTestTimes(t, demuxer)
+	for i, got := range times {
+		if want := time.Duration(i) * step; got != want {
+			t.Errorf("packet %d time %s, want %s", i, got, want)
+		}
+	}
+	// discontinuity 없이 앞으로 튀는 것은 (빠진 segment) 그대로
+	demuxer = &sourcePacedDemuxer{reader: &sourceHLSReader{}, Demuxer: &hlsTestDemuxer{packets: []av.Packet{
+		{Time: 0, Duration: step},
+		{Time: 100 * time.Millisecond, Duration: step},
+	}}}
+	if times = hlsTestTimes(t, demuxer); times[1] != 100*time.Millisecond {
+		t.Errorf("gap without discontinuity %s, want 100ms", times[1])
+	}
+}
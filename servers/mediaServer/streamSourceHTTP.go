@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/deepch/vdk/format/flv"
+)
+
+func init() {
+	RegisterSource("http", SourceHTTPDial)
+	RegisterSource("https", SourceHTTPDial)
+}
+
+// SourceHTTPDial select http source by path extension (.flv, .m3u8)
+// 확장자가 없으면 ?format=flv|hls 로 지정
+func SourceHTTPDial(opt *ChannelST) (Source, error) {
+	u, err := url.Parse(opt.URL)
+	if err != nil {
+		return nil, err
+	}
+	format := strings.ToLower(u.Query().Get("format"))
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(path.Ext(u.Path)), ".")
+	}
+	switch format {
+	case "flv":
+		return SourceHTTPFLVDial(opt)
+	case "m3u8", "hls":
+		return SourceHLSDial(opt)
+	}
+	return nil, ErrorStreamSourceUnsupported
+}
+
+// sourceHTTPClient http client for stream pull, no total timeout (live body)
+func sourceHTTPClient(opt *ChannelST) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: 3 * time.Second}).DialContext,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: 5 * time.Second,
+			TLSClientConfig:       &tls.Config{InsecureSkipVerify: opt.InsecureSkipVerify},
+		},
+	}
+}
+
+// SourceHTTPFLVDial pull http-flv live stream
+func SourceHTTPFLVDial(opt *ChannelST) (Source, error) {
+	res, err := sourceHTTPClient(opt).Get(opt.URL)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("http-flv status %d", res.StatusCode)
+	}
+	// codec 헤더가 안오면 끊는다
+	dialTimeout := time.AfterFunc(10*time.Second, func() {
+		res.Body.Close()
+	})
+	source, err := NewSourceDemux(flv.NewDemuxer(res.Body), res.Body)
+	if !dialTimeout.Stop() && err != nil {
+		return nil, ErrorStreamSourceTimeout
+	}
+	if err != nil {
+		return nil, err
+	}
+	return source, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// push channel 은 url 이 없고 publisher (rtmp publish ...) 가 source 를 넘겨준다
+var (
+	pushPublishersMutex sync.Mutex
+	pushPublishers      = make(map[string]chan Source)
+)
+
+// pushPublisher get publish queue of channel
+func pushPublisher(streamID string, channelID string) chan Source {
+	pushPublishersMutex.Lock()
+	defer pushPublishersMutex.Unlock()
+	key := streamID + "/" + channelID
+	queue, ok := pushPublishers[key]
+	if !ok {
+		queue = make(chan Source)
+		pushPublishers[key] = queue
+	}
+	return queue
+}
+
+// SourcePushPublish hand publisher source to push channel loop
+// start channel if not running, error if other publisher still active
+func SourcePushPublish(streamID string, channelID string, source Source) error {
+	opt, err := Storage.StreamChannelControl(streamID, channelID)
+	if err != nil {
+		return err
+	}
+	if !opt.Push {
+		return ErrorStreamChannelNotPush
+	}
+	Storage.StreamChannelRun(streamID, channelID)
+	select {
+	case pushPublisher(streamID, channelID) <- source:
+		return nil
+	case <-time.After(10 * time.Second):
+		return ErrorStreamPushBusy
+	}
+}
+
+// SourcePushWait wait publisher for push channel, core signals stop wait
+func SourcePushWait(streamID string, channelID string, signals <-chan int) (Source, int, error) {
+	checkStream := time.NewTicker(20 * time.Second)
+	defer checkStream.Stop()
+	for {
+		select {
+		case source := <-pushPublisher(streamID, channelID):
+			return source, 0, nil
+		case signal := <-signals:
+			switch signal {
+			case SignalStreamStop:
+				return nil, 2, ErrorStreamStopCoreSignal
+			case SignalStreamRestart:
+				return nil, 0, ErrorStreamRestart
+			}
+		case <-checkStream.C:
+			if _, err := Storage.StreamChannelControl(streamID, channelID); err != nil {
+				return nil, 2, err
+			}
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"time"
+
+	"github.com/deepch/vdk/format/rtmp"
+)
+
+func init() {
+	RegisterSource("rtmp", SourceRTMPDial)
+}
+
+// SourceRTMPDial pull rtmp stream
+func SourceRTMPDial(opt *ChannelST) (Source, error) {
+	conn, err := rtmp.DialTimeout(opt.URL, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return NewSourceDemux(conn, conn)
+}
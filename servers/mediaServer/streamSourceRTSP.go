@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/format/rtspv2"
+)
+
+func init() {
+	RegisterSource("rtsp", SourceRTSPDial)
+	RegisterSource("rtsps", SourceRTSPDial)
+}
+
+// SourceRTSP rtsp pull source
+type SourceRTSP struct {
+	client    *rtspv2.RTSPClient
+	signals   chan int
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// SourceRTSPDial connect to rtsp camera
+func SourceRTSPDial(opt *ChannelST) (Source, error) {
+	client, err := rtspv2.Dial(rtspv2.RTSPClientOptions{
+		URL:                opt.URL,
+		InsecureSkipVerify: opt.InsecureSkipVerify,
+		DisableAudio:       !opt.Audio,
+		DialTimeout:        3 * time.Second,
+		ReadWriteTimeout:   15 * time.Second, // RTP 패킷 받아야하는 타임. 기존 5초
+		Debug:              opt.Debug,
+		OutgoingProxy:      true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	source := &SourceRTSP{
+		client:  client,
+		signals: make(chan int, 100),
+		done:    make(chan struct{}),
+	}
+	go source.signalLoop()
+	return source, nil
+}
+
+// signalLoop convert rtspv2 signals to source signals
+func (source *SourceRTSP) signalLoop() {
+	for {
+		select {
+		case <-source.done:
+			return
+		case signal := <-source.client.Signals:
+			switch signal {
+			case rtspv2.SignalCodecUpdate:
+				source.signals <- SourceSignalCodecUpdate
+			case rtspv2.SignalStreamRTPStop:
+				source.signals <- SourceSignalStop
+				return
+			}
+		}
+	}
+}
+
+// CodecData func
+func (source *SourceRTSP) CodecData() []av.CodecData {
+	return source.client.CodecData
+}
+
+// SDP func
+func (source *SourceRTSP) SDP() []byte {
+	return source.client.SDPRaw
+}
+
+// WaitCodec func
+func (source *SourceRTSP) WaitCodec() bool {
+	return source.client.WaitCodec
+}
+
+// Packets func
+func (source *SourceRTSP) Packets() <-chan *av.Packet {
+	return source.client.OutgoingPacketQueue
+}
+
+// RTPPackets func
+func (source *SourceRTSP) RTPPackets() <-chan *[]byte {
+	return source.client.OutgoingProxyQueue
+}
+
+// Signals func
+func (source *SourceRTSP) Signals() <-chan int {
+	return source.signals
+}
+
+// Close func
+func (source *SourceRTSP) Close() {
+	source.closeOnce.Do(func() {
+		close(source.done)
+		source.client.Close()
+	})
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec"
+	"github.com/deepch/vdk/codec/aacparser"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/codec/h265parser"
+	"github.com/deepch/vdk/format/rtsp/sdp"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+)
+
+// SourceRTSPRecord rtsp ANNOUNCE / RECORD publish source
+// publisher rtp 를 av packet 으로 바꾸고, rtsp 재송출은 core packetizer 가 한다
+type SourceRTSPRecord struct {
+	medias    []sdp.Media
+	tracks    map[byte]*rtspRecordTrack // interleaved rtp channel
+	mutex     sync.RWMutex
+	codecs    []av.CodecData
+	waitCodec bool
+	vps       []byte
+	sps       []byte
+	pps       []byte
+	packets   chan *av.Packet
+	signals   chan int
+	done      chan struct{}
+	closeOnce sync.Once
+	conn      io.Writer // publisher 연결, keyframe 요청 rtcp 전송
+}
+
+// rtspRecordTrack one SETUP media of publisher
+type rtspRecordTrack struct {
+	media     sdp.Media
+	idx       int8
+	clockRate time.Duration
+	h264      *codecs.H264Packet
+	started   bool
+	lastTS    uint32
+	pts       time.Duration
+	preFrame  time.Duration
+	frame     []byte
+	ssrc      uint32 // atomic
+}
+
+// NewSourceRTSPRecord source from ANNOUNCE sdp, video track 필수 (h264 / h265)
+// 지원하지 않는 audio 는 무시
+func NewSourceRTSPRecord(raw []byte) (*SourceRTSPRecord, error) {
+	_, medias := sdp.Parse(string(raw))
+	source := &SourceRTSPRecord{
+		medias:  medias,
+		tracks:  make(map[byte]*rtspRecordTrack),
+		packets: make(chan *av.Packet, 1000),
+		signals: make(chan int, 100),
+		done:    make(chan struct{}),
+	}
+	var video bool
+	for _, media := range medias {
+		switch media.Type {
+		case av.H264:
+			if video {
+				continue
+			}
+			video = true
+			if len(media.SpropParameterSets) > 1 {
+				source.sps, source.pps = media.SpropParameterSets[0], media.SpropParameterSets[1]
+			}
+		case av.H265:
+			if video {
+				continue
+			}
+			video = true
+			source.vps, source.sps, source.pps = media.SpropVPS, media.SpropSPS, media.SpropPPS
+		}
+	}
+	if !video {
+		return nil, ErrorRTSPRecordCodec
+	}
+	source.waitCodec = !source.codecUpdate()
+	return source, nil
+}
+
+// setup bind interleaved channel to sdp media of SETUP url, false if no media
+func (source *SourceRTSPRecord) setup(url string, channel byte) bool {
+	for i, media := range source.medias {
+		if media.Control == "" || !strings.HasSuffix(url, media.Control) {
+			continue
+		}
+		clockRate := media.TimeScale
+		if clockRate == 0 {
+			clockRate = 90000
+			if media.AVType == "audio" {
+				clockRate = 8000
+			}
+		}
+		track := &rtspRecordTrack{media: source.medias[i], clockRate: time.Duration(clockRate)}
+		if media.Type == av.H264 {
+			track.h264 = &codecs.H264Packet{IsAVC: true}
+		}
+		source.mutex.Lock()
+		track.idx = rtspRecordTrackIdx(source.codecs, media)
+		source.tracks[channel] = track
+		source.mutex.Unlock()
+		return true
+	}
+	return false
+}
+
+// codecUpdate make codec list from parameter sets and sdp audio, true if ready
+func (source *SourceRTSPRecord) codecUpdate() bool {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	var codecData []av.CodecData
+	var video bool
+	for _, media := range source.medias {
+		switch {
+		case media.AVType == "video" && !video && media.Type == av.H264:
+			if len(source.sps) == 0 || len(source.pps) == 0 {
+				return false
+			}
+			val, err := h264parser.NewCodecDataFromSPSAndPPS(source.sps, source.pps)
+			if err != nil {
+				return false
+			}
+			video = true
+			codecData = append(codecData, val)
+		case media.AVType == "video" && !video && media.Type == av.H265:
+			if len(source.vps) == 0 || len(source.sps) == 0 || len(source.pps) == 0 {
+				return false
+			}
+			val, err := h265parser.NewCodecDataFromVPSAndSPSAndPPS(source.vps, source.sps, source.pps)
+			if err != nil {
+				return false
+			}
+			video = true
+			codecData = append(codecData, val)
+		case media.AVType == "audio":
+			if val := rtspRecordAudioCodec(media); val != nil {
+				codecData = append(codecData, val)
+			}
+		}
+	}
+	if !source.waitCodec && !rtspRecordCodecsChanged(source.codecs, codecData) {
+		return true
+	}
+	source.codecs = codecData
+	source.waitCodec = false
+	for _, track := range source.tracks {
+		track.idx = rtspRecordTrackIdx(codecData, track.media)
+	}
+	select {
+	case source.signals <- SourceSignalCodecUpdate:
+	default:
+	}
+	return true
+}
+
+// rtspRecordTrackIdx packet idx of media (codec 순서), -1 if not supported
+func rtspRecordTrackIdx(codecData []av.CodecData, media sdp.Media) int8 {
+	for i, val := range codecData {
+		if val.Type() == media.Type {
+			return int8(i)
+		}
+	}
+	return -1
+}
+
+// rtspRecordCodecsChanged true if codec list or video parameter sets changed
+func rtspRecordCodecsChanged(current []av.CodecData, codecData []av.CodecData) bool {
+	if len(current) != len(codecData) {
+		return true
+	}
+	for i := range codecData {
+		switch val := codecData[i].(type) {
+		case h264parser.CodecData:
+			old, ok := current[i].(h264parser.CodecData)
+			if !ok || string(old.SPS()) != string(val.SPS()) || string(old.PPS()) != string(val.PPS()) {
+				return true
+			}
+		case h265parser.CodecData:
+			old, ok := current[i].(h265parser.CodecData)
+			if !ok || string(old.VPS()) != string(val.VPS()) || string(old.SPS()) != string(val.SPS()) || string(old.PPS()) != string(val.PPS()) {
+				return true
+			}
+		default:
+			if current[i].Type() != val.Type() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rtspRecordAudioCodec codec data of sdp audio media, nil if not supported
+func rtspRecordAudioCodec(media sdp.Media) av.CodecData {
+	switch media.Type {
+	case av.AAC:
+		val, err := aacparser.NewCodecDataFromMPEG4AudioConfigBytes(media.Config)
+		if err != nil {
+			return nil
+		}
+		return val
+	case av.OPUS:
+		layout := av.CH_MONO
+		if media.ChannelCount == 2 {
+			layout = av.CH_STEREO
+		}
+		return codec.NewOpusCodecData(48000, layout)
+	case av.PCM_MULAW:
+		return codec.NewPCMMulawCodecData()
+	case av.PCM_ALAW:
+		return codec.NewPCMAlawCodecData()
+	}
+	return nil
+}
+
+// writeRTP interleaved frame from publisher, rtcp 와 SETUP 안된 channel 은 무시
+func (source *SourceRTSPRecord) writeRTP(channel byte, data []byte) {
+	source.mutex.RLock()
+	track, ok := source.tracks[channel]
+	source.mutex.RUnlock()
+	if !ok {
+		return
+	}
+	var packetRTP rtp.Packet
+	if err := packetRTP.Unmarshal(data); err != nil {
+		return
+	}
+	if !track.started {
+		track.started = true
+		track.lastTS = packetRTP.Timestamp
+		atomic.StoreUint32(&track.ssrc, packetRTP.SSRC)
+	}
+	track.pts += time.Duration(int32(packetRTP.Timestamp-track.lastTS)) * time.Second / track.clockRate
+	track.lastTS = packetRTP.Timestamp
+	switch track.media.Type {
+	case av.H264:
+		nalus, err := track.h264.Unmarshal(packetRTP.Payload)
+		if err != nil {
+			track.frame = nil
+			return
+		}
+		track.frame = append(track.frame, nalus...)
+	case av.H265:
+		nalus := rtspRecordH265(packetRTP.Payload, &track.frame)
+		track.frame = append(track.frame, nalus...)
+	case av.AAC:
+		source.writeAAC(track, packetRTP.Payload)
+		return
+	default:
+		if len(packetRTP.Payload) == 0 || source.WaitCodec() || track.idx < 0 {
+			return
+		}
+		// g711 은 byte 당 1 sample, opus 는 20ms frame
+		duration := time.Duration(len(packetRTP.Payload)) * time.Second / track.clockRate
+		if track.media.Type == av.OPUS {
+			duration = 20 * time.Millisecond
+		}
+		source.writePacket(&av.Packet{Idx: track.idx, Data: append([]byte(nil), packetRTP.Payload...), Time: track.pts, Duration: duration})
+		return
+	}
+	if !packetRTP.Marker || len(track.frame) == 0 {
+		return
+	}
+	source.writeVideo(track, track.frame)
+	track.frame = nil
+}
+
+// rtspRecordH265 avcc nalus of h265 rtp payload (single, AP, FU), fu 는 frame 에 이어 붙인다
+func rtspRecordH265(payload []byte, frame *[]byte) []byte {
+	if len(payload) < 3 {
+		return nil
+	}
+	switch (payload[0] >> 1) & 0x3f {
+	case 48:
+		var out []byte
+		payload = payload[2:]
+		for len(payload) > 2 {
+			size := int(binary.BigEndian.Uint16(payload))
+			if size == 0 || size > len(payload)-2 {
+				break
+			}
+			out = append(out, rtspRecordSize(size)...)
+			out = append(out, payload[2:2+size]...)
+			payload = payload[2+size:]
+		}
+		return out
+	case 49:
+		fu := payload[2]
+		if fu&0x80 != 0 {
+			// 시작 fragment, nal header 복원
+			nalu := append([]byte{(payload[0] & 0x81) | (fu&0x3f)<<1, payload[1]}, payload[3:]...)
+			return append(rtspRecordSize(len(nalu)), nalu...)
+		}
+		// 이어지는 fragment 는 마지막 nalu 에 붙이고 길이 갱신, 시작을 놓쳤으면 버림
+		rtspRecordH265Append(frame, payload[3:])
+		return nil
+	default:
+		return append(rtspRecordSize(len(payload)), payload...)
+	}
+}
+
+// rtspRecordSize avcc 4 byte length prefix
+func rtspRecordSize(size int) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, uint32(size))
+	return buf
+}
+
+// rtspRecordH265Append append fu fragment to last nalu of frame
+func rtspRecordH265Append(frame *[]byte, data []byte) {
+	offset := 0
+	for offset+4 <= len(*frame) {
+		size := int(binary.BigEndian.Uint32((*frame)[offset:]))
+		if offset+4+size >= len(*frame) {
+			*frame = append(*frame, data...)
+			binary.BigEndian.PutUint32((*frame)[offset:], uint32(len(*frame)-offset-4))
+			return
+		}
+		offset += 4 + size
+	}
+}
+
+// writeAAC split mpeg4-generic AU (rfc 3640), AU 당 1024 sample
+func (source *SourceRTSPRecord) writeAAC(track *rtspRecordTrack, payload []byte) {
+	if len(payload) < 2 || source.WaitCodec() || track.idx < 0 {
+		return
+	}
+	sizeLength, indexLength := track.media.SizeLength, track.media.IndexLength
+	if sizeLength == 0 {
+		sizeLength, indexLength = 13, 3
+	}
+	headerBits := int(binary.BigEndian.Uint16(payload))
+	headerBytes := (headerBits + 7) / 8
+	if len(payload) < 2+headerBytes {
+		return
+	}
+	headers, data := payload[2:2+headerBytes], payload[2+headerBytes:]
+	frameDuration := 1024 * time.Second / track.clockRate
+	for i, bit := 0, 0; bit+sizeLength <= headerBits; i, bit = i+1, bit+sizeLength+indexLength {
+		size := 0
+		for j := 0; j < sizeLength; j++ {
+			size = size<<1 | int(headers[(bit+j)/8]>>(7-uint((bit+j)%8))&1)
+		}
+		if size == 0 || size > len(data) {
+			return
+		}
+		source.writePacket(&av.Packet{Idx: track.idx, Data: append([]byte(nil), data[:size]...), Time: track.pts + time.Duration(i)*frameDuration, Duration: frameDuration})
+		data = data[size:]
+	}
+}
+
+// writeVideo split avcc access unit, keep parameter sets as codec data
+func (source *SourceRTSPRecord) writeVideo(track *rtspRecordTrack, frame []byte) {
+	hevc := track.media.Type == av.H265
+	var keyFrame, parameters bool
+	var out []byte
+	for len(frame) > 4 {
+		size := int(binary.BigEndian.Uint32(frame))
+		if size <= 0 || size > len(frame)-4 {
+			break
+		}
+		nalu := frame[4 : 4+size]
+		if hevc {
+			switch naluType := (nalu[0] >> 1) & 0x3f; {
+			case naluType == h265parser.NAL_UNIT_VPS:
+				source.vps, parameters = append([]byte(nil), nalu...), true
+			case naluType == h265parser.NAL_UNIT_SPS:
+				source.sps, parameters = append([]byte(nil), nalu...), true
+			case naluType == h265parser.NAL_UNIT_PPS:
+				source.pps, parameters = append([]byte(nil), nalu...), true
+			case naluType == h265parser.NAL_UNIT_ACCESS_UNIT_DELIMITER:
+			default:
+				if naluType >= 16 && naluType <= 21 {
+					keyFrame = true
+				}
+				out = append(out, frame[:4+size]...)
+			}
+		} else {
+			switch nalu[0] & 0x1f {
+			case h264parser.NALU_SPS:
+				source.sps, parameters = append([]byte(nil), nalu...), true
+			case h264parser.NALU_PPS:
+				source.pps, parameters = append([]byte(nil), nalu...), true
+			case h264parser.NALU_AUD:
+			default:
+				if nalu[0]&0x1f == 5 {
+					keyFrame = true
+				}
+				out = append(out, frame[:4+size]...)
+			}
+		}
+		frame = frame[4+size:]
+	}
+	if parameters {
+		source.codecUpdate()
+	}
+	if len(out) == 0 || source.WaitCodec() || track.idx < 0 {
+		return
+	}
+	duration := time.Duration(0)
+	if track.preFrame != 0 {
+		duration = track.pts - track.preFrame
+	}
+	track.preFrame = track.pts
+	source.writePacket(&av.Packet{Idx: track.idx, Data: out, Time: track.pts, Duration: duration, IsKeyFrame: keyFrame})
+}
+
+// writePacket queue packet, drop if loop closed
+func (source *SourceRTSPRecord) writePacket(packet *av.Packet) {
+	select {
+	case source.packets <- packet:
+	case <-source.done:
+	}
+}
+
+// stop signal core loop, core call Close
+func (source *SourceRTSPRecord) stop() {
+	select {
+	case source.signals <- SourceSignalStop:
+	default:
+	}
+}
+
+// RequestKeyFrame send pli on rtcp channel (interleaved + 1) of video track
+func (source *SourceRTSPRecord) RequestKeyFrame() {
+	source.mutex.RLock()
+	defer source.mutex.RUnlock()
+	if source.conn == nil {
+		return
+	}
+	for channel, track := range source.tracks {
+		ssrc := atomic.LoadUint32(&track.ssrc)
+		if track.media.AVType != "video" || ssrc == 0 {
+			continue
+		}
+		data, err := (&rtcp.PictureLossIndication{MediaSSRC: ssrc}).Marshal()
+		if err != nil {
+			return
+		}
+		source.conn.Write(append([]byte{0x24, channel + 1, byte(len(data) >> 8), byte(len(data))}, data...))
+		return
+	}
+}
+
+// CodecData func
+func (source *SourceRTSPRecord) CodecData() []av.CodecData {
+	source.mutex.RLock()
+	defer source.mutex.RUnlock()
+	return source.codecs
+}
+
+// SDP func
+func (source *SourceRTSPRecord) SDP() []byte {
+	return nil
+}
+
+// WaitCodec func
+func (source *SourceRTSPRecord) WaitCodec() bool {
+	source.mutex.RLock()
+	defer source.mutex.RUnlock()
+	return source.waitCodec
+}
+
+// Packets func
+func (source *SourceRTSPRecord) Packets() <-chan *av.Packet {
+	return source.packets
+}
+
+// RTPPackets func
+func (source *SourceRTSPRecord) RTPPackets() <-chan *[]byte {
+	return nil
+}
+
+// Signals func
+func (source *SourceRTSPRecord) Signals() <-chan int {
+	return source.signals
+}
+
+// Close func
+func (source *SourceRTSPRecord) Close() {
+	source.closeOnce.Do(func() {
+		close(source.done)
+	})
+}
+
+// Done closed after source Close
+func (source *SourceRTSPRecord) Done() <-chan struct{} {
+	return source.done
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+)
+
+func init() {
+	RegisterSource("srt", SourceSRTDial)
+}
+
+// SRT url options
+// srt://host:port?mode=caller|listener&latency=<ms>&passphrase=<key>&pbkeylen=16|24|32&streamid=<id>
+const (
+	srtModeCaller   = "caller"
+	srtModeListener = "listener"
+)
+
+// SourceSRTDial connect (caller) or wait encoder push (listener) MPEG-TS over srt
+func SourceSRTDial(opt *ChannelST) (Source, error) {
+	u, err := url.Parse(opt.URL)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	out := url.Values{}
+	mode := query.Get("mode")
+	if mode == "" {
+		mode = srtModeCaller
+	}
+	if mode != srtModeCaller && mode != srtModeListener {
+		return nil, ErrorStreamSourceUnsupported
+	}
+	out.Set("mode", mode)
+	// latency ms, ffmpeg 는 us 단위
+	if latency := query.Get("latency"); latency != "" {
+		ms, err := strconv.Atoi(latency)
+		if err != nil {
+			return nil, err
+		}
+		out.Set("latency", strconv.Itoa(ms*1000))
+	}
+	if passphrase := query.Get("passphrase"); passphrase != "" {
+		out.Set("passphrase", passphrase)
+		if pbkeylen := query.Get("pbkeylen"); pbkeylen != "" {
+			out.Set("pbkeylen", pbkeylen)
+		}
+	}
+	if streamid := query.Get("streamid"); streamid != "" {
+		out.Set("streamid", streamid)
+	}
+	// listener 는 인코더 접속까지 대기
+	timeout := 5 * time.Second
+	if mode == srtModeListener {
+		timeout = 60 * time.Second
+	} else {
+		out.Set("connect_timeout", "3000")
+	}
+	host := u.Host
+	if u.Hostname() == "" {
+		host = "0.0.0.0:" + u.Port()
+	}
+	input := url.URL{Scheme: "srt", Host: host, RawQuery: out.Encode()}
+	outputArgs := []string{"-map", "0:v:0"}
+	if opt.Audio {
+		outputArgs = append(outputArgs, "-map", "0:a:0?")
+	}
+	outputArgs = append(outputArgs, "-c", "copy")
+	source, err := sourceFFmpegRun(opt.Debug, []string{"-f", "mpegts", "-i", input.String()}, outputArgs, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return source, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+// srtTestFFmpeg skip test without ffmpeg srt support
+func srtTestFFmpeg(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not found")
+	}
+	protocols, err := exec.Command("ffmpeg", "-hide_banner", "-protocols").Output()
+	if err != nil || !strings.Contains(string(protocols), "srt") {
+		t.Skip("ffmpeg without srt")
+	}
+}
+
+// srtTestPort free local udp port
+func srtTestPort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestSourceSRTDialUnsupportedMode(t *testing.T) {
+	if _, err := SourceSRTDial(&ChannelST{URL: "srt://127.0.0.1:9000?mode=rendezvous"}); err != ErrorStreamSourceUnsupported {
+		t.Errorf("mode rendezvous = %v, want %v", err, ErrorStreamSourceUnsupported)
+	}
+}
+
+func TestSourceSRTLoopback(t *testing.T) {
+	srtTestFFmpeg(t)
+	previous := Storage
+	Storage = &StorageST{}
+	defer func() {
+		Storage = previous
+	}()
+	port := strconv.Itoa(srtTestPort(t))
+	// 인코더 역할 ffmpeg 가 listener source 로 push
+	encoder := exec.Command("ffmpeg", "-hide_banner", "-loglevel", "error", "-re",
+		"-f", "lavfi", "-i", "testsrc=size=320x240:rate=25", "-t", "10",
+		"-c:v", "libx264", "-preset", "ultrafast", "-g", "25", "-pix_fmt", "yuv420p",
+		"-f", "mpegts", "srt://127.0.0.1:"+port+"?mode=caller&latency=50000")
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		encoder.Run()
+	}()
+	defer func() {
+		if encoder.Process != nil {
+			encoder.Process.Kill()
+		}
+	}()
+	source, err := SourceSRTDial(&ChannelST{URL: "srt://:" + port + "?mode=listener&latency=50"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	codecs := source.CodecData()
+	if len(codecs) == 0 || codecs[0].Type() != av.H264 {
+		t.Fatalf("codecs %v, want h264", codecs)
+	}
+	// keyframe 과 증가하는 시간, 그리고 rtsp 재전송용 rtp
+	packetizer := NewRTSPPacketizer(codecs)
+	var keyFrame bool
+	var last time.Duration
+	timeout := time.After(10 * time.Second)
+	for packets := 0; packets < 50; packets++ {
+		select {
+		case packet := <-source.Packets():
+			if packet.Time < last {
+				t.Errorf("packet time %s before %s", packet.Time, last)
+			}
+			last = packet.Time
+			keyFrame = keyFrame || packet.IsKeyFrame
+			if len(packetizer.WritePacket(packet)) == 0 {
+				t.Error("packet without rtp")
+			}
+		case <-timeout:
+			t.Fatalf("%d packets before timeout", packets)
+		}
+	}
+	if !keyFrame {
+		t.Error("no keyframe in 50 packets")
+	}
+}
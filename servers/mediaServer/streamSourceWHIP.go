@@ -0,0 +1,394 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+// WHIP publish sessions
+var (
+	whipSessionsMutex sync.Mutex
+	whipSessions      = make(map[string]*SourceWHIP)
+)
+
+// SourceWHIP webrtc publish source (h264 + opus)
+type SourceWHIP struct {
+	ID        string
+	StreamID  string
+	ChannelID string
+	pc        *webrtc.PeerConnection
+	mutex     sync.RWMutex
+	codecs    []av.CodecData
+	waitCodec bool
+	hasAudio  bool // answer 에서 opus 수신이 협상됨
+	audioIdx  int8 // codecs 안의 audio index
+	sps       []byte
+	pps       []byte
+	packets   chan *av.Packet
+	signals   chan int
+	done      chan struct{}
+	closeOnce sync.Once
+	videoSSRC uint32    // atomic, viewer keyframe 요청 (pli) 대상
+	start     time.Time // 모든 track 의 첫 packet 도착 시각, audio / video pts 공통 기준
+}
+
+// webrtcNACKHistory viewer 마다 재전송용으로 보관하는 rtp packet 수 (2 의 거듭제곱)
+const webrtcNACKHistory = 256
+
+// newWebRTCPeerConnection peer connection with server ice and port options
+func newWebRTCPeerConnection(mediaEngine *webrtc.MediaEngine) (*webrtc.PeerConnection, error) {
+	configuration := webrtc.Configuration{}
+	if servers := Storage.ServerICEServers(); len(servers) > 0 {
+		configuration.ICEServers = append(configuration.ICEServers, webrtc.ICEServer{
+			URLs:           servers,
+			Username:       Storage.ServerICEUsername(),
+			Credential:     Storage.ServerICECredential(),
+			CredentialType: webrtc.ICECredentialTypePassword,
+		})
+	}
+	// default interceptor 와 같고 nack 재전송 history 만 작게 (viewer 마다 보관)
+	registry := &interceptor.Registry{}
+	generator, err := nack.NewGeneratorInterceptor()
+	if err != nil {
+		return nil, err
+	}
+	responder, err := nack.NewResponderInterceptor(nack.ResponderSize(webrtcNACKHistory))
+	if err != nil {
+		return nil, err
+	}
+	registry.Add(responder)
+	registry.Add(generator)
+	if err = webrtc.ConfigureRTCPReports(registry); err != nil {
+		return nil, err
+	}
+	if err = webrtc.ConfigureTWCCSender(mediaEngine, registry); err != nil {
+		return nil, err
+	}
+	settings := webrtc.SettingEngine{}
+	portMin, portMax := Storage.ServerWebRTCPortMin(), Storage.ServerWebRTCPortMax()
+	if portMin > 0 && portMax > portMin {
+		settings.SetEphemeralUDPPortRange(portMin, portMax)
+	}
+	if ip := Storage.ServerICEServerIP(); ip != "" {
+		settings.SetNAT1To1IPs([]string{ip}, webrtc.ICECandidateTypeHost)
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithInterceptorRegistry(registry), webrtc.WithSettingEngine(settings))
+	return api.NewPeerConnection(configuration)
+}
+
+// NewSourceWHIP accept whip offer and return source with sdp answer
+func NewSourceWHIP(streamID string, channelID string, offer string) (*SourceWHIP, string, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	videoFeedback := []webrtc.RTCPFeedback{{Type: "nack"}, {Type: "nack", Parameter: "pli"}, {Type: "ccm", Parameter: "fir"}}
+	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f", RTCPFeedback: videoFeedback},
+		PayloadType:        102,
+	}, webrtc.RTPCodecTypeVideo); err != nil {
+		return nil, "", err
+	}
+	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f", RTCPFeedback: videoFeedback},
+		PayloadType:        106,
+	}, webrtc.RTPCodecTypeVideo); err != nil {
+		return nil, "", err
+	}
+	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1"},
+		PayloadType:        111,
+	}, webrtc.RTPCodecTypeAudio); err != nil {
+		return nil, "", err
+	}
+	pc, err := newWebRTCPeerConnection(mediaEngine)
+	if err != nil {
+		return nil, "", err
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	source := &SourceWHIP{
+		ID:        hex.EncodeToString(id),
+		StreamID:  streamID,
+		ChannelID: channelID,
+		pc:        pc,
+		waitCodec: true,
+		packets:   make(chan *av.Packet, 1000),
+		signals:   make(chan int, 100),
+		done:      make(chan struct{}),
+	}
+	pc.OnTrack(source.trackLoop)
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateDisconnected, webrtc.PeerConnectionStateClosed:
+			source.stop()
+		}
+	})
+	if err = pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		pc.Close()
+		return nil, "", err
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		pc.Close()
+		return nil, "", err
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err = pc.SetLocalDescription(answer); err != nil {
+		pc.Close()
+		return nil, "", err
+	}
+	source.mutex.Lock()
+	source.hasAudio = whipAudioNegotiated(pc)
+	source.mutex.Unlock()
+	select {
+	case <-gatherComplete:
+	case <-time.After(5 * time.Second):
+	}
+	whipSessionsMutex.Lock()
+	whipSessions[source.ID] = source
+	whipSessionsMutex.Unlock()
+	return source, pc.LocalDescription().SDP, nil
+}
+
+// whipAudioNegotiated answer 에 opus 를 받는 audio transceiver 가 있는지
+func whipAudioNegotiated(pc *webrtc.PeerConnection) bool {
+	for _, transceiver := range pc.GetTransceivers() {
+		if transceiver.Kind() != webrtc.RTPCodecTypeAudio || transceiver.Receiver() == nil {
+			continue
+		}
+		switch transceiver.Direction() {
+		case webrtc.RTPTransceiverDirectionRecvonly, webrtc.RTPTransceiverDirectionSendrecv:
+			if len(transceiver.Receiver().GetParameters().Codecs) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SourceWHIPSession find whip session by id
+func SourceWHIPSession(id string) (*SourceWHIP, bool) {
+	whipSessionsMutex.Lock()
+	defer whipSessionsMutex.Unlock()
+	source, ok := whipSessions[id]
+	return source, ok
+}
+
+// trackLoop read remote track rtp to av packets
+func (source *SourceWHIP) trackLoop(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+	isVideo := track.Kind() == webrtc.RTPCodecTypeVideo
+	if isVideo {
+		atomic.StoreUint32(&source.videoSSRC, uint32(track.SSRC()))
+		// 처음 키프레임 빨리 받기 위해 codec 준비될때 까지 PLI 요청
+		go func() {
+			ticker := time.NewTicker(2 * time.Second)
+			defer ticker.Stop()
+			for {
+				source.pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())}})
+				select {
+				case <-source.done:
+					return
+				case <-ticker.C:
+					if !source.WaitCodec() {
+						return
+					}
+				}
+			}
+		}()
+	}
+	depacketizer := &codecs.H264Packet{IsAVC: true}
+	clockRate := time.Duration(track.Codec().ClockRate)
+	var started bool
+	var lastTS uint32
+	var pts time.Duration
+	var frame []byte
+	for {
+		packetRTP, _, err := track.ReadRTP()
+		if err != nil {
+			source.stop()
+			return
+		}
+		if !started {
+			// track 마다 rtp timestamp 기준이 달라 첫 pts 는 공통 기준에서 지난 시간
+			started = true
+			lastTS = packetRTP.Timestamp
+			pts = source.since(time.Now())
+		}
+		pts += time.Duration(int32(packetRTP.Timestamp-lastTS)) * time.Second / clockRate
+		lastTS = packetRTP.Timestamp
+		if !isVideo {
+			idx, ok := source.audioIndex()
+			if !ok || len(packetRTP.Payload) == 0 {
+				continue
+			}
+			source.writePacket(&av.Packet{Idx: idx, Data: append([]byte(nil), packetRTP.Payload...), Time: pts, Duration: 20 * time.Millisecond})
+			continue
+		}
+		nalus, err := depacketizer.Unmarshal(packetRTP.Payload)
+		if err != nil {
+			frame = nil
+			continue
+		}
+		frame = append(frame, nalus...)
+		if !packetRTP.Marker || len(frame) == 0 {
+			continue
+		}
+		source.writeVideo(frame, pts)
+		frame = nil
+	}
+}
+
+// since time from first packet of any track
+func (source *SourceWHIP) since(now time.Time) time.Duration {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	if source.start.IsZero() {
+		source.start = now
+	}
+	return now.Sub(source.start)
+}
+
+// writeVideo split avcc access unit, keep sps/pps as codec data
+func (source *SourceWHIP) writeVideo(frame []byte, pts time.Duration) {
+	var keyFrame bool
+	var out []byte
+	for len(frame) > 4 {
+		size := int(binary.BigEndian.Uint32(frame))
+		if size <= 0 || size > len(frame)-4 {
+			break
+		}
+		nalu := frame[4 : 4+size]
+		switch nalu[0] & 0x1f {
+		case h264parser.NALU_SPS:
+			source.sps = append([]byte(nil), nalu...)
+		case h264parser.NALU_PPS:
+			source.pps = append([]byte(nil), nalu...)
+		case h264parser.NALU_AUD:
+		default:
+			if nalu[0]&0x1f == 5 {
+				keyFrame = true
+			}
+			out = append(out, frame[:4+size]...)
+		}
+		frame = frame[4+size:]
+	}
+	if keyFrame && len(source.sps) > 0 && len(source.pps) > 0 {
+		source.codecUpdate()
+	}
+	if len(out) == 0 || source.WaitCodec() {
+		return
+	}
+	source.writePacket(&av.Packet{Idx: 0, Data: out, Time: pts, IsKeyFrame: keyFrame})
+}
+
+// codecUpdate make codec from sps/pps, signal if changed
+func (source *SourceWHIP) codecUpdate() {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	if len(source.codecs) > 0 {
+		if current, ok := source.codecs[0].(h264parser.CodecData); ok && string(current.SPS()) == string(source.sps) && string(current.PPS()) == string(source.pps) {
+			return
+		}
+	}
+	video, err := h264parser.NewCodecDataFromSPSAndPPS(source.sps, source.pps)
+	if err != nil {
+		return
+	}
+	codecData := []av.CodecData{video}
+	if source.hasAudio {
+		source.audioIdx = int8(len(codecData))
+		codecData = append(codecData, codec.NewOpusCodecData(48000, av.CH_STEREO))
+	}
+	source.codecs = codecData
+	source.waitCodec = false
+	select {
+	case source.signals <- SourceSignalCodecUpdate:
+	default:
+	}
+}
+
+// writePacket queue packet, drop if loop closed
+func (source *SourceWHIP) writePacket(packet *av.Packet) {
+	select {
+	case source.packets <- packet:
+	case <-source.done:
+	}
+}
+
+// stop signal core loop, core call Close
+func (source *SourceWHIP) stop() {
+	select {
+	case source.signals <- SourceSignalStop:
+	default:
+	}
+}
+
+// CodecData func
+func (source *SourceWHIP) CodecData() []av.CodecData {
+	source.mutex.RLock()
+	defer source.mutex.RUnlock()
+	return source.codecs
+}
+
+// SDP func
+func (source *SourceWHIP) SDP() []byte {
+	return nil
+}
+
+// audioIndex audio codec index, codec 준비 전이거나 audio 가 없으면 false
+func (source *SourceWHIP) audioIndex() (int8, bool) {
+	source.mutex.RLock()
+	defer source.mutex.RUnlock()
+	return source.audioIdx, source.hasAudio && !source.waitCodec
+}
+
+// WaitCodec func
+func (source *SourceWHIP) WaitCodec() bool {
+	source.mutex.RLock()
+	defer source.mutex.RUnlock()
+	return source.waitCodec
+}
+
+// Packets func
+func (source *SourceWHIP) Packets() <-chan *av.Packet {
+	return source.packets
+}
+
+// RTPPackets func
+func (source *SourceWHIP) RTPPackets() <-chan *[]byte {
+	return nil
+}
+
+// RequestKeyFrame send pli to publisher
+func (source *SourceWHIP) RequestKeyFrame() {
+	if ssrc := atomic.LoadUint32(&source.videoSSRC); ssrc != 0 {
+		source.pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: ssrc}})
+	}
+}
+
+// Signals func
+func (source *SourceWHIP) Signals() <-chan int {
+	return source.signals
+}
+
+// Close func
+func (source *SourceWHIP) Close() {
+	source.closeOnce.Do(func() {
+		close(source.done)
+		source.pc.Close()
+		whipSessionsMutex.Lock()
+		delete(whipSessions, source.ID)
+		whipSessionsMutex.Unlock()
+	})
+}
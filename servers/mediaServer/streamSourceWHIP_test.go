@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// whipTestOffer publisher offer with h264 video and audio of codec
+func whipTestOffer(t *testing.T, audio *webrtc.RTPCodecCapability) string {
+	t.Helper()
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f"},
+		PayloadType:        102,
+	}, webrtc.RTPCodecTypeVideo); err != nil {
+		t.Fatal(err)
+	}
+	if audio != nil {
+		if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{RTPCodecCapability: *audio, PayloadType: 111}, webrtc.RTPCodecTypeAudio); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pc, err := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pc.Close()
+	})
+	kinds := []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo}
+	if audio != nil {
+		kinds = append(kinds, webrtc.RTPCodecTypeAudio)
+	}
+	for _, kind := range kinds {
+		if _, err = pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return offer.SDP
+}
+
+func TestSourceWHIPAudioNegotiated(t *testing.T) {
+	previous := Storage
+	Storage = &StorageST{}
+	defer func() {
+		Storage = previous
+	}()
+	tests := []struct {
+		name  string
+		audio *webrtc.RTPCodecCapability
+		want  bool
+	}{
+		{"opus", &webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, true},
+		// m=audio 는 있지만 opus 가 없어서 거부된 section
+		{"pcmu only", &webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000}, false},
+		{"video only", nil, false},
+	}
+	for _, test := range tests {
+		source, _, err := NewSourceWHIP("whip-test", "0", whipTestOffer(t, test.audio))
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if _, ok := source.audioIndex(); ok {
+			t.Errorf("%s: audio index before codec", test.name)
+		}
+		if source.hasAudio != test.want {
+			t.Errorf("%s: audio %v, want %v", test.name, source.hasAudio, test.want)
+		}
+		source.Close()
+	}
+}
+
+func TestSourceWHIPSharedStart(t *testing.T) {
+	source := &SourceWHIP{}
+	now := time.Now()
+	// 먼저 도착한 track 이 기준, 늦게 시작한 track 은 그만큼 뒤의 pts 로 시작
+	if pts := source.since(now); pts != 0 {
+		t.Errorf("first track pts %s, want 0", pts)
+	}
+	if pts := source.since(now.Add(300 * time.Millisecond)); pts != 300*time.Millisecond {
+		t.Errorf("second track pts %s, want 300ms", pts)
+	}
+}
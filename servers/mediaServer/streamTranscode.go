@@ -0,0 +1,147 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+// streamTranscodeTimeout ffmpeg 첫 codec 대기
+const streamTranscodeTimeout = 10 * time.Second
+
+// streamTranscode h264 transcode of one channel (webrtc h265 fallback), viewers share one ffmpeg
+type streamTranscode struct {
+	source  *SourceDemux
+	codecs  []av.CodecData
+	clients map[string]chan *av.Packet
+	ready   chan struct{} // ffmpeg 시작 (또는 실패) 후 닫힘
+	err     error
+}
+
+// streamTranscodes running transcoders by stream/channel
+var streamTranscodes = struct {
+	mutex sync.Mutex
+	list  map[string]*streamTranscode
+}{list: make(map[string]*streamTranscode)}
+
+// StreamTranscodeH264Add subscribe h264 transcode of channel, start ffmpeg if first viewer
+// packet channel closed when ffmpeg exit
+func StreamTranscodeH264Add(streamID string, channelID string, remote string, user string) ([]av.CodecData, string, chan *av.Packet, error) {
+	cid, err := generateUUID()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	key := streamID + "/" + channelID
+	streamTranscodes.mutex.Lock()
+	transcode, ok := streamTranscodes.list[key]
+	if !ok {
+		// ffmpeg 시작은 lock 밖에서, 같은 채널 viewer 는 ready 를 기다림
+		transcode = &streamTranscode{clients: make(map[string]chan *av.Packet), ready: make(chan struct{})}
+		streamTranscodes.list[key] = transcode
+		streamTranscodes.mutex.Unlock()
+		input := rtspInternalURL(Storage.ServerRTSPPort(), streamID, channelID)
+		source, err := sourceFFmpegRun(false, []string{"-rtsp_transport", "tcp", "-user_agent", rtspInternalAgent, "-i", input},
+			[]string{"-map", "0:v:0", "-c:v", "libx264", "-preset", "ultrafast", "-tune", "zerolatency", "-bf", "0", "-an"}, streamTranscodeTimeout)
+		streamTranscodes.mutex.Lock()
+		if err != nil {
+			transcode.err = err
+			delete(streamTranscodes.list, key)
+			close(transcode.ready)
+			streamTranscodes.mutex.Unlock()
+			log.Printf("[ERROR] [core] [StreamTranscodeH264Add] [sourceFFmpegRun] stream=%s channel=%s: %s", streamID, channelID, err.Error())
+			return nil, "", nil, err
+		}
+		transcode.source = source
+		transcode.codecs = source.CodecData()
+		close(transcode.ready)
+		go transcode.run(key)
+		log.Printf("[INFO] [core] [StreamTranscodeH264Add] Transcode start: stream=%s channel=%s", streamID, channelID)
+	} else {
+		streamTranscodes.mutex.Unlock()
+		<-transcode.ready
+		streamTranscodes.mutex.Lock()
+		if transcode.err != nil {
+			streamTranscodes.mutex.Unlock()
+			return nil, "", nil, transcode.err
+		}
+		if streamTranscodes.list[key] != transcode {
+			streamTranscodes.mutex.Unlock()
+			return nil, "", nil, ErrorTranscodeStopped
+		}
+	}
+	defer streamTranscodes.mutex.Unlock()
+	ch := make(chan *av.Packet, 2000)
+	transcode.clients[cid] = ch
+	Sessions.Add(&SessionST{ID: cid, StreamID: streamID, ChannelID: channelID, Protocol: SessionWebRTC, Remote: remote, User: user, detached: true, kick: func() {
+		streamTranscodeKick(key, cid)
+	}})
+	return transcode.codecs, cid, ch, nil
+}
+
+// StreamTranscodeH264Delete unsubscribe, last viewer stop ffmpeg
+func StreamTranscodeH264Delete(streamID string, channelID string, cid string) {
+	key := streamID + "/" + channelID
+	streamTranscodes.mutex.Lock()
+	defer streamTranscodes.mutex.Unlock()
+	transcode, ok := streamTranscodes.list[key]
+	if !ok || transcode.source == nil {
+		return
+	}
+	delete(transcode.clients, cid)
+	Sessions.Delete(cid)
+	if len(transcode.clients) == 0 {
+		delete(streamTranscodes.list, key)
+		transcode.source.Close()
+		log.Printf("[INFO] [core] [StreamTranscodeH264Delete] Transcode stop: stream=%s channel=%s", streamID, channelID)
+	}
+}
+
+// streamTranscodeKick close viewer queue, viewer handler call StreamTranscodeH264Delete on exit
+func streamTranscodeKick(key string, cid string) {
+	streamTranscodes.mutex.Lock()
+	defer streamTranscodes.mutex.Unlock()
+	if transcode, ok := streamTranscodes.list[key]; ok {
+		if ch, ok := transcode.clients[cid]; ok {
+			close(ch)
+			delete(transcode.clients, cid)
+		}
+	}
+	Sessions.Delete(cid)
+}
+
+// run fan out transcoded packets until ffmpeg exit or closed
+func (transcode *streamTranscode) run(key string) {
+	defer func() {
+		streamTranscodes.mutex.Lock()
+		if streamTranscodes.list[key] == transcode {
+			delete(streamTranscodes.list, key)
+		}
+		for cid, ch := range transcode.clients {
+			close(ch)
+			delete(transcode.clients, cid)
+			Sessions.Delete(cid)
+		}
+		streamTranscodes.mutex.Unlock()
+		transcode.source.Close()
+	}()
+	for {
+		select {
+		case <-transcode.source.Done():
+			return
+		case signal := <-transcode.source.Signals():
+			if signal == SourceSignalStop {
+				return
+			}
+		case packet := <-transcode.source.Packets():
+			streamTranscodes.mutex.Lock()
+			for _, ch := range transcode.clients {
+				if len(ch) < cap(ch) {
+					ch <- packet
+				}
+			}
+			streamTranscodes.mutex.Unlock()
+		}
+	}
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+const (
+	// webrtcTrickleGather whep answer 전 host candidate 대기, 나머지는 PATCH 응답으로 trickle
+	webrtcTrickleGather = 500 * time.Millisecond
+	// webrtcConnectTimeout ice 연결 대기
+	webrtcConnectTimeout = 20 * time.Second
+)
+
+// WebRTC playback sessions (whep)
+var (
+	webrtcSessionsMutex sync.Mutex
+	webrtcSessions      = make(map[string]*WebRTCSession)
+)
+
+// WebRTCSession webrtc viewer of one channel, client 등록은 ice 연결 후
+type WebRTCSession struct {
+	ID          string
+	StreamID    string
+	ChannelID   string
+	remote      string
+	user        string
+	transcode   bool // h265 채널, ffmpeg h264 변환 공유
+	pc          *webrtc.PeerConnection
+	video       *webrtc.TrackLocalStaticSample
+	audio       *webrtc.TrackLocalStaticSample
+	mutex       sync.Mutex
+	answered    bool
+	candidates  []string // answer 이후 gather 된 server candidate
+	gathered    bool     // end-of-candidates 아직 안 보냄
+	cid         string   // channel client id, 연결 후
+	stats       SessionRTCPST
+	keyFrame    chan struct{} // viewer pli / fir
+	connected   chan struct{}
+	done        chan struct{}
+	connectOnce sync.Once
+	closeOnce   sync.Once
+}
+
+// webrtcAudioMime webrtc audio mime of codec, 지원 안 하면 ""
+func webrtcAudioMime(codec av.CodecData) string {
+	switch codec.Type() {
+	case av.OPUS:
+		return webrtc.MimeTypeOpus
+	case av.PCM_ALAW:
+		return webrtc.MimeTypePCMA
+	case av.PCM_MULAW:
+		return webrtc.MimeTypePCMU
+	}
+	return ""
+}
+
+// NewWebRTCSession answer offer after gatherWait, client 등록과 전송은 ice 연결 후 시작
+func NewWebRTCSession(streamID string, channelID string, codecs []av.CodecData, offer string, remote string, user string, gatherWait time.Duration) (*WebRTCSession, string, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		return nil, "", err
+	}
+	pc, err := newWebRTCPeerConnection(mediaEngine)
+	if err != nil {
+		return nil, "", err
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	session := &WebRTCSession{
+		ID:        hex.EncodeToString(id),
+		StreamID:  streamID,
+		ChannelID: channelID,
+		remote:    remote,
+		user:      user,
+		transcode: CodecHasH265(codecs),
+		pc:        pc,
+		keyFrame:  make(chan struct{}, 1),
+		connected: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	// transcode 는 video (h264) 만
+	for _, codec := range codecs {
+		switch {
+		case codec.Type().IsVideo() && session.video == nil:
+			session.video, err = webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", session.ID)
+		case codec.Type().IsAudio() && session.audio == nil && !session.transcode && webrtcAudioMime(codec) != "":
+			audio := codec.(av.AudioCodecData)
+			session.audio, err = webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtcAudioMime(codec), ClockRate: uint32(audio.SampleRate()), Channels: uint16(audio.ChannelLayout().Count())}, "audio", session.ID)
+		}
+		if err != nil {
+			pc.Close()
+			return nil, "", err
+		}
+	}
+	if session.video == nil && session.audio == nil {
+		pc.Close()
+		return nil, "", ErrorWebRTCNoTrack
+	}
+	for _, track := range []*webrtc.TrackLocalStaticSample{session.video, session.audio} {
+		if track == nil {
+			continue
+		}
+		sender, err := pc.AddTrack(track)
+		if err != nil {
+			pc.Close()
+			return nil, "", err
+		}
+		go session.rtcpLoop(sender, track == session.video)
+	}
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		session.mutex.Lock()
+		defer session.mutex.Unlock()
+		if !session.answered {
+			return
+		}
+		if candidate == nil {
+			session.gathered = true
+			return
+		}
+		session.candidates = append(session.candidates, candidate.ToJSON().Candidate)
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			session.connectOnce.Do(func() {
+				close(session.connected)
+			})
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			go session.Close()
+		}
+	})
+	// 연결 실패로 gather 대기 중에 Close 될 수 있으므로 먼저 등록, 이후 실패는 Close 로 정리
+	webrtcSessionsMutex.Lock()
+	webrtcSessions[session.ID] = session
+	webrtcSessionsMutex.Unlock()
+	if err = pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		session.Close()
+		return nil, "", err
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		session.Close()
+		return nil, "", err
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err = pc.SetLocalDescription(answer); err != nil {
+		session.Close()
+		return nil, "", err
+	}
+	select {
+	case <-gatherComplete:
+	case <-time.After(gatherWait):
+	case <-session.done:
+		return nil, "", ErrorWebRTCSessionClosed
+	}
+	session.mutex.Lock()
+	sdp := pc.LocalDescription().SDP
+	session.answered = true
+	session.mutex.Unlock()
+	go session.run()
+	return session, sdp, nil
+}
+
+// WebRTCSessionFind find webrtc session by id
+func WebRTCSessionFind(id string) (*WebRTCSession, bool) {
+	webrtcSessionsMutex.Lock()
+	defer webrtcSessionsMutex.Unlock()
+	session, ok := webrtcSessions[id]
+	return session, ok
+}
+
+// rtcpLoop read rtcp of sender, nack 재전송은 interceptor 가 하고 여기서는 통계와 keyframe 요청
+func (session *WebRTCSession) rtcpLoop(sender *webrtc.RTPSender, video bool) {
+	var ssrc uint32
+	if encodings := sender.GetParameters().Encodings; len(encodings) > 0 {
+		ssrc = uint32(encodings[0].SSRC)
+	}
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		if video {
+			session.rtcp(packets, ssrc)
+		}
+	}
+}
+
+// rtcp count nack / pli / fir and read receiver report of video ssrc
+func (session *WebRTCSession) rtcp(packets []rtcp.Packet, ssrc uint32) {
+	var keyFrame bool
+	session.mutex.Lock()
+	for _, packet := range packets {
+		switch packet := packet.(type) {
+		case *rtcp.PictureLossIndication:
+			session.stats.PLI++
+			keyFrame = true
+		case *rtcp.FullIntraRequest:
+			session.stats.FIR++
+			keyFrame = true
+		case *rtcp.TransportLayerNack:
+			for _, pair := range packet.Nacks {
+				session.stats.NACK += uint64(len(pair.PacketList()))
+			}
+		case *rtcp.ReceiverReport:
+			for _, report := range packet.Reports {
+				if report.SSRC == ssrc {
+					session.receiverReport(report)
+				}
+			}
+		}
+	}
+	session.stats.Updated = time.Now()
+	stats, cid := session.stats, session.cid
+	session.mutex.Unlock()
+	if cid != "" {
+		Sessions.RTCP(cid, stats)
+	}
+	if keyFrame {
+		select {
+		case session.keyFrame <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// receiverReport loss, jitter (90kHz) and rtt from lsr / dlsr, lock 안에서 호출
+func (session *WebRTCSession) receiverReport(report rtcp.ReceptionReport) {
+	// total lost 는 24bit signed
+	lost := int64(report.TotalLost)
+	if lost&0x800000 != 0 {
+		lost -= 1 << 24
+	}
+	session.stats.PacketsLost = lost
+	session.stats.FractionLost = float64(report.FractionLost) / 256
+	session.stats.JitterMs = float64(report.Jitter) / 90
+	if report.LastSenderReport != 0 {
+		if rtt := webrtcNTPMiddle(time.Now()) - report.LastSenderReport - report.Delay; int32(rtt) >= 0 {
+			session.stats.RTTMs = float64(rtt) * 1000 / 65536
+		}
+	}
+}
+
+// webrtcNTPMiddle middle 32 bits of ntp time (1/65536 초), sender report lsr 와 같은 단위
+func webrtcNTPMiddle(t time.Time) uint32 {
+	seconds := uint64(t.Unix()) + 2208988800
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return uint32((seconds<<32 | fraction) >> 16)
+}
+
+// Trickle add client candidates of sdp fragment, answer 이후 server candidate fragment 반환 (없으면 "")
+func (session *WebRTCSession) Trickle(fragment string) (string, error) {
+	remoteUfrag := webrtcSDPAttribute(session.pc.RemoteDescription().SDP, "ice-ufrag")
+	transceivers := session.pc.GetTransceivers()
+	if len(transceivers) == 0 {
+		return "", ErrorWebRTCNoTrack
+	}
+	mid := transceivers[0].Mid()
+	var candidates []webrtc.ICECandidateInit
+	for _, line := range strings.Split(fragment, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "a=ice-ufrag:"):
+			// ufrag 가 바뀌면 ice restart
+			if strings.TrimPrefix(line, "a=ice-ufrag:") != remoteUfrag {
+				return "", ErrorWHEPICERestart
+			}
+		case strings.HasPrefix(line, "a=mid:"):
+			mid = strings.TrimPrefix(line, "a=mid:")
+		case strings.HasPrefix(line, "a=candidate:"):
+			candidateMid := mid
+			candidates = append(candidates, webrtc.ICECandidateInit{Candidate: strings.TrimPrefix(line, "a="), SDPMid: &candidateMid})
+		}
+	}
+	for _, candidate := range candidates {
+		if err := session.pc.AddICECandidate(candidate); err != nil {
+			return "", err
+		}
+	}
+	return session.localFragment(), nil
+}
+
+// localFragment server candidates not sent yet as trickle-ice-sdpfrag
+func (session *WebRTCSession) localFragment() string {
+	session.mutex.Lock()
+	candidates, gathered := session.candidates, session.gathered
+	session.candidates, session.gathered = nil, false
+	session.mutex.Unlock()
+	if len(candidates) == 0 && !gathered {
+		return ""
+	}
+	local := session.pc.LocalDescription().SDP
+	transceiver := session.pc.GetTransceivers()[0]
+	var fragment strings.Builder
+	fragment.WriteString("a=ice-ufrag:" + webrtcSDPAttribute(local, "ice-ufrag") + "\r\n")
+	fragment.WriteString("a=ice-pwd:" + webrtcSDPAttribute(local, "ice-pwd") + "\r\n")
+	fragment.WriteString("m=" + transceiver.Kind().String() + " 9 UDP/TLS/RTP/SAVPF 0\r\n")
+	fragment.WriteString("a=mid:" + transceiver.Mid() + "\r\n")
+	for _, candidate := range candidates {
+		fragment.WriteString("a=" + candidate + "\r\n")
+	}
+	if gathered {
+		fragment.WriteString("a=end-of-candidates\r\n")
+	}
+	return fragment.String()
+}
+
+// webrtcSDPAttribute first a=<name>: value of sdp
+func webrtcSDPAttribute(sdp string, name string) string {
+	for _, line := range strings.Split(sdp, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), "a="+name+":"); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// clientAdd register viewer (h265 은 transcode), 현재 codec 과 client queue 반환
+func (session *WebRTCSession) clientAdd() ([]av.CodecData, string, chan *av.Packet, func(), error) {
+	if session.transcode {
+		codecs, cid, ch, err := StreamTranscodeH264Add(session.StreamID, session.ChannelID, session.remote, session.user)
+		if err != nil {
+			return nil, "", nil, nil, err
+		}
+		return codecs, cid, ch, func() {
+			StreamTranscodeH264Delete(session.StreamID, session.ChannelID, cid)
+		}, nil
+	}
+	cid, ch, _, err := Storage.ClientAdd(session.StreamID, session.ChannelID, WEBRTC, session.remote, session.user, false)
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+	clientDelete := func() {
+		Storage.ClientDelete(session.StreamID, cid, session.ChannelID)
+	}
+	codecs, err := Storage.StreamChannelCodecs(session.StreamID, session.ChannelID)
+	if err != nil {
+		clientDelete()
+		return nil, "", nil, nil, err
+	}
+	return codecs, cid, ch, clientDelete, nil
+}
+
+// run wait ice connected, register client and send packets until close
+func (session *WebRTCSession) run() {
+	defer session.Close()
+	select {
+	case <-session.connected:
+	case <-session.done:
+		return
+	case <-time.After(webrtcConnectTimeout):
+		log.Printf("[ERROR] [http_webrtc] [WebRTCSession] [connect] stream=%s channel=%s session=%s: ice connect timeout", session.StreamID, session.ChannelID, session.ID)
+		return
+	}
+	codecs, cid, ch, clientDelete, err := session.clientAdd()
+	if err != nil {
+		log.Printf("[ERROR] [http_webrtc] [WebRTCSession] [clientAdd] stream=%s channel=%s session=%s: %s", session.StreamID, session.ChannelID, session.ID, err.Error())
+		return
+	}
+	defer clientDelete()
+	session.mutex.Lock()
+	session.cid = cid
+	session.mutex.Unlock()
+	log.Printf("[INFO] [http_webrtc] [WebRTCSession] play start: stream=%s channel=%s session=%s remote=%s", session.StreamID, session.ChannelID, session.ID, session.remote)
+	last := make(map[int8]time.Duration)
+	var videoStart bool
+	// keyframe 요청으로 다시 보낸 GOP 의 마지막 시간, queue 에 남은 같은 packet 은 건너뜀
+	var replayed bool
+	var replayIdx int8
+	var replayTime time.Duration
+	var keyFrameSent time.Time
+	noVideo := time.NewTimer(20 * time.Second)
+	defer noVideo.Stop()
+	for {
+		select {
+		case <-session.done:
+			return
+		case <-noVideo.C:
+			log.Printf("[ERROR] [http_webrtc] [WebRTCSession] [ErrorStreamNoVideo] stream=%s channel=%s session=%s: %s", session.StreamID, session.ChannelID, session.ID, ErrorStreamNoVideo.Error())
+			return
+		case <-session.keyFrame:
+			// transcode 는 h265 GOP 라 보낼 수 없고 ffmpeg 다음 keyframe 을 기다린다
+			if session.transcode || !videoStart || time.Since(keyFrameSent) < streamKeyFrameInterval {
+				continue
+			}
+			keyFrameSent = time.Now()
+			Storage.StreamChannelKeyFrameRequest(session.StreamID, session.ChannelID)
+			// 캐시된 GOP 를 압축된 시간으로 다시 보내 live 시점 화면을 바로 복구
+			gop := Storage.StreamChannelGOP(session.StreamID, session.ChannelID)
+			for _, packet := range gop {
+				if int(packet.Idx) >= len(codecs) {
+					break
+				}
+				if err = session.writePacket(codecs[packet.Idx], packet, gopReplayStep); err != nil {
+					log.Printf("[ERROR] [http_webrtc] [WebRTCSession] [WritePacket] stream=%s channel=%s session=%s: %s", session.StreamID, session.ChannelID, session.ID, err.Error())
+					return
+				}
+			}
+			if len(gop) > 0 {
+				replayed, replayIdx, replayTime = true, gop[0].Idx, gop[len(gop)-1].Time
+				last[replayIdx] = replayTime
+			}
+		case packet, ok := <-ch:
+			if !ok {
+				log.Printf("[ERROR] [http_webrtc] [WebRTCSession] [ClientQueue] stream=%s channel=%s session=%s: queue closed (slow client or transcode stopped)", session.StreamID, session.ChannelID, session.ID)
+				return
+			}
+			if int(packet.Idx) >= len(codecs) {
+				continue
+			}
+			if replayed && packet.Idx == replayIdx {
+				if packet.Time <= replayTime {
+					continue
+				}
+				replayed = false
+			}
+			if packet.IsKeyFrame {
+				noVideo.Reset(10 * time.Second)
+				videoStart = true
+			}
+			if !videoStart {
+				continue
+			}
+			// 이전 packet 과의 간격을 sample 길이로
+			duration := packet.Duration
+			if previous, ok := last[packet.Idx]; ok && packet.Time > previous {
+				duration = packet.Time - previous
+			}
+			last[packet.Idx] = packet.Time
+			if err = session.writePacket(codecs[packet.Idx], packet, duration); err != nil {
+				log.Printf("[ERROR] [http_webrtc] [WebRTCSession] [WritePacket] stream=%s channel=%s session=%s: %s", session.StreamID, session.ChannelID, session.ID, err.Error())
+				return
+			}
+			Sessions.Sent(cid, len(packet.Data))
+		}
+	}
+}
+
+// writePacket write packet as sample, h264 keyframe 에는 sps / pps 추가
+func (session *WebRTCSession) writePacket(codec av.CodecData, packet *av.Packet, duration time.Duration) error {
+	switch {
+	case codec.Type() == av.H264 && session.video != nil:
+		nalus, _ := h264parser.SplitNALUs(packet.Data)
+		if len(nalus) == 0 {
+			return nil
+		}
+		if packet.IsKeyFrame && nalus[0][0]&0x1f != h264parser.NALU_SPS {
+			if h264, ok := codec.(h264parser.CodecData); ok {
+				nalus = append([][]byte{h264.SPS(), h264.PPS()}, nalus...)
+			}
+		}
+		data := append([]byte{0, 0, 0, 1}, bytes.Join(nalus, []byte{0, 0, 0, 1})...)
+		return session.video.WriteSample(media.Sample{Data: data, Duration: duration})
+	case codec.Type().IsAudio() && session.audio != nil && webrtcAudioMime(codec) != "":
+		return session.audio.WriteSample(media.Sample{Data: packet.Data, Duration: duration})
+	}
+	return nil
+}
+
+// Close func
+func (session *WebRTCSession) Close() {
+	session.closeOnce.Do(func() {
+		close(session.done)
+		session.pc.Close()
+		webrtcSessionsMutex.Lock()
+		delete(webrtcSessions, session.ID)
+		webrtcSessionsMutex.Unlock()
+		log.Printf("[INFO] [http_webrtc] [WebRTCSession] play stop: stream=%s channel=%s session=%s", session.StreamID, session.ChannelID, session.ID)
+	})
+}
@@ -14,6 +14,7 @@ const (
 	SignalStreamRestart = iota ///< Y   Restart
 	SignalStreamStop
 	SignalStreamClient
+	SignalStreamKeyFrame // viewer 가 keyframe 요청 (pli / fir)
 )
 
 // generateUUID function make random uuid for clients and stream
@@ -87,3 +88,12 @@ func extractFirstIPFromICEServers(iceServers []string) string {
 
 	return ""
 }
+
+// bearerToken token of "Authorization: Bearer <token>", scheme 대소문자 무시, 다른 scheme 이면 ""
+func bearerToken(header string) string {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
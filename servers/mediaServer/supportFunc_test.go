@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestBearerToken(t *testing.T) {
+	for header, want := range map[string]string{
+		"Bearer abc":      "abc",
+		"bearer abc":      "abc",
+		"BEARER  abc ":    "abc",
+		"Basic dXNlcjpw":  "",
+		"abc":             "",
+		"":                "",
+		"Bearerabc":       "",
+		"Digest username": "",
+	} {
+		if got := bearerToken(header); got != want {
+			t.Errorf("bearerToken(%q) = %q, want %q", header, got, want)
+		}
+	}
+}